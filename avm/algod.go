@@ -0,0 +1,242 @@
+package avm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/metrics"
+
+	"github.com/algorand/go-algorand-sdk/v2/client/v2/algod"
+	sdk_models "github.com/algorand/go-algorand-sdk/v2/client/v2/common/models"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// Algod is the part of the algod API used by Client.
+// NewAlgod adapts an *algod.Client to it, and the fakealgod package implements it
+// in-process for offline testing
+type Algod interface {
+	Status(ctx context.Context) (sdk_models.NodeStatus, error)
+	// StatusAfterBlock waits for the block after round
+	StatusAfterBlock(ctx context.Context, round uint64) (sdk_models.NodeStatus, error)
+	SuggestedParams(ctx context.Context) (types.SuggestedParams, error)
+	AccountInformation(ctx context.Context, address string) (sdk_models.Account, error)
+	// SendRawTransaction sends a msgpack encoded signed txn group, returning the id of the
+	// first txn
+	SendRawTransaction(ctx context.Context, signedGroup []byte) (string, error)
+	// SimulateTransaction evaluates the txn groups of request against the latest round
+	// without committing them
+	SimulateTransaction(ctx context.Context, request sdk_models.SimulateRequest,
+	) (sdk_models.SimulateResponse, error)
+	PendingTransactionInformation(ctx context.Context, txnId string,
+	) (sdk_models.PendingTransactionInfoResponse, error)
+	// Block returns the block of round
+	Block(ctx context.Context, round uint64) (types.Block, error)
+	// TealCompile compiles teal, returning also its source map if sourcemap is true
+	TealCompile(ctx context.Context, teal []byte, sourcemap bool,
+	) (sdk_models.CompileResponse, error)
+	// GetApplicationBoxByName returns the box of app appId with the given name, failing
+	// with ErrBoxNotFound if it does not exist
+	GetApplicationBoxByName(ctx context.Context, appId uint64, name []byte,
+	) (sdk_models.Box, error)
+}
+
+// ErrBoxNotFound is returned by Algod.GetApplicationBoxByName if the box does not exist
+var ErrBoxNotFound = errors.New("box not found")
+
+// algodClient adapts an *algod.Client to the Algod interface
+type algodClient struct {
+	c *algod.Client
+}
+
+// NewAlgod returns the Algod backed by the algod node of c
+func NewAlgod(c *algod.Client) Algod {
+	return &algodClient{c: c}
+}
+
+func (a *algodClient) Status(ctx context.Context) (sdk_models.NodeStatus, error) {
+	return a.c.Status().Do(ctx)
+}
+
+func (a *algodClient) StatusAfterBlock(ctx context.Context, round uint64,
+) (sdk_models.NodeStatus, error) {
+	return a.c.StatusAfterBlock(round).Do(ctx)
+}
+
+func (a *algodClient) SuggestedParams(ctx context.Context) (types.SuggestedParams, error) {
+	return a.c.SuggestedParams().Do(ctx)
+}
+
+func (a *algodClient) AccountInformation(ctx context.Context, address string,
+) (sdk_models.Account, error) {
+	return a.c.AccountInformation(address).Do(ctx)
+}
+
+func (a *algodClient) SendRawTransaction(ctx context.Context, signedGroup []byte,
+) (string, error) {
+	return a.c.SendRawTransaction(signedGroup).Do(ctx)
+}
+
+func (a *algodClient) SimulateTransaction(ctx context.Context,
+	request sdk_models.SimulateRequest) (sdk_models.SimulateResponse, error) {
+	return a.c.SimulateTransaction(request).Do(ctx)
+}
+
+func (a *algodClient) PendingTransactionInformation(ctx context.Context, txnId string,
+) (sdk_models.PendingTransactionInfoResponse, error) {
+	info, _, err := a.c.PendingTransactionInformation(txnId).Do(ctx)
+	return info, err
+}
+
+func (a *algodClient) Block(ctx context.Context, round uint64) (types.Block, error) {
+	return a.c.Block(round).Do(ctx)
+}
+
+func (a *algodClient) TealCompile(ctx context.Context, teal []byte, sourcemap bool,
+) (sdk_models.CompileResponse, error) {
+	return a.c.TealCompile(teal).Sourcemap(sourcemap).Do(ctx)
+}
+
+func (a *algodClient) GetApplicationBoxByName(ctx context.Context, appId uint64,
+	name []byte) (sdk_models.Box, error) {
+	box, err := a.c.GetApplicationBoxByName(appId, name).Do(ctx)
+	// the sdk NotFound error type can't be told apart from other errors, so we check the
+	// HTTP status in the message
+	if err != nil && strings.HasPrefix(err.Error(), "HTTP 404") {
+		return box, ErrBoxNotFound
+	}
+	return box, err
+}
+
+// instrumentedAlgod wraps an Algod recording the latency and errors of each call
+type instrumentedAlgod struct {
+	a Algod
+}
+
+// instrumentAlgod wraps a with the algod call metrics
+func instrumentAlgod(a Algod) Algod {
+	return &instrumentedAlgod{a: a}
+}
+
+// observe records a call to method started at start which returned err
+func (i *instrumentedAlgod) observe(method string, start time.Time, err error) {
+	metrics.AlgodDuration.ObserveSince(start, method)
+	if err != nil {
+		metrics.AlgodErrors.Inc(method)
+	}
+}
+
+func (i *instrumentedAlgod) Status(ctx context.Context) (sdk_models.NodeStatus, error) {
+	start := time.Now()
+	status, err := i.a.Status(ctx)
+	i.observe("Status", start, err)
+	return status, err
+}
+
+func (i *instrumentedAlgod) StatusAfterBlock(ctx context.Context, round uint64,
+) (sdk_models.NodeStatus, error) {
+	start := time.Now()
+	status, err := i.a.StatusAfterBlock(ctx, round)
+	i.observe("StatusAfterBlock", start, err)
+	return status, err
+}
+
+func (i *instrumentedAlgod) SuggestedParams(ctx context.Context,
+) (types.SuggestedParams, error) {
+	start := time.Now()
+	sp, err := i.a.SuggestedParams(ctx)
+	i.observe("SuggestedParams", start, err)
+	return sp, err
+}
+
+func (i *instrumentedAlgod) AccountInformation(ctx context.Context, address string,
+) (sdk_models.Account, error) {
+	start := time.Now()
+	account, err := i.a.AccountInformation(ctx, address)
+	i.observe("AccountInformation", start, err)
+	return account, err
+}
+
+func (i *instrumentedAlgod) SendRawTransaction(ctx context.Context, signedGroup []byte,
+) (string, error) {
+	start := time.Now()
+	txnId, err := i.a.SendRawTransaction(ctx, signedGroup)
+	i.observe("SendRawTransaction", start, err)
+	return txnId, err
+}
+
+func (i *instrumentedAlgod) SimulateTransaction(ctx context.Context,
+	request sdk_models.SimulateRequest) (sdk_models.SimulateResponse, error) {
+	start := time.Now()
+	result, err := i.a.SimulateTransaction(ctx, request)
+	i.observe("SimulateTransaction", start, err)
+	return result, err
+}
+
+func (i *instrumentedAlgod) PendingTransactionInformation(ctx context.Context,
+	txnId string) (sdk_models.PendingTransactionInfoResponse, error) {
+	start := time.Now()
+	info, err := i.a.PendingTransactionInformation(ctx, txnId)
+	i.observe("PendingTransactionInformation", start, err)
+	return info, err
+}
+
+func (i *instrumentedAlgod) Block(ctx context.Context, round uint64) (types.Block, error) {
+	start := time.Now()
+	block, err := i.a.Block(ctx, round)
+	i.observe("Block", start, err)
+	return block, err
+}
+
+func (i *instrumentedAlgod) TealCompile(ctx context.Context, teal []byte, sourcemap bool,
+) (sdk_models.CompileResponse, error) {
+	start := time.Now()
+	result, err := i.a.TealCompile(ctx, teal, sourcemap)
+	i.observe("TealCompile", start, err)
+	return result, err
+}
+
+func (i *instrumentedAlgod) GetApplicationBoxByName(ctx context.Context, appId uint64,
+	name []byte) (sdk_models.Box, error) {
+	start := time.Now()
+	box, err := i.a.GetApplicationBoxByName(ctx, appId, name)
+	observed := err
+	if errors.Is(err, ErrBoxNotFound) {
+		observed = nil // a missing box is an answer, not a failure
+	}
+	i.observe("GetApplicationBoxByName", start, observed)
+	return box, err
+}
+
+// waitForConfirmation waits for txnId to be confirmed for up to waitRounds rounds, like
+// transaction.WaitForConfirmation from the sdk but for any Algod, returning the same
+// errors which parseWaitForConfirmationError expects
+func waitForConfirmation(ctx context.Context, algod Algod, txnId string, waitRounds uint64,
+) (sdk_models.PendingTransactionInfoResponse, error) {
+	var txnInfo sdk_models.PendingTransactionInfoResponse
+	status, err := algod.Status(ctx)
+	if err != nil {
+		return txnInfo, err
+	}
+	lastRound := status.LastRound
+	for currentRound := lastRound + 1; ; currentRound++ {
+		if currentRound > lastRound+waitRounds {
+			return txnInfo, fmt.Errorf("Wait for transaction id %s timed out", txnId)
+		}
+		txnInfo, err = algod.PendingTransactionInformation(ctx, txnId)
+		// errors are ignored since a node behind a load balancer may not have the txn yet
+		if err == nil {
+			if txnInfo.PoolError != "" {
+				return txnInfo, fmt.Errorf("Transaction rejected: %s", txnInfo.PoolError)
+			}
+			if txnInfo.ConfirmedRound > 0 {
+				return txnInfo, nil
+			}
+		}
+		if _, err = algod.StatusAfterBlock(ctx, currentRound); err != nil {
+			return txnInfo, err
+		}
+	}
+}
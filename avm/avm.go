@@ -4,13 +4,17 @@ package avm
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/zkp"
 
 	"github.com/algorand/go-algorand-sdk/v2/abi"
 	"github.com/algorand/go-algorand-sdk/v2/client/v2/algod"
@@ -21,71 +25,136 @@ type algodConfig struct {
 	Token string
 }
 
-var client *algod.Client
+// Client interacts with the app onchain through an algod node.
+// It also keeps the in-memory merkle tree of the app, synced from the txns database
+type Client struct {
+	algod Algod
+	App   *models.App
+	// MinimumBalance is the minimum balance required for an Algorand account in microAlgos
+	MinimumBalance uint64
+	tree           *merkleTree
+	prover         *zkp.Prover
+	// the approval program with its source map, to explain the failing pcs
+	approvalMu sync.Mutex
+	approval   *programSource
+	// the size of the last deposit group, whose fee is quoted to deposit whole balances
+	depositSizeMu sync.Mutex
+	depositSize   *groupSize
+}
+
+// NewClient returns a client for app using algodClient, with the merkle tree synced
+// from txns and generating the zk proofs with prover.
+// It reads the minimum balance from algod, failing after 3 attempts.
+// The algod calls are recorded in the metrics
+func NewClient(algodClient Algod, app *models.App, txns db.TxnsReader, prover *zkp.Prover,
+) (*Client, error) {
+	c := &Client{
+		algod:  instrumentAlgod(algodClient),
+		App:    app,
+		tree:   newMerkleTree(txns, app.TreeConfig.ZeroHashes),
+		prover: prover,
+	}
+	var err error
+	c.MinimumBalance, err = c.getMinimumBalance()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
 
-func init() {
-	if config.AlgodPath == "" {
-		client = devnetAlgodClient()
-		return
+// NewAlgodClient returns an algod client for algodPath, either the URL of the node,
+// used with algodToken, or the data directory of a local node.
+// If algodPath is empty it returns a client for the local devnet
+func NewAlgodClient(algodPath, algodToken string) (*algod.Client, error) {
+	if algodPath == "" {
+		return devnetAlgodClient(), nil
 	}
 
 	var err error
 	conf := &algodConfig{}
 
-	if strings.Contains(config.AlgodPath, "http") {
-		conf.URL = config.AlgodPath
-		conf.Token = config.AlgodToken
+	if strings.Contains(algodPath, "http") {
+		conf.URL = algodPath
+		conf.Token = algodToken
 	} else {
-		conf, err = readAlgodConfigFromDir(config.AlgodPath)
+		conf, err = readAlgodConfigFromDir(algodPath)
 		if err != nil {
-			log.Fatalf("failed to read algod config: %v", err)
+			return nil, fmt.Errorf("failed to read algod config: %v", err)
 		}
 	}
 
-	client, err = algod.MakeClient(
+	client, err := algod.MakeClient(
 		conf.URL,
 		conf.Token,
 	)
 	if err != nil {
-		log.Fatalf("Failed to create algod client: %v", err)
+		return nil, fmt.Errorf("failed to create algod client: %v", err)
 	}
+	return client, nil
 }
 
-func AlgodClient() *algod.Client {
-	return client
+// AlgodClient returns the algod client used by c
+func (c *Client) AlgodClient() Algod {
+	return c.algod
 }
 
-func CompileTealFromFile(tealPath string) ([]byte, error) {
-	algodClient := AlgodClient()
+func (c *Client) CompileTealFromFile(tealPath string) ([]byte, error) {
+	algodClient := c.AlgodClient()
 
 	teal, err := os.ReadFile(tealPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read %s from file: %v", tealPath, err)
 	}
 
-	result, err := algodClient.TealCompile(teal).Do(context.Background())
+	result, err := algodClient.TealCompile(context.Background(), teal, false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to compile %s: %v", tealPath, err)
 	}
 	binary, err := base64.StdEncoding.DecodeString(result.Result)
 	if err != nil {
-		log.Fatalf("failed to decode approval program: %v", err)
+		return nil, fmt.Errorf("failed to decode approval program: %v", err)
 	}
 
 	return binary, nil
 }
 
 // GetBalance returns the balance and MBR of the given address in microAlgos
-func GetBalanceAndMBR(address string) (uint64, uint64, error) {
-	algodClient := AlgodClient()
+func (c *Client) GetBalanceAndMBR(address string) (uint64, uint64, error) {
+	algodClient := c.AlgodClient()
 
-	accountInfo, err := algodClient.AccountInformation(address).Do(context.Background())
+	accountInfo, err := algodClient.AccountInformation(context.Background(), address)
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to get account information: %v", err)
 	}
 	return accountInfo.Amount, accountInfo.MinBalance, nil
 }
 
+// BlockTime returns the timestamp of the block of round
+func (c *Client) BlockTime(ctx context.Context, round uint64) (time.Time, error) {
+	block, err := c.algod.Block(ctx, round)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get block %d: %v", round, err)
+	}
+	return time.Unix(block.TimeStamp, 0).UTC(), nil
+}
+
+// ErrNullifierSpent is returned when creating a withdrawal from a note already spent
+var ErrNullifierSpent = errors.New("nullifier already spent")
+
+// IsNullifierSpent reports whether the box of nullifier exists onchain, which the app
+// creates when the note with that nullifier is spent
+func (c *Client) IsNullifierSpent(ctx context.Context, nullifier []byte) (bool, error) {
+	_, err := c.algod.GetApplicationBoxByName(ctx, c.App.Id, nullifier)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrBoxNotFound):
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to get nullifier box: %v", err)
+	}
+}
+
 // abiEncode encodes arg into its abi []byte representation
 func abiEncode(arg any, abiTypeName string) ([]byte, error) {
 	abiType, err := abi.TypeOf(abiTypeName)
@@ -1,8 +1,11 @@
 package avm
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/zkp"
 )
 
 // SendTxnErrorType represents the type of error sending a transaction
@@ -15,6 +18,11 @@ const (
 	ErrExpired
 	ErrInternal
 	ErrMinimumBalanceRequirement
+	ErrServerBusy
+	ErrTreeDivergence
+	ErrNoteSpent
+	ErrRootExpired
+	ErrBudgetExhausted
 )
 
 func (e SendTxnErrorType) String() string {
@@ -31,15 +39,39 @@ func (e SendTxnErrorType) String() string {
 		return "TxnInternalError"
 	case ErrMinimumBalanceRequirement:
 		return "TxnMinimumBalanceRequirementError"
+	case ErrServerBusy:
+		return "TxnServerBusyError"
+	case ErrTreeDivergence:
+		return "TxnTreeDivergenceError"
+	case ErrNoteSpent:
+		return "TxnNoteSpentError"
+	case ErrRootExpired:
+		return "TxnRootExpiredError"
+	case ErrBudgetExhausted:
+		return "TxnBudgetExhaustedError"
 	default:
 		return "TxnUnknownError"
 	}
 }
 
+// ParseSendTxnErrorType returns the error type whose String is s, or ErrInternal if none
+func ParseSendTxnErrorType(s string) SendTxnErrorType {
+	for t := ErrWaitTimeout; t <= ErrBudgetExhausted; t++ {
+		if t.String() == s {
+			return t
+		}
+	}
+	return ErrInternal
+}
+
 // TxnConfirmationError represents an error waiting for a txn confirmation
 type TxnConfirmationError struct {
 	Type    SendTxnErrorType // The type of the error
 	Message string           // The original error message
+	// For ErrServerBusy, the seconds to wait before trying again
+	RetryAfter int
+	// The failure found simulating the txn group before sending it, if any
+	Simulation *SimulationFailure
 }
 
 // Implement the Error() method to satisfy the error interface
@@ -74,6 +106,12 @@ func parseSendTransactionError(err error) *TxnConfirmationError {
 	if err == nil {
 		return nil
 	}
+	if strings.Contains(err.Error(), "budget exceeded") {
+		return &TxnConfirmationError{
+			Type:    ErrBudgetExhausted,
+			Message: err.Error(),
+		}
+	}
 	if strings.Contains(err.Error(), "logic eval error") {
 		return &TxnConfirmationError{
 			Type:    ErrRejected,
@@ -111,3 +149,30 @@ func InternalError(s string) *TxnConfirmationError {
 		Message: s,
 	}
 }
+
+// CreateTxnsError returns the error for a failure creating the transactions to send:
+// ErrServerBusy if the prover queue was full, ErrTreeDivergence if the merkle tree does not
+// match the chain, ErrNoteSpent if the note withdrawn is already spent, ErrInternal otherwise
+func CreateTxnsError(s string, err error) *TxnConfirmationError {
+	if errors.Is(err, ErrNullifierSpent) {
+		return &TxnConfirmationError{
+			Type:    ErrNoteSpent,
+			Message: s + ": " + err.Error(),
+		}
+	}
+	if errors.Is(err, ErrTreeDiverged) {
+		return &TxnConfirmationError{
+			Type:    ErrTreeDivergence,
+			Message: s + ": " + err.Error(),
+		}
+	}
+	var busy *zkp.BusyError
+	if errors.As(err, &busy) {
+		return &TxnConfirmationError{
+			Type:       ErrServerBusy,
+			Message:    s + ": " + err.Error(),
+			RetryAfter: busy.RetrySeconds(),
+		}
+	}
+	return InternalError(s + ": " + err.Error())
+}
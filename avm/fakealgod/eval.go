@@ -0,0 +1,341 @@
+package fakealgod
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+
+	sdk_models "github.com/algorand/go-algorand-sdk/v2/client/v2/common/models"
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/algorand/go-algorand-sdk/v2/transaction"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// evaluation is the evaluation of a txn group, holding the state it changes until
+// the group is known to be valid
+type evaluation struct {
+	algod     *Algod
+	balances  map[types.Address]uint64 // the balances changed by the group
+	subtree   [][]byte
+	leafCount uint64
+	roots     [][]byte
+	spent     map[string]bool // the nullifiers spent by the group
+	failedAt  int             // the index of the txn failing the group
+	feeCredit uint64          // the fees paid by the group in excess, for the inner txns
+	appBudget uint64          // the opcode budget pooled by the app calls
+}
+
+// evalGroup evaluates the txns of group in order, returning them as pending txns
+func (e *evaluation) evalGroup(group []types.SignedTxn) ([]*pendingTxn, error) {
+	firstTxnId := crypto.GetTxID(group[0].Txn)
+	var fees uint64
+	for _, stxn := range group {
+		fees += uint64(stxn.Txn.Fee)
+	}
+	minFees := uint64(transaction.MinTxnFee * len(group))
+	if fees < minFees {
+		return nil, poolError(firstTxnId,
+			"txgroup had %d in fees, which is less than the minimum %d * %d",
+			fees, len(group), transaction.MinTxnFee)
+	}
+	e.feeCredit = fees - minFees
+	for _, stxn := range group {
+		if stxn.Txn.Type == types.ApplicationCallTx {
+			e.appBudget += AppCallBudget
+		}
+	}
+
+	round := e.algod.round + 1
+	var pending []*pendingTxn
+	var logicSigCost uint64
+	for i, stxn := range group {
+		e.failedAt = i
+		txn := stxn.Txn
+		txnId := crypto.GetTxID(txn)
+		cost := e.algod.logicSigCost(txn.Sender)
+		logicSigCost += cost
+		if logicSigCost > config.LogicSigBudgetPerTxn*uint64(len(group)) {
+			return nil, poolError(txnId, "rejected by logic err=dynamic cost budget "+
+				"exceeded, executing pushint: pooled program cost was %d > %d", logicSigCost,
+				config.LogicSigBudgetPerTxn*len(group))
+		}
+		if round < uint64(txn.FirstValid) || round > uint64(txn.LastValid) {
+			return nil, poolError(txnId, "txn dead: round %d outside of %d--%d", round,
+				txn.FirstValid, txn.LastValid)
+		}
+		if err := e.spend(txnId, txn.Sender, uint64(txn.Fee)); err != nil {
+			return nil, err
+		}
+
+		p := &pendingTxn{
+			txnId:        txnId,
+			info:         sdk_models.PendingTransactionInfoResponse{Transaction: stxn},
+			logicSigCost: cost,
+		}
+		switch txn.Type {
+		case types.PaymentTx:
+			if err := e.spend(txnId, txn.Sender, uint64(txn.Amount)); err != nil {
+				return nil, err
+			}
+			e.credit(txn.Receiver, uint64(txn.Amount))
+			if !txn.CloseRemainderTo.IsZero() {
+				e.credit(txn.CloseRemainderTo, e.balance(txn.Sender))
+				e.balances[txn.Sender] = 0
+			}
+		case types.ApplicationCallTx:
+			if uint64(txn.ApplicationID) == e.algod.app.Id {
+				if err := e.evalAppCall(p, group, i); err != nil {
+					return nil, poolError(txnId, "logic eval error: %v", err)
+				}
+			}
+		}
+		pending = append(pending, p)
+	}
+
+	e.failedAt = 0 // the minimum balances are checked for the whole group
+	for address, balance := range e.balances {
+		if balance > 0 && balance < MinBalance {
+			return nil, poolError(firstTxnId,
+				"account %s balance %d below min %d (0 assets)", address, balance,
+				MinBalance)
+		}
+	}
+	return pending, nil
+}
+
+// evalAppCall evaluates the app call at index i of group, setting the log and the leaf
+// inserted in the merkle tree of p for deposits and withdrawals. Withdrawals with no
+// change insert no leaf and log the last leaf inserted, like the app
+func (e *evaluation) evalAppCall(p *pendingTxn, group []types.SignedTxn, i int) error {
+	txn := group[i].Txn
+	schema := e.algod.app.Schema.Contract
+	if len(txn.ApplicationArgs) == 0 {
+		return fmt.Errorf("missing method selector")
+	}
+	selector := txn.ApplicationArgs[0]
+	isMethod := func(name string) bool {
+		method, err := schema.GetMethodByName(name)
+		return err == nil && bytes.Equal(selector, method.GetSelector())
+	}
+
+	var commitment []byte
+	switch {
+	case isMethod(config.DepositMethodName):
+		if err := e.ensureBudget(p, DepositOpcodeBudget); err != nil {
+			return err
+		}
+		// args: proof, public inputs (amount, commitment), depositor address
+		amount, err := publicInput(txn.ApplicationArgs, 0)
+		if err != nil {
+			return err
+		}
+		if commitment, err = publicInput(txn.ApplicationArgs, 1); err != nil {
+			return err
+		}
+		appAddress := crypto.GetApplicationAddress(e.algod.app.Id)
+		if i+1 >= len(group) || group[i+1].Txn.Type != types.PaymentTx ||
+			group[i+1].Txn.Receiver != appAddress ||
+			uint64(group[i+1].Txn.Amount) != binary.BigEndian.Uint64(amount[24:]) {
+			return fmt.Errorf("deposit payment not found")
+		}
+
+	case isMethod(config.WithDrawalMethodName):
+		if err := e.ensureBudget(p, WithdrawalOpcodeBudget); err != nil {
+			return err
+		}
+		// args: proof, public inputs (recipient, amount, fee, commitment, nullifier,
+		// root), recipient account, fee recipient account, no change
+		inputs := make([][]byte, 6)
+		for pos := range inputs {
+			input, err := publicInput(txn.ApplicationArgs, pos)
+			if err != nil {
+				return err
+			}
+			inputs[pos] = input
+		}
+		amount, fee, nullifier, root := inputs[1], inputs[2], inputs[4], inputs[5]
+		commitment = inputs[3]
+		if e.algod.nullifiers[string(nullifier)] || e.spent[string(nullifier)] {
+			return e.assertFailed("Nullifier already exists")
+		}
+		if e.algod.expireRoots {
+			e.algod.expireRoots = false
+			e.algod.expireRoot(root)
+		}
+		if e.algod.expiredRoots[string(root)] || !e.isRecentRoot(root) {
+			return e.assertFailed("Invalid root")
+		}
+		recipient, err := foreignAccount(txn, 3)
+		if err != nil {
+			return err
+		}
+		feeRecipient, err := foreignAccount(txn, 4)
+		if err != nil {
+			return err
+		}
+		// an arc4 bool is one byte with the value in the highest bit
+		if len(txn.ApplicationArgs) < 6 || len(txn.ApplicationArgs[5]) != 1 {
+			return fmt.Errorf("missing no change arg")
+		}
+		noChange := txn.ApplicationArgs[5][0]&0x80 != 0
+		appAddress := crypto.GetApplicationAddress(e.algod.app.Id)
+		amountValue := binary.BigEndian.Uint64(amount[24:])
+		feeValue := binary.BigEndian.Uint64(fee[24:])
+		if err := e.spend(p.txnId, appAddress, amountValue+feeValue); err != nil {
+			return err
+		}
+		e.credit(recipient, amountValue)
+		e.credit(feeRecipient, feeValue)
+		if err := e.innerTxn(p, types.PaymentTx); err != nil {
+			return err
+		}
+		if feeValue > config.NullifierMbr {
+			if err := e.innerTxn(p, types.PaymentTx); err != nil {
+				return err
+			}
+		}
+		e.spent[string(nullifier)] = true
+		if noChange {
+			// the app inserts no change note and returns the last leaf inserted
+			p.leafIndex, p.root = e.leafCount-1, e.roots[len(e.roots)-1]
+			p.info.Logs = [][]byte{abiReturn(p.leafIndex, p.root)}
+			return nil
+		}
+
+	default:
+		return nil
+	}
+
+	p.leaf = commitment
+	p.leafIndex, p.root = e.insertLeaf(commitment)
+	p.info.Logs = [][]byte{abiReturn(p.leafIndex, p.root)}
+	return nil
+}
+
+// ensureBudget makes opup inner app calls in p until the pooled app budget reaches
+// budget, like the app does
+func (e *evaluation) ensureBudget(p *pendingTxn, budget uint64) error {
+	for e.appBudget < budget {
+		if err := e.innerTxn(p, types.ApplicationCallTx); err != nil {
+			return err
+		}
+		e.appBudget += AppCallBudget
+	}
+	return nil
+}
+
+// innerTxn adds an inner txn of type txnType to p, paying its fee from the fee credit
+func (e *evaluation) innerTxn(p *pendingTxn, txnType types.TxType) error {
+	if e.feeCredit < transaction.MinTxnFee {
+		return fmt.Errorf("fee too small: %d left in the group fee credit, %d needed "+
+			"for inner txn %d", e.feeCredit, transaction.MinTxnFee, len(p.info.InnerTxns))
+	}
+	e.feeCredit -= transaction.MinTxnFee
+	inner := sdk_models.PendingTransactionResponse{}
+	inner.Transaction.Txn.Type = txnType
+	p.info.InnerTxns = append(p.info.InnerTxns, inner)
+	return nil
+}
+
+// assertFailed returns the error of the failed app assert with the error comment reason.
+// Its pc is the line number of the assert in the approval program, matching the source
+// maps returned by TealCompile
+func (e *evaluation) assertFailed(reason string) error {
+	pc := 0
+	approval, _ := base64.StdEncoding.DecodeString(e.algod.app.Schema.Source.Approval)
+	for i, line := range strings.Split(string(approval), "\n") {
+		if strings.TrimSpace(line) == "assert // "+reason {
+			pc = i
+			break
+		}
+	}
+	return fmt.Errorf("assert failed pc=%d. Details: app=%d, pc=%d", pc, e.algod.app.Id, pc)
+}
+
+// insertLeaf appends leaf to the merkle tree, returning its index and the new root
+func (e *evaluation) insertLeaf(leaf []byte) (leafIndex uint64, root []byte) {
+	zeroHashes := e.algod.app.TreeConfig.ZeroHashes
+	leafIndex = e.leafCount
+	index := leafIndex
+	current := leaf
+	for level := range config.MerkleTreeLevels {
+		if index&1 == 0 {
+			e.subtree[level] = current
+			current = config.Hash(current, zeroHashes[level])
+		} else {
+			current = config.Hash(e.subtree[level], current)
+		}
+		index >>= 1
+	}
+	e.leafCount++
+	if len(e.roots) == config.RootCount {
+		e.roots = e.roots[1:]
+	}
+	e.roots = append(e.roots, current)
+	return leafIndex, current
+}
+
+// isRecentRoot reports whether root is one of the roots accepted for withdrawals
+func (e *evaluation) isRecentRoot(root []byte) bool {
+	for _, r := range e.roots {
+		if bytes.Equal(r, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// balance returns the balance of address as changed by the group so far
+func (e *evaluation) balance(address types.Address) uint64 {
+	if balance, ok := e.balances[address]; ok {
+		return balance
+	}
+	return e.algod.balances[address]
+}
+
+// spend takes amount from the balance of address, failing if it is not enough
+func (e *evaluation) spend(txnId string, address types.Address, amount uint64) error {
+	balance := e.balance(address)
+	if balance < amount {
+		return poolError(txnId, "overspend (account %s, data {MicroAlgos:{Raw:%d}}, "+
+			"tried to spend {%d})", address, balance, amount)
+	}
+	e.balances[address] = balance - amount
+	return nil
+}
+
+// credit adds amount to the balance of address
+func (e *evaluation) credit(address types.Address, amount uint64) {
+	e.balances[address] = e.balance(address) + amount
+}
+
+// publicInput returns the zk public input at pos, from the arc4 byte[32][] array
+// which is the third app arg
+func publicInput(args [][]byte, pos int) ([]byte, error) {
+	if len(args) < 3 {
+		return nil, fmt.Errorf("missing public inputs")
+	}
+	// the first 2 bytes encode the length of the array
+	start := 2 + pos*32
+	if len(args[2]) < start+32 {
+		return nil, fmt.Errorf("missing public input %d", pos)
+	}
+	return args[2][start : start+32], nil
+}
+
+// foreignAccount returns the account referenced by the app arg at argPos, which is the
+// 1-based index of the account in the foreign accounts
+func foreignAccount(txn types.Transaction, argPos int) (types.Address, error) {
+	if len(txn.ApplicationArgs) <= argPos || len(txn.ApplicationArgs[argPos]) != 1 {
+		return types.Address{}, fmt.Errorf("missing account arg %d", argPos)
+	}
+	index := int(txn.ApplicationArgs[argPos][0]) - 1
+	if index < 0 || index >= len(txn.Accounts) {
+		return types.Address{}, fmt.Errorf("account index %d out of range", index+1)
+	}
+	return txn.Accounts[index], nil
+}
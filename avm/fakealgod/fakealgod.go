@@ -0,0 +1,494 @@
+// Package fakealgod provides an in-process fake of the algod API used by avm.Client, to
+// exercise the deposit and withdrawal flows offline.
+//
+// The fake keeps the account balances, advances one round each time a caller waits for
+// a block, and confirms the txn groups it accepts in the next round.
+// It evaluates the app deposit and withdrawal calls checking the nullifiers and the
+// recent roots like the contract, but it does not verify the zk proofs or the logic
+// signatures. The notes inserted in its merkle tree are written to a db.Memory store when
+// confirmed, as the subscriber would do.
+//
+// Failures are simulated as algod would report them, both when simulating a group and
+// when sending it: sending a txn past its last valid round (see AdvanceRounds) fails with
+// "txn dead", spending more than the balance with "overspend", leaving less than the
+// minimum balance with "below min", and the app asserts with "assert failed" at a pc
+// which is the line number of the assert in the approval program, as in the source maps
+// returned by TealCompile. The opup inner txns the app makes to reach its opcode budget
+// and the opcode budget pooled by the logicsigs are accounted for with the fake costs
+// below, and the fees must cover the inner txns.
+//
+// RejectNext makes the next group evaluated fail with a "logic eval error",
+// ExpireRootsNext makes the root of the next withdrawal fail the app root check and
+// DropNext makes the next group sent never confirm, so that waiting for it times out.
+// DisableSimulation makes the simulate endpoint unavailable. HideRootsNext makes the next
+// read of the app roots box miss the local roots, as if the txns database had diverged
+// from the chain.
+package fakealgod
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	sdk_models "github.com/algorand/go-algorand-sdk/v2/client/v2/common/models"
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/algorand/go-algorand-sdk/v2/transaction"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// fake network constants
+const (
+	GenesisID  = "fakenet-v1"
+	MinBalance = 100_000 // microalgo, the minimum balance of an account with no assets
+
+	firstRound      = 1000
+	validityRounds  = 1000 // last valid round of the suggested params, after the first
+	abiReturnPrefix = "\x15\x1f\x7c\x75"
+)
+
+// fake costs of the app and its logicsigs
+const (
+	AppCallBudget = 700 // the opcode budget pooled by each app call
+	// the opcode budget the app ensures for deposits and withdrawals with opup inner txns
+	DepositOpcodeBudget    = 37_100
+	WithdrawalOpcodeBudget = 39_200
+	// the cost of the verifier logicsigs, needing 7 top level txns of pooled budget, and
+	// of the TSS logicsig
+	VerifierCost = 130_000
+	TSSCost      = 100
+)
+
+var GenesisHash = types.Digest(sha512.Sum512_256([]byte(GenesisID)))
+
+var _ avm.Algod = (*Algod)(nil)
+
+// Algod is a fake algod node for app
+type Algod struct {
+	mu    sync.Mutex
+	app   *models.App
+	txns  *db.Memory
+	round uint64
+	// the unix timestamp of each round made, from firstRound
+	timestamps []int64
+
+	balances map[types.Address]uint64
+	pending  map[string]*pendingTxn // by txn id
+
+	// the app state
+	subtree    [][]byte // the last left node of each level of the merkle tree
+	leafCount  uint64
+	roots      [][]byte // the last config.RootCount roots, oldest first
+	nullifiers map[string]bool
+	// the roots no longer accepted, see ExpireRootsNext
+	expiredRoots map[string]bool
+
+	// failures to inject
+	rejectNext   string
+	expireRoots  bool
+	dropNext     bool
+	hideRoots    bool
+	noSimulation bool
+}
+
+// pendingTxn is a txn accepted by the fake
+type pendingTxn struct {
+	txnId        string
+	info         sdk_models.PendingTransactionInfoResponse
+	confirmRound uint64 // zero if it will never be confirmed
+	leaf         []byte // the commitment inserted in the merkle tree, if any
+	leafIndex    uint64
+	root         []byte // the root after inserting leaf
+	logicSigCost uint64 // the opcode cost of the logicsig of the txn, if any
+}
+
+// New returns a fake algod for app writing the notes inserted in the merkle tree to txns
+func New(app *models.App, txns *db.Memory) *Algod {
+	txns.SetWatermark(firstRound)
+	return &Algod{
+		app:        app,
+		txns:       txns,
+		round:      firstRound,
+		timestamps: []int64{time.Now().Unix()},
+		balances:   make(map[types.Address]uint64),
+		pending:    make(map[string]*pendingTxn),
+		subtree:    make([][]byte, config.MerkleTreeLevels),
+		nullifiers: make(map[string]bool),
+
+		expiredRoots: make(map[string]bool),
+	}
+}
+
+// Fund adds amount microalgos to the balance of address
+func (a *Algod) Fund(address types.Address, amount uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.balances[address] += amount
+}
+
+// Balance returns the balance of address in microalgos
+func (a *Algod) Balance(address types.Address) uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.balances[address]
+}
+
+// Round returns the last round
+func (a *Algod) Round() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.round
+}
+
+// AdvanceRounds moves the last round forward by n rounds, confirming the pending txns
+func (a *Algod) AdvanceRounds(n uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for range n {
+		a.nextRound()
+	}
+}
+
+// RejectNext makes the evaluation of the next txn group, simulated or sent, fail with
+// reason
+func (a *Algod) RejectNext(reason string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rejectNext = reason
+}
+
+// ExpireRootsNext makes the root of the next withdrawal evaluated, simulated or sent,
+// expire as if other frontends had inserted many leaves since it was proven: the root is
+// refused from then on, and a leaf is inserted and saved at once to the txns database, so
+// that a proof against the latest root is accepted
+func (a *Algod) ExpireRootsNext() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expireRoots = true
+}
+
+// DisableSimulation makes the simulate endpoint unavailable, or available again
+func (a *Algod) DisableSimulation(disabled bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.noSimulation = disabled
+}
+
+// DropNext makes the next txn group sent be accepted but never confirmed
+func (a *Algod) DropNext() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dropNext = true
+}
+
+// HideRootsNext makes the next read of the app roots box return no roots
+func (a *Algod) HideRootsNext() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.hideRoots = true
+}
+
+func (a *Algod) Status(ctx context.Context) (sdk_models.NodeStatus, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return sdk_models.NodeStatus{LastRound: a.round}, nil
+}
+
+// StatusAfterBlock does not wait, the block after round is made at once
+func (a *Algod) StatusAfterBlock(ctx context.Context, round uint64,
+) (sdk_models.NodeStatus, error) {
+	if err := ctx.Err(); err != nil {
+		return sdk_models.NodeStatus{}, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for a.round <= round {
+		a.nextRound()
+	}
+	return sdk_models.NodeStatus{LastRound: a.round}, nil
+}
+
+func (a *Algod) SuggestedParams(ctx context.Context) (types.SuggestedParams, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return types.SuggestedParams{
+		Fee:             0,
+		GenesisID:       GenesisID,
+		GenesisHash:     GenesisHash[:],
+		FirstRoundValid: types.Round(a.round),
+		LastRoundValid:  types.Round(a.round + validityRounds),
+		MinFee:          transaction.MinTxnFee,
+	}, nil
+}
+
+func (a *Algod) AccountInformation(ctx context.Context, address string,
+) (sdk_models.Account, error) {
+	addr, err := types.DecodeAddress(address)
+	if err != nil {
+		return sdk_models.Account{}, fmt.Errorf("failed to parse the address: %v", err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	amount := a.balances[addr]
+	return sdk_models.Account{
+		Address:                     address,
+		Amount:                      amount,
+		AmountWithoutPendingRewards: amount,
+		MinBalance:                  MinBalance,
+		Round:                       a.round,
+		Status:                      "Offline",
+	}, nil
+}
+
+func (a *Algod) PendingTransactionInformation(ctx context.Context, txnId string,
+) (sdk_models.PendingTransactionInfoResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p, ok := a.pending[txnId]
+	if !ok {
+		return sdk_models.PendingTransactionInfoResponse{},
+			fmt.Errorf("txn does not exist")
+	}
+	return p.info, nil
+}
+
+// Block returns only the header of the block of round, with its round and timestamp
+func (a *Algod) Block(ctx context.Context, round uint64) (types.Block, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if round < firstRound || round > a.round {
+		return types.Block{}, fmt.Errorf("failed to retrieve information from the ledger")
+	}
+	var block types.Block
+	block.Round = types.Round(round)
+	block.TimeStamp = a.timestamps[round-firstRound]
+	return block, nil
+}
+
+// TealCompile does not compile teal, it only returns its source map if asked, where the
+// pc of each line is its line number like for the pcs of the failed app asserts
+func (a *Algod) TealCompile(ctx context.Context, teal []byte, sourcemap bool,
+) (sdk_models.CompileResponse, error) {
+	var response sdk_models.CompileResponse
+	if sourcemap {
+		// a segment per pc, each one line after the previous one
+		lines := strings.Count(string(teal), "\n") + 1
+		mappings := "AAAA" + strings.Repeat(";AACA", lines-1)
+		response.Sourcemap = &map[string]interface{}{"version": 3, "mappings": mappings}
+	}
+	return response, nil
+}
+
+// GetApplicationBoxByName returns the roots, subtree and nullifier boxes of the app
+func (a *Algod) GetApplicationBoxByName(ctx context.Context, appId uint64, name []byte,
+) (sdk_models.Box, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	box := sdk_models.Box{Name: name, Round: a.round}
+	switch {
+	case appId != a.app.Id:
+		return sdk_models.Box{}, avm.ErrBoxNotFound
+	case string(name) == "roots":
+		// a ring buffer, the i-th root inserted at i % config.RootCount
+		box.Value = make([]byte, 32*config.RootCount)
+		if a.hideRoots {
+			a.hideRoots = false
+			return box, nil
+		}
+		first := a.leafCount - uint64(len(a.roots))
+		for i, root := range a.roots {
+			pos := (first + uint64(i)) % config.RootCount
+			copy(box.Value[32*pos:], root)
+		}
+	case string(name) == "subtree":
+		for level, node := range a.subtree {
+			if node == nil {
+				node = a.app.TreeConfig.ZeroHashes[level]
+			}
+			box.Value = append(box.Value, node...)
+		}
+	case !a.nullifiers[string(name)]:
+		return sdk_models.Box{}, avm.ErrBoxNotFound
+	}
+	return box, nil
+}
+
+// SendRawTransaction evaluates the signed txn group and, if valid, accepts it to be
+// confirmed in the next round
+func (a *Algod) SendRawTransaction(ctx context.Context, signedGroup []byte,
+) (string, error) {
+	group, err := avm.DecodeSignedGroup(signedGroup)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e, pending, err := a.evaluate(group)
+	if err != nil {
+		return "", err
+	}
+
+	// the group is valid, apply it
+	for address, balance := range e.balances {
+		a.balances[address] = balance
+	}
+	a.subtree, a.leafCount, a.roots = e.subtree, e.leafCount, e.roots
+	for nullifier := range e.spent {
+		a.nullifiers[nullifier] = true
+	}
+	drop := a.dropNext
+	a.dropNext = false
+	for _, p := range pending {
+		if !drop {
+			p.confirmRound = a.round + 1
+		}
+		a.pending[p.txnId] = p
+	}
+	return crypto.GetTxID(group[0].Txn), nil
+}
+
+// SimulateTransaction evaluates the txn groups of request like SendRawTransaction,
+// without applying them, reporting the failures like algod
+func (a *Algod) SimulateTransaction(ctx context.Context,
+	request sdk_models.SimulateRequest) (sdk_models.SimulateResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.noSimulation {
+		return sdk_models.SimulateResponse{}, errors.New("HTTP 404 Not Found")
+	}
+
+	response := sdk_models.SimulateResponse{Version: 2, LastRound: a.round}
+	for _, requestGroup := range request.TxnGroups {
+		group := requestGroup.Txns
+		if len(group) == 0 {
+			return sdk_models.SimulateResponse{}, errors.New("empty txn group")
+		}
+		var result sdk_models.SimulateTransactionGroupResult
+		e, pending, err := a.evaluate(group)
+		if err != nil {
+			result.FailedAt = []uint64{uint64(e.failedAt)}
+			result.FailureMessage = strings.TrimPrefix(err.Error(), poolErrorPrefix)
+		}
+		for _, p := range pending {
+			result.TxnResults = append(result.TxnResults,
+				sdk_models.SimulateTransactionResult{
+					TxnResult: sdk_models.PendingTransactionResponse{
+						InnerTxns:   p.info.InnerTxns,
+						Logs:        p.info.Logs,
+						Transaction: p.info.Transaction,
+					},
+					LogicSigBudgetConsumed: p.logicSigCost,
+				})
+		}
+		response.TxnGroups = append(response.TxnGroups, result)
+	}
+	return response, nil
+}
+
+// evaluate evaluates group against the current state, or fails it with the reason given
+// to RejectNext. The lock must be held
+func (a *Algod) evaluate(group []types.SignedTxn) (*evaluation, []*pendingTxn, error) {
+	e := a.newEvaluation()
+	if a.rejectNext != "" {
+		reason := a.rejectNext
+		a.rejectNext = ""
+		return e, nil, poolError(crypto.GetTxID(group[0].Txn), "logic eval error: %s",
+			reason)
+	}
+	pending, err := e.evalGroup(group)
+	return e, pending, err
+}
+
+// newEvaluation returns an evaluation of a txn group against the current state.
+// The lock must be held
+func (a *Algod) newEvaluation() *evaluation {
+	return &evaluation{
+		algod:     a,
+		balances:  make(map[types.Address]uint64),
+		subtree:   append([][]byte{}, a.subtree...),
+		leafCount: a.leafCount,
+		roots:     append([][]byte{}, a.roots...),
+		spent:     make(map[string]bool),
+	}
+}
+
+// expireRoot makes root refused for withdrawals, inserting a leaf in the merkle tree as
+// another frontend would, saved at once to the txns database. The lock must be held
+func (a *Algod) expireRoot(root []byte) {
+	a.expiredRoots[string(root)] = true
+	e := a.newEvaluation()
+	leaf := binary.BigEndian.AppendUint64(make([]byte, 24), a.leafCount+1)
+	leafIndex, newRoot := e.insertLeaf(leaf)
+	a.subtree, a.leafCount, a.roots = e.subtree, e.leafCount, e.roots
+	a.txns.InsertTxn(leafIndex, leaf, fmt.Sprintf("FOREIGN%d", leafIndex))
+	a.txns.SetRoot(newRoot, leafIndex+1)
+}
+
+// logicSigCost returns the cost of the logicsig of the txns sent by sender, if any
+func (a *Algod) logicSigCost(sender types.Address) uint64 {
+	switch sender {
+	case a.app.DepositVerifier.Address, a.app.WithdrawalVerifier.Address:
+		return VerifierCost
+	case a.app.TSS.Address:
+		return TSSCost
+	}
+	return 0
+}
+
+// nextRound makes a new round, confirming the pending txns due.
+// The lock must be held
+func (a *Algod) nextRound() {
+	a.round++
+	a.timestamps = append(a.timestamps, time.Now().Unix())
+	// confirm in leaf order, as the subscriber would save them
+	var due []*pendingTxn
+	for _, p := range a.pending {
+		if p.confirmRound == a.round {
+			p.info.ConfirmedRound = a.round
+			if p.leaf != nil {
+				due = append(due, p)
+			}
+		}
+	}
+	for len(due) > 0 {
+		next := 0
+		for i, p := range due {
+			if p.leafIndex < due[next].leafIndex {
+				next = i
+			}
+		}
+		p := due[next]
+		a.txns.InsertTxn(p.leafIndex, p.leaf, p.txnId)
+		a.txns.SetRoot(p.root, p.leafIndex+1)
+		due = append(due[:next], due[next+1:]...)
+	}
+	a.txns.SetWatermark(a.round)
+}
+
+// poolError returns an error like the ones returned by algod for txns refused by the
+// txn pool
+func poolError(txnId string, format string, args ...any) error {
+	return fmt.Errorf(poolErrorPrefix+"transaction %s: %s", txnId,
+		fmt.Sprintf(format, args...))
+}
+
+// poolErrorPrefix prefixes the errors of the txn pool, but not the simulation failures
+const poolErrorPrefix = "TransactionPool.Remember: "
+
+// abiReturn returns the log of the arc4 return value (uint64,byte[32]) of the app calls
+func abiReturn(leafIndex uint64, root []byte) []byte {
+	log := []byte(abiReturnPrefix)
+	log = binary.BigEndian.AppendUint64(log, leafIndex)
+	return append(log, root...)
+}
@@ -0,0 +1,125 @@
+package avm
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	sdk_models "github.com/algorand/go-algorand-sdk/v2/client/v2/common/models"
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/algorand/go-algorand-sdk/v2/transaction"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// groupSize is the number of top level txns of a deposit or withdrawal group, padded with
+// noop app calls to pool the opcode budget the verifier logicsig needs, and its total fee
+type groupSize struct {
+	txns int
+	fee  uint64
+}
+
+// sizeGroup returns the smallest size of the txn group made by build, at least minTxns,
+// whose pooled logicsig budget covers the logicsigs, and its exact fee covering the top
+// level txns and the inner ones made by the app.
+// It finds them simulating the group first with the largest size, to measure the logicsig
+// cost, and then with the size needed, to count the inner txns, paying fallback.fee in
+// both.
+// If a simulation is not available or fails it returns fallback
+func (c *Client) sizeGroup(ctx context.Context, minTxns int, fallback groupSize,
+	build func(groupSize) ([]types.Transaction, error)) groupSize {
+
+	result, err := c.simulateSize(ctx, build,
+		groupSize{txns: config.MaxTxnGroupSize, fee: fallback.fee})
+	if err != nil {
+		log.Printf("Error measuring the logicsig cost, using the default group size: %v", err)
+		return fallback
+	}
+	var logicSigCost uint64
+	for _, r := range result.TxnResults {
+		logicSigCost += r.LogicSigBudgetConsumed
+	}
+	txns := max(minTxns, int((logicSigCost+config.LogicSigBudgetPerTxn-1)/
+		config.LogicSigBudgetPerTxn))
+
+	result, err = c.simulateSize(ctx, build, groupSize{txns: txns, fee: fallback.fee})
+	if err != nil {
+		log.Printf("Error simulating a group of %d txns, using the default group size: %v",
+			txns, err)
+		return fallback
+	}
+	innerTxns := 0
+	for _, r := range result.TxnResults {
+		innerTxns += countInnerTxns(r.TxnResult)
+	}
+	return groupSize{txns: txns, fee: uint64(txns+innerTxns) * transaction.MinTxnFee}
+}
+
+// simulateSize simulates the txn group made by build for size, failing if the group
+// would be rejected
+func (c *Client) simulateSize(ctx context.Context,
+	build func(groupSize) ([]types.Transaction, error), size groupSize,
+) (sdk_models.SimulateTransactionGroupResult, error) {
+	var result sdk_models.SimulateTransactionGroupResult
+	txns, err := build(size)
+	if err != nil {
+		return result, err
+	}
+	group, err := c.signWithLogicSigs(txns)
+	if err != nil {
+		return result, err
+	}
+	response, err := c.algod.SimulateTransaction(ctx, sdk_models.SimulateRequest{
+		TxnGroups: []sdk_models.SimulateRequestTransactionGroup{{Txns: group}},
+		// the txns signed by the user are not signed yet
+		AllowEmptySignatures: true,
+	})
+	if err != nil {
+		return result, err
+	}
+	if len(response.TxnGroups) == 0 {
+		return result, fmt.Errorf("simulation returned no txn group")
+	}
+	result = response.TxnGroups[0]
+	if result.FailureMessage != "" {
+		return result, fmt.Errorf("simulation of %d txns failed: %s", size.txns,
+			result.FailureMessage)
+	}
+	return result, nil
+}
+
+// signWithLogicSigs signs the txns sent by the app logicsigs, leaving the others unsigned
+func (c *Client) signWithLogicSigs(txns []types.Transaction) ([]types.SignedTxn, error) {
+	group := make([]types.SignedTxn, len(txns))
+	for i, txn := range txns {
+		group[i].Txn = txn
+		for _, lsig := range []*models.Lsig{c.App.DepositVerifier,
+			c.App.WithdrawalVerifier, c.App.TSS} {
+			if txn.Sender != lsig.Address {
+				continue
+			}
+			_, signed, err := crypto.SignLogicSigAccountTransaction(lsig.Account, txn)
+			if err != nil {
+				return nil, fmt.Errorf("failed to sign txn %d: %v", i, err)
+			}
+			stxns, err := DecodeSignedGroup(signed)
+			if err != nil {
+				return nil, err
+			}
+			group[i] = stxns[0]
+			break
+		}
+	}
+	return group, nil
+}
+
+// countInnerTxns returns the number of inner txns of txn, at any depth
+func countInnerTxns(txn sdk_models.PendingTransactionResponse) int {
+	n := len(txn.InnerTxns)
+	for _, inner := range txn.InnerTxns {
+		n += countInnerTxns(inner)
+	}
+	return n
+}
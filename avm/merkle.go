@@ -3,70 +3,272 @@ package avm
 import (
 	"bytes"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/giuliop/HermesVault-frontend/config"
 	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/metrics"
 )
 
-// getRoot returns the Merkle root from the database
-func getRoot(leafIndex uint64) ([]byte, error) {
-	root, leaf_count, err := db.GetRoot()
-	if err != nil {
-		return nil, fmt.Errorf("error getting root: %v", err)
+// merkleTree is an in-memory incremental Merkle tree mirroring the onchain one.
+// It keeps all the interior nodes so that any proof can be built in O(depth);
+// nodes not yet filled by a leaf are implicitly the zero hash of their level.
+// It is loaded once from the txns database and then synced appending new leaves.
+// It also keeps a window of the most recent roots, mirroring the ones the contract accepts
+type merkleTree struct {
+	mu         sync.RWMutex
+	txns       db.TxnsReader // where the tree is loaded from
+	zeroHashes [][]byte      // the zero hash of each level, from the app tree config
+	nodes      [][][]byte    // nodes[level][index], level 0 are the leaves
+	root       []byte
+	roots      []treeRoot // the last config.RootCount roots, oldest first
+}
+
+// treeRoot is a merkle root with the number of leaves in the tree when it was computed
+type treeRoot struct {
+	value     []byte
+	leafCount uint64
+}
+
+// newMerkleTree returns an empty tree to be loaded from txns
+func newMerkleTree(txns db.TxnsReader, zeroHashes [][]byte) *merkleTree {
+	return &merkleTree{txns: txns, zeroHashes: zeroHashes}
+}
+
+// InitMerkleTree loads the merkle tree from the txns database and verifies its root.
+// It is not required to call it, the tree loads itself when first needed, but doing it
+// at startup avoids slowing down the first withdrawal
+func (c *Client) InitMerkleTree() error {
+	return c.tree.sync()
+}
+
+// WaitForLeaf waits until the leaf at leafIndex is in the tree, syncing the tree from the
+// txns database every second, or until timeout expires
+func (c *Client) WaitForLeaf(leafIndex uint64, timeout time.Duration) error {
+	tree := c.tree
+	deadline := time.Now().Add(timeout)
+	for {
+		err := tree.sync()
+		if err == nil {
+			tree.mu.RLock()
+			found := leafIndex < tree.leafCount()
+			tree.mu.RUnlock()
+			if found {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("error syncing merkle tree: %v", err)
+			}
+			return fmt.Errorf("timed out waiting for leaf %d", leafIndex)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// IsRootExpired reports whether the given root is no longer among the most recent
+// config.RootCount roots of the tree, and so would be rejected by the contract.
+// It syncs the tree first so that roots added by other frontends are accounted for
+func (c *Client) IsRootExpired(root []byte) (bool, error) {
+	tree := c.tree
+	if err := tree.sync(); err != nil {
+		return false, fmt.Errorf("error syncing merkle tree: %v", err)
 	}
-	if leafIndex >= leaf_count {
-		return nil, fmt.Errorf("leaf index not in tree")
+
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	for _, r := range tree.roots {
+		if bytes.Equal(r.value, root) {
+			return false, nil
+		}
 	}
-	return root, nil
+	return true, nil
 }
 
-// createMerkleProof returns the Merkle proof for the leaf at the given index.
+// createMerkleProof returns the Merkle proof for the leaf at the given index against
+// the given root, which must be one of the recent roots of the tree, and that root.
+// If root is nil the latest root is used.
 // The proof is a path that starts with the leaf value (not hashed)
 // and includes the sibling hashes up to but excluding the root.
-// It checks the validity of the proof against the provided root
-func createMerkleProof(leafValue []byte, leafIndex uint64, root []byte) ([][]byte, error) {
-	depth := config.MerkleTreeLevels
-	proof := make([][]byte, 1, depth+1)
-	proof[0] = leafValue
+// It checks the validity of the proof against the root
+func (c *Client) createMerkleProof(leafValue []byte, leafIndex uint64, root []byte,
+) (proof [][]byte, proofRoot []byte, err error) {
+	defer metrics.MerkleProofDuration.ObserveSince(time.Now())
 
-	// We need to decide whether we are left and add the right sibling to
-	// the proof, or we are right and add the left sibling to the proof.
-	// We can do this by checking the last bit of leaf index:
-	// if it's 0, we are left, if it's 1, we are right.
-	// We rigth shift the index to check the next bit in the next iteration.
-	currentLevel, err := db.GetAllLeavesCommitments()
-	if err != nil {
-		return nil, fmt.Errorf("error getting all leaf commitments: %v", err)
+	tree := c.tree
+	if err := tree.sync(); err != nil {
+		return nil, nil, fmt.Errorf("error syncing merkle tree: %v", err)
+	}
+
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	var target *treeRoot
+	if root == nil {
+		if len(tree.roots) > 0 {
+			target = &tree.roots[len(tree.roots)-1]
+		}
+	} else {
+		for i := range tree.roots {
+			if bytes.Equal(tree.roots[i].value, root) {
+				target = &tree.roots[i]
+			}
+		}
+	}
+	if target == nil {
+		return nil, nil, fmt.Errorf("root not among the recent roots")
 	}
-	if !bytes.Equal(config.Hash(leafValue), currentLevel[leafIndex]) {
-		return nil, fmt.Errorf("leaf commitment mismatch")
+	if leafIndex >= target.leafCount {
+		return nil, nil, fmt.Errorf("leaf index not in tree")
 	}
-	if len(currentLevel)%2 == 1 {
-		currentLevel = append(currentLevel, App.TreeConfig.ZeroHashes[0])
+	if !bytes.Equal(config.Hash(leafValue), tree.nodes[0][leafIndex]) {
+		return nil, nil, fmt.Errorf("leaf commitment mismatch")
 	}
-	nextLevel := make([][]byte, (len(currentLevel)+1)/2)
-	for i := 0; i < depth; i++ {
+
+	// At each level the sibling is the node whose index differs only in the last bit:
+	// if it's 0, we are left and add the right sibling, if it's 1, we are right and add
+	// the left sibling. We right shift the index to move up to the parent level.
+	depth := config.MerkleTreeLevels
+	proof = make([][]byte, 1, depth+1)
+	proof[0] = leafValue
+	index := leafIndex
+	for level := range depth {
+		proof = append(proof, tree.nodeAt(level, index^1, target.leafCount))
+		index >>= 1
+	}
+
+	// check if the root for the proof is the same as the target root
+	if !bytes.Equal(computeRoot(proof, leafIndex), target.value) {
+		return nil, nil, fmt.Errorf("root mismatch")
+	}
+
+	return proof, target.value, nil
+}
+
+// computeRoot returns the root obtained by hashing up the given merkle proof
+func computeRoot(proof [][]byte, leafIndex uint64) []byte {
+	current := config.Hash(proof[0])
+	for _, sibling := range proof[1:] {
 		if leafIndex&1 == 0 {
-			proof = append(proof, currentLevel[leafIndex+1])
+			current = config.Hash(current, sibling)
 		} else {
-			proof = append(proof, currentLevel[leafIndex-1])
+			current = config.Hash(sibling, current)
 		}
+		leafIndex >>= 1
+	}
+	return current
+}
 
-		for j := 0; j < len(currentLevel); j += 2 {
-			nextLevel[j/2] = config.Hash(currentLevel[j], currentLevel[j+1])
+// sync appends to the tree the leaves added to the txns database since the last sync
+// and checks the resulting root against the one in the database.
+// If the roots do not match the tree is reset, to be rebuilt from scratch at the next sync
+func (t *merkleTree) sync() error {
+	dbRoot, leafCount, err := t.txns.GetRoot()
+	if err != nil {
+		return fmt.Errorf("error getting root: %v", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.nodes == nil || leafCount < t.leafCount() {
+		t.reset()
+	}
+	if start := t.leafCount(); leafCount > start {
+		leaves, err := t.txns.GetLeavesCommitments(start, leafCount)
+		if err != nil {
+			return fmt.Errorf("error getting leaf commitments: %v", err)
 		}
-		if len(nextLevel)%2 == 1 {
-			nextLevel = append(nextLevel, App.TreeConfig.ZeroHashes[i+1])
+		for _, leaf := range leaves {
+			t.append(leaf)
 		}
+	}
 
-		currentLevel = nextLevel
-		nextLevel = nextLevel[:len(nextLevel)/2]
-		leafIndex >>= 1
+	if leafCount > 0 && !bytes.Equal(t.root, dbRoot) {
+		t.reset()
+		return fmt.Errorf("root mismatch with database at leaf count %d", leafCount)
+	}
+	return nil
+}
+
+// reset empties the tree
+func (t *merkleTree) reset() {
+	depth := config.MerkleTreeLevels
+	t.nodes = make([][][]byte, depth+1)
+	t.root = t.zeroHashes[depth]
+	t.roots = make([]treeRoot, 0, config.RootCount)
+}
+
+// leafCount returns the number of leaves in the tree
+func (t *merkleTree) leafCount() uint64 {
+	if t.nodes == nil {
+		return 0
 	}
-	// check if the root for the proof is the same as the supplied root
-	if len(nextLevel) != 1 || !bytes.Equal(nextLevel[0], root) {
-		return nil, fmt.Errorf("root mismatch")
+	return uint64(len(t.nodes[0]))
+}
+
+// node returns the node at the given level and index, or the zero hash of the level
+// if the node has not been filled yet
+func (t *merkleTree) node(level int, index uint64) []byte {
+	if index < uint64(len(t.nodes[level])) {
+		return t.nodes[level][index]
 	}
+	return t.zeroHashes[level]
+}
 
-	return proof, nil
+// nodeAt returns the node at the given level and index as it was when the tree had
+// leafCount leaves. Only the nodes on the path of the last leaf at that time can differ
+// from the current ones, and those are recomputed
+func (t *merkleTree) nodeAt(level int, index uint64, leafCount uint64) []byte {
+	firstLeaf := index << level
+	switch {
+	case firstLeaf >= leafCount:
+		return t.zeroHashes[level]
+	case firstLeaf+1<<level <= leafCount:
+		return t.node(level, index)
+	default:
+		return config.Hash(t.nodeAt(level-1, 2*index, leafCount),
+			t.nodeAt(level-1, 2*index+1, leafCount))
+	}
+}
+
+// subtree returns the last left node of each level of the tree, the state the app keeps in
+// its subtree box to insert the next leaf. Levels with no left node yet have the zero hash
+func (t *merkleTree) subtree() [][]byte {
+	depth := config.MerkleTreeLevels
+	subtree := make([][]byte, depth)
+	leafCount := t.leafCount()
+	for level := range depth {
+		subtree[level] = t.zeroHashes[level]
+		if leafCount > 0 {
+			subtree[level] = t.node(level, ((leafCount-1)>>level)&^1)
+		}
+	}
+	return subtree
+}
+
+// append adds a new leaf to the tree, updates the path from it to the root and
+// records the new root in the window of recent roots
+func (t *merkleTree) append(leaf []byte) {
+	depth := config.MerkleTreeLevels
+	index := t.leafCount()
+	t.nodes[0] = append(t.nodes[0], leaf)
+	for level := range depth {
+		parent := config.Hash(t.node(level, index&^1), t.node(level, index|1))
+		index >>= 1
+		if index < uint64(len(t.nodes[level+1])) {
+			t.nodes[level+1][index] = parent
+		} else {
+			t.nodes[level+1] = append(t.nodes[level+1], parent)
+		}
+	}
+	t.root = t.nodes[depth][0]
+
+	if len(t.roots) == config.RootCount {
+		t.roots = append(t.roots[:0], t.roots[1:]...)
+	}
+	t.roots = append(t.roots, treeRoot{value: t.root, leafCount: t.leafCount()})
 }
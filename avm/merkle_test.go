@@ -0,0 +1,166 @@
+package avm
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+)
+
+// testZeroHashes returns the zero hash of each level of a tree whose empty leaf is 0
+func testZeroHashes() [][]byte {
+	zeroHashes := make([][]byte, config.MerkleTreeLevels+1)
+	zeroHashes[0] = make([]byte, 32)
+	for level := range config.MerkleTreeLevels {
+		zeroHashes[level+1] = config.Hash(zeroHashes[level], zeroHashes[level])
+	}
+	return zeroHashes
+}
+
+// leafValue returns the value of the i-th test leaf, a field element
+func leafValue(i int) []byte {
+	value := make([]byte, 32)
+	value[30], value[31] = byte(i>>8), byte(i)
+	return value
+}
+
+// fullTree builds from scratch the levels of the tree with the given leaf commitments,
+// each level holding its filled nodes
+func fullTree(leaves [][]byte, zeroHashes [][]byte) [][][]byte {
+	levels := [][][]byte{leaves}
+	for level := range config.MerkleTreeLevels {
+		children := levels[level]
+		parents := make([][]byte, (len(children)+1)/2)
+		for i := range parents {
+			right := zeroHashes[level]
+			if 2*i+1 < len(children) {
+				right = children[2*i+1]
+			}
+			parents[i] = config.Hash(children[2*i], right)
+		}
+		levels = append(levels, parents)
+	}
+	return levels
+}
+
+// fullRoot returns the root of the tree built from scratch with the given leaves
+func fullRoot(leaves [][]byte, zeroHashes [][]byte) []byte {
+	if len(leaves) == 0 {
+		return zeroHashes[config.MerkleTreeLevels]
+	}
+	return fullTree(leaves, zeroHashes)[config.MerkleTreeLevels][0]
+}
+
+// fullProof returns the siblings of the path of leafIndex in the tree with levels
+func fullProof(levels [][][]byte, leafIndex uint64, zeroHashes [][]byte) [][]byte {
+	var siblings [][]byte
+	index := leafIndex
+	for level := range config.MerkleTreeLevels {
+		sibling := zeroHashes[level]
+		if index^1 < uint64(len(levels[level])) {
+			sibling = levels[level][index^1]
+		}
+		siblings = append(siblings, sibling)
+		index >>= 1
+	}
+	return siblings
+}
+
+// testTxns is a db.TxnsReader of the leaves appended to it, whose root is always rebuilt
+// from scratch
+type testTxns struct {
+	leaves     [][]byte
+	zeroHashes [][]byte
+}
+
+func (t *testTxns) GetLeafIndexByCommitment(commitment []byte) (uint64, error) {
+	return 0, fmt.Errorf("not implemented")
+}
+
+func (t *testTxns) GetLeavesCommitments(start, end uint64) ([][]byte, error) {
+	if end > uint64(len(t.leaves)) || start > end {
+		return nil, fmt.Errorf("leaves [%d, %d) not found", start, end)
+	}
+	return t.leaves[start:end], nil
+}
+
+func (t *testTxns) GetRoot() ([]byte, uint64, error) {
+	return fullRoot(t.leaves, t.zeroHashes), uint64(len(t.leaves)), nil
+}
+
+// TestMerkleTreeIncremental checks that the root and the proofs of the tree synced leaf
+// by leaf match those of the tree rebuilt from scratch, and that the proofs against each
+// of the recent roots verify
+func TestMerkleTreeIncremental(t *testing.T) {
+	zeroHashes := testZeroHashes()
+	txns := &testTxns{zeroHashes: zeroHashes}
+	c := &Client{tree: newMerkleTree(txns, zeroHashes)}
+	leafCount := config.RootCount + 13
+
+	var roots [][]byte // the root after each leaf, roots[i] with i+1 leaves
+	for i := range leafCount {
+		txns.leaves = append(txns.leaves, config.Hash(leafValue(i)))
+		// sync fails if the root differs from the one rebuilt by GetRoot
+		if err := c.tree.sync(); err != nil {
+			t.Fatalf("sync with %d leaves: %v", i+1, err)
+		}
+		roots = append(roots, c.tree.root)
+
+		levels := fullTree(txns.leaves, zeroHashes)
+		for _, leaf := range []int{0, i / 2, i} {
+			proof, root, err := c.createMerkleProof(leafValue(leaf), uint64(leaf), nil)
+			if err != nil {
+				t.Fatalf("proof of leaf %d with %d leaves: %v", leaf, i+1, err)
+			}
+			if !bytes.Equal(root, c.tree.root) {
+				t.Errorf("proof of leaf %d with %d leaves not against the latest root",
+					leaf, i+1)
+			}
+			if !slices.EqualFunc(proof[1:], fullProof(levels, uint64(leaf), zeroHashes),
+				bytes.Equal) {
+				t.Errorf("proof of leaf %d with %d leaves differs from the rebuilt one",
+					leaf, i+1)
+			}
+		}
+	}
+
+	if len(c.tree.roots) != config.RootCount {
+		t.Fatalf("%d recent roots, expected %d", len(c.tree.roots), config.RootCount)
+	}
+	for _, recent := range c.tree.roots {
+		count := int(recent.leafCount)
+		if !bytes.Equal(recent.value, roots[count-1]) {
+			t.Fatalf("recent root with %d leaves differs from the root then", count)
+		}
+		levels := fullTree(txns.leaves[:count], zeroHashes)
+		for _, leaf := range []int{0, count / 3, count - 2, count - 1} {
+			proof, root, err := c.createMerkleProof(leafValue(leaf), uint64(leaf),
+				recent.value)
+			if err != nil {
+				t.Fatalf("proof of leaf %d against the root with %d leaves: %v", leaf,
+					count, err)
+			}
+			if !bytes.Equal(root, recent.value) ||
+				!bytes.Equal(computeRoot(proof, uint64(leaf)), recent.value) {
+				t.Errorf("proof of leaf %d does not verify against the root with %d "+
+					"leaves", leaf, count)
+			}
+			if !slices.EqualFunc(proof[1:], fullProof(levels, uint64(leaf), zeroHashes),
+				bytes.Equal) {
+				t.Errorf("proof of leaf %d against the root with %d leaves differs "+
+					"from the rebuilt one", leaf, count)
+			}
+		}
+		if _, _, err := c.createMerkleProof(leafValue(count), uint64(count),
+			recent.value); err == nil {
+			t.Errorf("expected an error proving leaf %d against the root with %d leaves",
+				count, count)
+		}
+	}
+
+	if _, _, err := c.createMerkleProof(leafValue(0), 0, roots[0]); err == nil {
+		t.Errorf("expected an error proving against an expired root")
+	}
+}
@@ -0,0 +1,106 @@
+package avm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/metrics"
+)
+
+// ErrTreeDiverged is returned when the merkle tree synced from the txns database does
+// not match the roots the app holds onchain, so that no proof built from it is valid
+var ErrTreeDiverged = errors.New("merkle tree diverged from the app roots onchain")
+
+// the names of the app boxes holding the merkle tree state
+var (
+	// rootsBoxName holds the last config.RootCount roots, 32 bytes each, as a ring buffer
+	rootsBoxName = []byte("roots")
+	// subtreeBoxName holds the last left node of each level of the tree, 32 bytes each
+	subtreeBoxName = []byte("subtree")
+)
+
+// TreeCheck is the outcome of checking the merkle tree against the app state onchain
+type TreeCheck struct {
+	LeafCount uint64 // the leaves in the local tree
+	// AtTip reports whether the local tree has all the leaves inserted onchain.
+	// If false the txns database is behind the chain, which is fine as long as the latest
+	// local root is still among the roots the app accepts
+	AtTip bool
+}
+
+// CheckTreeOnchain syncs the merkle tree from the txns database and checks it against the
+// app roots and subtree boxes. It fails with ErrTreeDiverged if the latest root of the
+// tree is not among the app roots, counting the divergence in the metrics. This also
+// happens if the txns database is more than config.RootCount leaves behind the chain
+func (c *Client) CheckTreeOnchain(ctx context.Context) (*TreeCheck, error) {
+	// the tree is read before the boxes, so that the chain can only be ahead of it
+	tree := c.tree
+	if err := tree.sync(); err != nil {
+		return nil, fmt.Errorf("error syncing merkle tree: %v", err)
+	}
+	tree.mu.RLock()
+	root, localSubtree := tree.root, tree.subtree()
+	check := &TreeCheck{LeafCount: tree.leafCount()}
+	tree.mu.RUnlock()
+
+	roots, err := c.onchainBox(ctx, rootsBoxName, config.RootCount)
+	if err != nil {
+		return nil, err
+	}
+	subtree, err := c.onchainBox(ctx, subtreeBoxName, config.MerkleTreeLevels)
+	if err != nil {
+		return nil, err
+	}
+
+	if check.LeafCount == 0 {
+		// nothing to prove against yet, the subscriber may be starting from scratch
+		return check, nil
+	}
+	if !containsRoot(roots, root) {
+		metrics.TreeDivergences.Inc()
+		log.Printf("ALERT: the merkle tree root at leaf count %d is not among the app "+
+			"roots onchain, the txns database has diverged from the chain or is more than "+
+			"%d leaves behind", check.LeafCount, config.RootCount)
+		return nil, fmt.Errorf("%w: root at leaf count %d not found", ErrTreeDiverged,
+			check.LeafCount)
+	}
+	check.AtTip = true
+	for level, node := range localSubtree {
+		if !bytes.Equal(node, subtree[level]) {
+			check.AtTip = false
+			break
+		}
+	}
+	return check, nil
+}
+
+// onchainBox returns the app box with the given name split in count 32 bytes values
+func (c *Client) onchainBox(ctx context.Context, name []byte, count int) ([][]byte, error) {
+	box, err := c.algod.GetApplicationBoxByName(ctx, c.App.Id, name)
+	if err != nil {
+		return nil, fmt.Errorf("error reading app box %s: %v", name, err)
+	}
+	if len(box.Value) != count*32 {
+		return nil, fmt.Errorf("app box %s has %d bytes, expected %d", name,
+			len(box.Value), count*32)
+	}
+	values := make([][]byte, count)
+	for i := range values {
+		values[i] = box.Value[i*32 : (i+1)*32]
+	}
+	return values, nil
+}
+
+// containsRoot reports whether root is one of roots
+func containsRoot(roots [][]byte, root []byte) bool {
+	for _, r := range roots {
+		if bytes.Equal(r, root) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package avm
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -14,9 +15,6 @@ import (
 	"github.com/giuliop/algoplonk/utils"
 )
 
-// appSetupDirPath is the path to the app setup directory
-var appSetupDirPath = config.AppSetupDirPath
-
 // the setup filenames
 const (
 	appFile                       = "App.json"
@@ -29,109 +27,133 @@ const (
 	compiledWithdrawalCircuitFile = "CompiledWithdrawalCircuit.bin"
 )
 
-// App is the global app instance
-var App *models.App
-
 type AppJson struct {
 	Id            uint64 `json:"id"`
 	CreationBlock uint64 `json:"creationBlock"`
 }
 
-// MinimumBalance is the minimum balance required for an Algorand account in microAlgos.
-
-var MinimumBalance uint64
-
-func init() {
-	App = setupApp()
-	MinimumBalance = getMinimumBalance()
-}
-
-// setupApp sets up the app instance from the app setup files
-// It panics if the setup fails
-func setupApp() *models.App {
-	app := models.App{}
+// LoadAppJson reads the app id and creation block from the app setup files in
+// appSetupDirPath
+func LoadAppJson(appSetupDirPath string) (*AppJson, error) {
 	appJson := AppJson{}
+	err := decodeJSONFile(filepath.Join(appSetupDirPath, appFile), &appJson)
+	if err != nil {
+		return nil, err
+	}
+	return &appJson, nil
+}
 
-	decodeJSONFile(pathTo(appFile), &appJson)
-	app.Id = appJson.Id
-	decodeJSONFile(pathTo(appArc32File), &app.Schema)
-	app.TSS = readlogicsig(pathTo(tssTealFile))
-	app.DepositVerifier = readlogicsig(pathTo(depositVerifierTealFile))
-	app.WithdrawalVerifier = readlogicsig(pathTo(withdrawalVerifierTealFile))
-	app.TreeConfig = readTreeConfiguration(pathTo(treeConfigFile))
+// LoadApp sets up the app instance from the app setup files in appSetupDirPath
+func LoadApp(appSetupDirPath string) (*models.App, error) {
+	app, err := loadAppWithoutCircuits(appSetupDirPath)
+	if err != nil {
+		return nil, err
+	}
+	pathTo := func(file string) string {
+		return filepath.Join(appSetupDirPath, file)
+	}
 
-	var err error
 	app.DepositCc, err = utils.DeserializeCompiledCircuit(pathTo(compiledDepositCircuitFile))
 	if err != nil {
-		log.Fatalf("Error deserializing compiled deposit circuit: %v", err)
+		return nil, fmt.Errorf("error deserializing compiled deposit circuit: %v", err)
 	}
 	app.WithdrawalCc, err = utils.DeserializeCompiledCircuit(pathTo(
 		compiledWithdrawalCircuitFile))
 	if err != nil {
-		log.Fatalf("Error deserializing compiled withdrawal circuit: %v", err)
+		return nil, fmt.Errorf("error deserializing compiled withdrawal circuit: %v", err)
+	}
+
+	return app, nil
+}
+
+// loadAppWithoutCircuits sets up the app instance from the app setup files in
+// appSetupDirPath, except for the compiled circuits
+func loadAppWithoutCircuits(appSetupDirPath string) (*models.App, error) {
+	app := models.App{}
+	pathTo := func(file string) string {
+		return filepath.Join(appSetupDirPath, file)
 	}
 
-	return &app
+	appJson, err := LoadAppJson(appSetupDirPath)
+	if err != nil {
+		return nil, err
+	}
+	app.Id = appJson.Id
+	if err := decodeJSONFile(pathTo(appArc32File), &app.Schema); err != nil {
+		return nil, err
+	}
+	if app.TSS, err = readlogicsig(pathTo(tssTealFile)); err != nil {
+		return nil, err
+	}
+	if app.DepositVerifier, err = readlogicsig(pathTo(depositVerifierTealFile)); err != nil {
+		return nil, err
+	}
+	app.WithdrawalVerifier, err = readlogicsig(pathTo(withdrawalVerifierTealFile))
+	if err != nil {
+		return nil, err
+	}
+	if app.TreeConfig, err = readTreeConfiguration(pathTo(treeConfigFile)); err != nil {
+		return nil, err
+	}
+	return &app, nil
 }
 
-func readlogicsig(compiledPath string) *models.Lsig {
+func readlogicsig(compiledPath string) (*models.Lsig, error) {
 	bytecode, err := os.ReadFile(compiledPath)
 	if err != nil {
-		log.Fatalf("Error reading file: %v", err)
+		return nil, fmt.Errorf("error reading file: %v", err)
 	}
 	lsigAccount, err := crypto.MakeLogicSigAccountEscrowChecked(bytecode, nil)
 	if err != nil {
-		log.Fatalf("Error creating  logic sig account: %v", err)
+		return nil, fmt.Errorf("error creating logic sig account: %v", err)
 	}
 	address, err := lsigAccount.Address()
 	if err != nil {
-		log.Fatalf("Error getting lsig address: %v", err)
+		return nil, fmt.Errorf("error getting lsig address: %v", err)
 	}
 	return &models.Lsig{
 		Account: lsigAccount,
 		Address: address,
-	}
+	}, nil
 }
 
 // readTreeConfiguration reads the tree configuration from the given file
-func readTreeConfiguration(treeConfigPath string) models.TreeConfig {
+func readTreeConfiguration(treeConfigPath string) (models.TreeConfig, error) {
 	treeConfig := models.TreeConfig{}
-	decodeJSONFile(treeConfigPath, &treeConfig)
+	if err := decodeJSONFile(treeConfigPath, &treeConfig); err != nil {
+		return treeConfig, err
+	}
 	treeConfig.HashFunc = config.Hash
-	return treeConfig
-}
-
-// pathTo returns the path to the file in the artefacts directory
-func pathTo(file string) string {
-	return filepath.Join(appSetupDirPath, file)
+	return treeConfig, nil
 }
 
 // DecodeJSONFile decodes the JSON filepath into the given interface
-func decodeJSONFile(filepath string, v any) {
+func decodeJSONFile(filepath string, v any) error {
 	file, err := os.Open(filepath)
 	if err != nil {
-		log.Fatalf("Error opening file %s: %v", filepath, err)
+		return fmt.Errorf("error opening file %s: %v", filepath, err)
 	}
 	defer file.Close()
 
 	decoder := json.NewDecoder(file)
 	if err := decoder.Decode(v); err != nil {
-		log.Fatalf("Error decoding file %s: %v", filepath, err)
+		return fmt.Errorf("error decoding file %s: %v", filepath, err)
 	}
+	return nil
 }
 
 // getMinimumBalance returns the minimum balance required for an Algorand account
 // in microAlgos, we get it from reading the minimum balance from TSS logic signature,
 // since it has no opt-ins.
-func getMinimumBalance() uint64 {
+func (c *Client) getMinimumBalance() (uint64, error) {
 	const attempts = 3
 	const retryDelay = time.Second
 	var lastErr error
 
 	for attempt := 1; attempt <= attempts; attempt++ {
-		_, mbr, err := GetBalanceAndMBR(App.TSS.Address.String())
+		_, mbr, err := c.GetBalanceAndMBR(c.App.TSS.Address.String())
 		if err == nil {
-			return mbr
+			return mbr, nil
 		}
 		lastErr = err
 		log.Printf("Error getting balance and MBR (attempt %d/%d): %v", attempt, attempts, err)
@@ -140,6 +162,6 @@ func getMinimumBalance() uint64 {
 		}
 	}
 
-	log.Fatalf("Error getting balance and MBR after %d attempts: %v", attempts, lastErr)
-	return 0
+	return 0, fmt.Errorf("error getting balance and MBR after %d attempts: %v", attempts,
+		lastErr)
 }
@@ -0,0 +1,249 @@
+package avm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sdk_models "github.com/algorand/go-algorand-sdk/v2/client/v2/common/models"
+	"github.com/algorand/go-algorand-sdk/v2/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// the error comments of the app asserts that fail for a stale proof
+const (
+	// nullifierExistsError fails a withdrawal from a note already spent
+	nullifierExistsError = "Nullifier already exists"
+	// invalidRootError fails a withdrawal proven against a root no longer accepted
+	invalidRootError = "Invalid root"
+)
+
+// pcPattern matches the program counter in the algod failure messages, e.g. pc=459
+var pcPattern = regexp.MustCompile(`pc=(\d+)`)
+
+// SimulationFailure describes why a txn group failed its simulation
+type SimulationFailure struct {
+	TxnIndex int // the index in the group of the failing txn
+	PC       int // the program counter of the failing opcode, -1 if not a program failure
+	// For app calls, the failing opcode of the approval program and its error comment,
+	// e.g. "assert" and "Invalid root", if the source map of the program is available
+	Opcode  string
+	Reason  string
+	Logs    [][]byte // the logs of the failing txn
+	Message string   // the failure message from algod
+}
+
+func (f *SimulationFailure) String() string {
+	s := fmt.Sprintf("txn %d failed", f.TxnIndex)
+	if f.PC >= 0 {
+		s += fmt.Sprintf(" at pc %d", f.PC)
+	}
+	if f.Opcode != "" {
+		s += fmt.Sprintf(" (%s)", f.Opcode)
+	}
+	if f.Reason != "" {
+		s += ": " + f.Reason
+	}
+	for _, l := range f.Logs {
+		s += fmt.Sprintf(", log %x", l)
+	}
+	return s + "; " + f.Message
+}
+
+// simulateGroup runs the signed txn group through the algod simulate endpoint, returning
+// the error sending it would fail with, or nil if it would be accepted or the simulation
+// is not available
+func (c *Client) simulateGroup(ctx context.Context, signedGroup []byte,
+) *TxnConfirmationError {
+	group, err := DecodeSignedGroup(signedGroup)
+	if err != nil {
+		return InternalError("failed to decode signed txn group: " + err.Error())
+	}
+	response, err := c.algod.SimulateTransaction(ctx, sdk_models.SimulateRequest{
+		TxnGroups: []sdk_models.SimulateRequestTransactionGroup{{Txns: group}},
+	})
+	if err != nil {
+		log.Printf("Error simulating txn group, sending it without simulation: %v", err)
+		return nil
+	}
+	if len(response.TxnGroups) == 0 {
+		return InternalError("simulation returned no txn group")
+	}
+	result := response.TxnGroups[0]
+	if result.FailureMessage == "" {
+		return nil
+	}
+
+	failure := &SimulationFailure{PC: -1, Message: result.FailureMessage}
+	if len(result.FailedAt) > 0 {
+		failure.TxnIndex = int(result.FailedAt[0])
+	}
+	if failure.TxnIndex < len(result.TxnResults) {
+		failure.Logs = result.TxnResults[failure.TxnIndex].TxnResult.Logs
+	}
+	if match := pcPattern.FindStringSubmatch(failure.Message); match != nil {
+		failure.PC, _ = strconv.Atoi(match[1])
+	}
+	// app failures are reported as logic eval errors, logic sig ones as rejected by logic
+	if failure.PC >= 0 && strings.Contains(failure.Message, "logic eval error") &&
+		failure.TxnIndex < len(group) &&
+		uint64(group[failure.TxnIndex].Txn.ApplicationID) == c.App.Id {
+		line, err := c.approvalLine(ctx, failure.PC)
+		if err != nil {
+			log.Printf("Error mapping pc %d to the approval program: %v", failure.PC, err)
+		} else {
+			opcode, reason, _ := strings.Cut(line, "//")
+			failure.Opcode = strings.TrimSpace(opcode)
+			failure.Reason = strings.TrimSpace(reason)
+		}
+	}
+	return simulationError(failure)
+}
+
+// simulationError returns the error for the simulation failure f
+func simulationError(f *SimulationFailure) *TxnConfirmationError {
+	err := parseSendTransactionError(errors.New(f.Message))
+	switch f.Reason {
+	case nullifierExistsError:
+		err.Type = ErrNoteSpent
+	case invalidRootError:
+		err.Type = ErrRootExpired
+	}
+	err.Message = "simulation failed: " + f.String()
+	err.Simulation = f
+	return err
+}
+
+// approvalLine returns the line of the approval program source with the opcode at pc.
+// The source map is compiled by algod on first use
+func (c *Client) approvalLine(ctx context.Context, pc int) (string, error) {
+	c.approvalMu.Lock()
+	defer c.approvalMu.Unlock()
+	if c.approval == nil {
+		approval, err := c.compileApproval(ctx)
+		if err != nil {
+			return "", err
+		}
+		c.approval = approval
+	}
+	if pc >= len(c.approval.pcLines) || c.approval.pcLines[pc] < 0 ||
+		c.approval.pcLines[pc] >= len(c.approval.lines) {
+		return "", fmt.Errorf("pc %d not in the source map", pc)
+	}
+	return c.approval.lines[c.approval.pcLines[pc]], nil
+}
+
+// programSource is the source of a program with the source line of each pc
+type programSource struct {
+	lines   []string
+	pcLines []int // -1 for the pcs with no opcode starting there
+}
+
+// compileApproval compiles the approval program of the app with its source map
+func (c *Client) compileApproval(ctx context.Context) (*programSource, error) {
+	teal, err := base64.StdEncoding.DecodeString(c.App.Schema.Source.Approval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode approval program: %v", err)
+	}
+	// the template variables are the verifier addresses, the pcs do not depend on them
+	teal = bytes.ReplaceAll(teal, []byte("TMPL_DEPOSIT_VERIFIER_ADDRESS"),
+		[]byte("0x"+hex.EncodeToString(c.App.DepositVerifier.Address[:])))
+	teal = bytes.ReplaceAll(teal, []byte("TMPL_WITHDRAWAL_VERIFIER_ADDRESS"),
+		[]byte("0x"+hex.EncodeToString(c.App.WithdrawalVerifier.Address[:])))
+
+	result, err := c.algod.TealCompile(ctx, teal, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile approval program: %v", err)
+	}
+	if result.Sourcemap == nil {
+		return nil, fmt.Errorf("no source map for the approval program")
+	}
+	mappings, ok := (*result.Sourcemap)["mappings"].(string)
+	if !ok {
+		return nil, fmt.Errorf("no mappings in the approval program source map")
+	}
+	pcLines, err := decodeSourceMap(mappings)
+	if err != nil {
+		return nil, err
+	}
+	return &programSource{lines: strings.Split(string(teal), "\n"), pcLines: pcLines}, nil
+}
+
+// decodeSourceMap returns the source line of each pc from the mappings of a source map
+// as generated by algod, with a segment per pc, empty if no opcode starts there, whose
+// third field is the source line relative to the previous segment
+func decodeSourceMap(mappings string) ([]int, error) {
+	var pcLines []int
+	line := 0
+	for pc, segment := range strings.Split(mappings, ";") {
+		if segment == "" {
+			pcLines = append(pcLines, -1)
+			continue
+		}
+		fields, err := decodeVLQ(segment)
+		if err != nil || len(fields) < 3 {
+			return nil, fmt.Errorf("invalid source map segment %q at pc %d", segment, pc)
+		}
+		line += fields[2]
+		pcLines = append(pcLines, line)
+	}
+	return pcLines, nil
+}
+
+// decodeVLQ decodes the base64 VLQ values of a source map segment
+func decodeVLQ(segment string) ([]int, error) {
+	const digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	var values []int
+	value, shift := 0, 0
+	for _, r := range segment {
+		digit := strings.IndexRune(digits, r)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base64 digit %q", r)
+		}
+		value += (digit & 31) << shift
+		if digit&32 != 0 { // continuation bit
+			shift += 5
+			continue
+		}
+		// the lowest bit is the sign
+		if value&1 != 0 {
+			values = append(values, -(value >> 1))
+		} else {
+			values = append(values, value>>1)
+		}
+		value, shift = 0, 0
+	}
+	if shift != 0 {
+		return nil, fmt.Errorf("truncated value")
+	}
+	return values, nil
+}
+
+// DecodeSignedGroup decodes a msgpack encoded signed txn group
+func DecodeSignedGroup(signedGroup []byte) ([]types.SignedTxn, error) {
+	var group []types.SignedTxn
+	decoder := msgpack.NewDecoder(bytes.NewReader(signedGroup))
+	for {
+		var stxn types.SignedTxn
+		err := decoder.Decode(&stxn)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("msgpack decode error: %v", err)
+		}
+		group = append(group, stxn)
+	}
+	if len(group) == 0 {
+		return nil, errors.New("empty txn group")
+	}
+	return group, nil
+}
@@ -0,0 +1,112 @@
+package avm
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecodeVLQ(t *testing.T) {
+	for _, test := range []struct {
+		segment string
+		values  []int
+	}{
+		{"A", []int{0}},
+		{"C", []int{1}},
+		{"D", []int{-1}},
+		{"AAAA", []int{0, 0, 0, 0}},
+		{"AACA", []int{0, 0, 1, 0}},
+		{"AAFA", []int{0, 0, -2, 0}},
+		{"gB", []int{16}},
+		{"2H", []int{123}},
+		{"/D", []int{-63}},
+		{"AA2HA", []int{0, 0, 123, 0}},
+	} {
+		values, err := decodeVLQ(test.segment)
+		if err != nil || !slices.Equal(values, test.values) {
+			t.Errorf("decodeVLQ(%s) = %v, %v, want %v", test.segment, values, err, test.values)
+		}
+	}
+	for _, segment := range []string{"A!", "AA A", "g", "AA2"} {
+		if values, err := decodeVLQ(segment); err == nil {
+			t.Errorf("decodeVLQ(%s) = %v, expected an error", segment, values)
+		}
+	}
+}
+
+func TestDecodeSourceMap(t *testing.T) {
+	for _, test := range []struct {
+		mappings string
+		pcLines  []int
+	}{
+		{"AAAA", []int{0}},
+		{"AAAA;AACA;;AACA;AADA;AAEA", []int{0, 1, -1, 2, 1, 3}},
+		{";;AAGA;;", []int{-1, -1, 3, -1, -1}},
+		{"AAAA;AA2HA;AAAA", []int{0, 123, 123}},
+	} {
+		pcLines, err := decodeSourceMap(test.mappings)
+		if err != nil || !slices.Equal(pcLines, test.pcLines) {
+			t.Errorf("decodeSourceMap(%s) = %v, %v, want %v", test.mappings, pcLines, err,
+				test.pcLines)
+		}
+	}
+	for _, mappings := range []string{"AAAA;AA", "AAAA;A!AA", "AAAA;AAg"} {
+		if pcLines, err := decodeSourceMap(mappings); err == nil {
+			t.Errorf("decodeSourceMap(%s) = %v, expected an error", mappings, pcLines)
+		}
+	}
+}
+
+func TestApprovalLine(t *testing.T) {
+	source := "#pragma version 10\nintcblock 0 1\ntxn NumAppArgs\nassert // Invalid root"
+	pcLines, err := decodeSourceMap("AAAA;AACA;;;AACA;AACA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{approval: &programSource{
+		lines:   strings.Split(source, "\n"),
+		pcLines: pcLines,
+	}}
+	for _, test := range []struct {
+		pc   int
+		line string
+	}{
+		{0, "#pragma version 10"},
+		{1, "intcblock 0 1"},
+		{4, "txn NumAppArgs"},
+		{5, "assert // Invalid root"},
+	} {
+		line, err := c.approvalLine(context.Background(), test.pc)
+		if err != nil || line != test.line {
+			t.Errorf("pc %d: got %q, %v, want %q", test.pc, line, err, test.line)
+		}
+	}
+	// pcs 2 and 3 are within the intcblock opcode, 6 is past the program
+	for _, pc := range []int{2, 3, 6} {
+		if line, err := c.approvalLine(context.Background(), pc); err == nil {
+			t.Errorf("pc %d: got %q, expected an error", pc, line)
+		}
+	}
+}
+
+func TestSimulationError(t *testing.T) {
+	for _, test := range []struct {
+		reason  string
+		message string
+		errType SendTxnErrorType
+	}{
+		{nullifierExistsError, "logic eval error: assert failed pc=459", ErrNoteSpent},
+		{invalidRootError, "logic eval error: assert failed pc=312", ErrRootExpired},
+		{"", "logic eval error: assert failed pc=12", ErrRejected},
+		{"", "overspend (account X, data {...})", ErrOverSpend},
+	} {
+		f := &SimulationFailure{TxnIndex: 1, PC: 12, Reason: test.reason,
+			Message: test.message}
+		err := simulationError(f)
+		if err.Type != test.errType || err.Simulation != f ||
+			!strings.HasPrefix(err.Message, "simulation failed: txn 1 failed at pc 12") {
+			t.Errorf("%q: got %v, want a %s", test.message, err, test.errType)
+		}
+	}
+}
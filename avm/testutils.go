@@ -5,12 +5,18 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/zkp/circuits"
+
 	"github.com/algorand/go-algorand-sdk/v2/client/kmd"
 	"github.com/algorand/go-algorand-sdk/v2/crypto"
 	"github.com/algorand/go-algorand-sdk/v2/transaction"
 	"github.com/algorand/go-algorand-sdk/v2/types"
 
 	"github.com/algorand/go-algorand-sdk/v2/client/v2/algod"
+	"github.com/giuliop/algoplonk"
+	"github.com/giuliop/algoplonk/setup"
 )
 
 type kmdConfig struct {
@@ -27,9 +33,6 @@ var devnetKmdConfig = kmdConfig{
 	Password: "",
 }
 
-func init() {
-}
-
 func devnetAlgodClient() *algod.Client {
 	algodClient, err := algod.MakeClient(
 		"http://localhost:4001",
@@ -141,3 +144,25 @@ func getDevNetDefaultAccount() *crypto.Account {
 
 	return &accts[0]
 }
+
+// LoadTestApp sets up the app instance from the app setup files in appSetupDirPath like
+// LoadApp, but compiles the circuits with a test only setup instead of reading the
+// compiled ones. The proofs it makes are only accepted by a fake algod, not by the
+// deployed verifiers. Compiling the circuits takes a while
+func LoadTestApp(appSetupDirPath string) (*models.App, error) {
+	app, err := loadAppWithoutCircuits(appSetupDirPath)
+	if err != nil {
+		return nil, err
+	}
+	app.DepositCc, err = algoplonk.Compile(&circuits.DepositCircuit{}, config.Curve,
+		setup.TestOnly)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling deposit circuit: %v", err)
+	}
+	app.WithdrawalCc, err = algoplonk.Compile(&circuits.WithdrawalCircuit{}, config.Curve,
+		setup.TestOnly)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling withdrawal circuit: %v", err)
+	}
+	return app, nil
+}
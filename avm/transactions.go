@@ -1,14 +1,15 @@
 package avm
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 
 	"github.com/giuliop/HermesVault-frontend/config"
 	"github.com/giuliop/HermesVault-frontend/models"
-	"github.com/giuliop/HermesVault-frontend/zkp"
 	"github.com/giuliop/HermesVault-frontend/zkp/circuits"
 
 	sdk_models "github.com/algorand/go-algorand-sdk/v2/client/v2/common/models"
@@ -23,21 +24,55 @@ import (
 //  2. the deposit transaction to the contract address to be signed by the user
 //  3. the additional app call transactions needed to meet the opcode budget to be signed
 //     by the TSS account
-func CreateDepositTxns(amount models.Amount, userAddress models.Address, note *models.Note,
+//
+// The number of additional transactions and the fee paid by the user are the least needed
+// according to a simulation of the group, or the config defaults if it is not available.
+// wholeBalanceFee is the fee quoted by DepositFee to the user with the max deposit, 0 if
+// none: if amount is the whole account balance net of it, the group is the one paying that
+// fee and closes the account into the contract, or the error is ErrDepositFeeChanged if
+// that fee is no longer offered.
+// The zk proof is queued in the client prover; if ctx is done while it waits the proof is
+// dropped. If the prover queue is full the error wraps a *zkp.BusyError
+func (c *Client) CreateDepositTxns(ctx context.Context, amount models.Amount,
+	userAddress models.Address, note *models.Note, wholeBalanceFee uint64,
 ) ([]types.Transaction, error) {
 
+	algod := c.AlgodClient()
+	// wholeBalanceSize is the size of the group if the user is sending the whole account
+	// balance net of the quoted fee, which must be paid exactly so it is not sized again
+	var wholeBalanceSize *groupSize
+	if wholeBalanceFee != 0 {
+		accountInfo, err := algod.AccountInformation(context.Background(),
+			string(userAddress))
+		if err != nil {
+			log.Printf("failed to get account information: - %v -; "+
+				"proceeding without full-balance close-out optimization", err)
+		} else if accountInfo.Amount >= wholeBalanceFee &&
+			amount.Microalgos == accountInfo.Amount-wholeBalanceFee {
+			for _, size := range []groupSize{c.lastDepositSize(), defaultDepositSize} {
+				if size.fee == wholeBalanceFee {
+					wholeBalanceSize = &size
+					break
+				}
+			}
+			if wholeBalanceSize == nil {
+				return nil, ErrDepositFeeChanged
+			}
+		}
+	}
+
 	assignment := &circuits.DepositCircuit{
 		Amount:     amount.Microalgos,
 		Commitment: note.Commitment(),
 		K:          note.K[:],
 		R:          note.R[:],
 	}
-	zkArgs, err := zkp.ZkArgs(assignment, App.DepositCc)
+	zkArgs, err := c.prover.Prove(ctx, "deposit", assignment, c.App.DepositCc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get zk args for deposit: %v", err)
+		return nil, fmt.Errorf("failed to get zk args for deposit: %w", err)
 	}
 
-	depositMethod, err := App.Schema.Contract.GetMethodByName(config.DepositMethodName)
+	depositMethod, err := c.App.Schema.Contract.GetMethodByName(config.DepositMethodName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get method %s: %v", config.DepositMethodName, err)
 	}
@@ -50,50 +85,43 @@ func CreateDepositTxns(amount models.Amount, userAddress models.Address, note *m
 	}
 	appArgs = append(appArgs, addressBytes[:])
 
-	algod := AlgodClient()
-	sp, err := algod.SuggestedParams().Do(context.Background())
+	sp, err := algod.SuggestedParams(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get suggested params: %v", err)
 	}
 	sp.Fee = 0
 	sp.FlatFee = true
-	sp.LastRoundValid = sp.FirstRoundValid + config.WaitRounds
+	sp.LastRoundValid = sp.FirstRoundValid + types.Round(config.WaitRounds)
 
 	// txn1 is the app call signed by the deposit verifier with the zk proof
 	txn1, err := transaction.MakeApplicationNoOpTxWithBoxes(
-		App.Id,
+		c.App.Id,
 		appArgs,
 		nil, nil, nil, // foreignAccounts, foreignApps, foreignAssets
 		[]types.AppBoxReference{
-			{AppID: App.Id, Name: []byte("subtree")},
-			{AppID: App.Id, Name: []byte("subtree")},
-			{AppID: App.Id, Name: []byte("roots")},
-			{AppID: App.Id, Name: []byte("roots")},
+			{AppID: c.App.Id, Name: []byte("subtree")},
+			{AppID: c.App.Id, Name: []byte("subtree")},
+			{AppID: c.App.Id, Name: []byte("roots")},
+			{AppID: c.App.Id, Name: []byte("roots")},
 		},
 		sp,
-		App.DepositVerifier.Address, // sender
-		nil,                         // note
-		types.Digest{},              // group
-		[32]byte{},                  // lease
-		types.ZeroAddress,           // RekeyTo
+		c.App.DepositVerifier.Address, // sender
+		nil,                           // note
+		types.Digest{},                // group
+		[32]byte{},                    // lease
+		types.ZeroAddress,             // RekeyTo
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make application call txn: %v", err)
 	}
 
-	// txn2 is the deposit transaction to the contract address signed by the user
-	txnFee := types.MicroAlgos(transaction.MinTxnFee * config.DepositMinFeeMultiplier)
-	contractAddress := crypto.GetApplicationAddress(App.Id).String()
+	// txn2 is the deposit transaction to the contract address signed by the user, which
+	// pays the fees of the group
+	contractAddress := crypto.GetApplicationAddress(c.App.Id).String()
 	closeRemainderTo := types.ZeroAddress.String()
-	accountInfo, err := algod.AccountInformation(string(userAddress)).
-		Do(context.Background())
-	if err != nil {
-		log.Printf("failed to get account information: - %v -; "+
-			"proceeding without full-balance close-out optimization", err)
-	} else if accountInfo.Amount >= uint64(txnFee) &&
-		amount.Microalgos == accountInfo.Amount-uint64(txnFee) {
-		// If the user is sending the whole account balance (net of txn fee),
-		// close the account into the contract.
+	if wholeBalanceSize != nil {
+		// the user is sending the whole account balance, close the account into the
+		// contract
 		closeRemainderTo = contractAddress
 	}
 
@@ -108,106 +136,169 @@ func CreateDepositTxns(amount models.Amount, userAddress models.Address, note *m
 	if err != nil {
 		return nil, fmt.Errorf("failed to make payment txn: %v", err)
 	}
-	txn2.Fee = txnFee
 
 	// additional transactions needed to meet the opcode budget
 	// we make them app calls to count also for smart contract opcode pooling.
-	txnNeeded := config.VerifierTopLevelTxnNeeded - 2 // 2 transactions already added
-	noopMethod, err := App.Schema.Contract.GetMethodByName(config.NoOpMethodName)
+	noopMethod, err := c.App.Schema.Contract.GetMethodByName(config.NoOpMethodName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get method %s: %v", config.NoOpMethodName, err)
 	}
 	args := [][]byte{noopMethod.GetSelector()}
 
-	txns := []types.Transaction{txn1, txn2}
-	for i := range txnNeeded {
-		txn, err := transaction.MakeApplicationNoOpTx(
-			App.Id,
-			append(args, []byte{byte(i)}), // args
-			nil, nil, nil,                 // foreignAccounts, foreignApps, foreignAssets
-			sp,
-			App.TSS.Address,   // sender
-			nil,               // note
-			types.Digest{},    // group
-			[32]byte{},        // lease
-			types.ZeroAddress, // rekeyTo
-		)
+	build := func(size groupSize) ([]types.Transaction, error) {
+		txn2.Fee = types.MicroAlgos(size.fee)
+		txns := []types.Transaction{txn1, txn2}
+		for i := range size.txns - 2 { // 2 transactions already added
+			txn, err := transaction.MakeApplicationNoOpTx(
+				c.App.Id,
+				append(args, []byte{byte(i)}), // args
+				nil, nil, nil,                 // foreignAccounts, foreignApps, foreignAssets
+				sp,
+				c.App.TSS.Address, // sender
+				nil,               // note
+				types.Digest{},    // group
+				[32]byte{},        // lease
+				types.ZeroAddress, // rekeyTo
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to make application call txn: %v", err)
+			}
+			txns = append(txns, txn)
+		}
+
+		groupID, err := crypto.ComputeGroupID(txns)
 		if err != nil {
-			return nil, fmt.Errorf("failed to make application call txn: %v", err)
+			return nil, fmt.Errorf("failed to compute group id: %v", err)
 		}
-		txns = append(txns, txn)
+		for i := range txns {
+			txns[i].Group = groupID
+		}
+		return txns, nil
 	}
 
-	groupID, err := crypto.ComputeGroupID(txns)
-	if err != nil {
-		return nil, fmt.Errorf("failed to compute group id: %v", err)
+	// a whole balance deposit must pay the fee it is net of, so it is not sized again
+	if wholeBalanceSize != nil {
+		return build(*wholeBalanceSize)
 	}
-	for i := range txns {
-		txns[i].Group = groupID
+	size := c.sizeGroup(ctx, 2, defaultDepositSize, build)
+	c.depositSizeMu.Lock()
+	c.depositSize = &size
+	c.depositSizeMu.Unlock()
+	return build(size)
+}
+
+// ErrDepositFeeChanged is returned when creating a deposit of the whole balance net of a
+// fee quoted by DepositFee that is no longer offered, since a later deposit was sized
+// differently
+var ErrDepositFeeChanged = errors.New("deposit fee changed since it was quoted")
+
+// defaultDepositSize is the deposit group size used when it cannot be simulated
+var defaultDepositSize = groupSize{
+	txns: config.VerifierTopLevelTxnNeeded,
+	fee:  transaction.MinTxnFee * config.DepositMinFeeMultiplier,
+}
+
+// lastDepositSize returns the size of the last deposit group, or the default one if no
+// deposit was made yet
+func (c *Client) lastDepositSize() groupSize {
+	c.depositSizeMu.Lock()
+	defer c.depositSizeMu.Unlock()
+	if c.depositSize == nil {
+		return defaultDepositSize
 	}
+	return *c.depositSize
+}
 
-	return txns, nil
+// DepositFee returns the fee to quote for a deposit group, paid by the user on top of the
+// amount: the fee of the last deposit group sized, which can change with the next one, so
+// the fee quoted must be passed back to CreateDepositTxns to deposit the whole balance net
+// of it
+func (c *Client) DepositFee() uint64 {
+	return c.lastDepositSize().fee
 }
 
-// SendDepositToNetwork sends the deposit transactions to the network.
-// It returns the leaf index of the deposit note, the ID of the first group txn, and any error
-func SendDepositToNetwork(txns []types.Transaction, userSignedTxn []byte,
-) (leafIndex uint64, txnId string, txnConfirmationError *TxnConfirmationError) {
-	algod := AlgodClient()
+// SendDepositToNetwork sends the deposit transactions to the network, after simulating
+// them to fail with a precise error type without sending them if they would be rejected.
+// It returns the leaf index of the deposit note, the ID of the first group txn, the round
+// it was confirmed in, and any error
+func (c *Client) SendDepositToNetwork(txns []types.Transaction, userSignedTxn []byte,
+) (leafIndex uint64, txnId string, round uint64,
+	txnConfirmationError *TxnConfirmationError) {
+	algod := c.AlgodClient()
 	signedGroup := []byte{}
 	// sign the deposit app call transaction with the deposit verifier
-	_, signed1, err := crypto.SignLogicSigAccountTransaction(App.DepositVerifier.Account,
-		txns[0])
+	_, signed1, err := crypto.SignLogicSigAccountTransaction(
+		c.App.DepositVerifier.Account, txns[0])
 	if err != nil {
-		return 0, "", InternalError("failed to sign app call txn: " + err.Error())
+		return 0, "", 0, InternalError("failed to sign app call txn: " + err.Error())
 	}
 	signedGroup = append(signedGroup, signed1...)
 	// the second transaction is the one signed by the user
 	signedGroup = append(signedGroup, userSignedTxn...)
 	// then sign the noop transactions for the opcode budget with the TSS account
 	for i := 2; i < len(txns); i++ {
-		_, signed, err := crypto.SignLogicSigAccountTransaction(App.TSS.Account, txns[i])
+		_, signed, err := crypto.SignLogicSigAccountTransaction(c.App.TSS.Account, txns[i])
 		if err != nil {
-			return 0, "", InternalError("failed to sign app call txn: " + err.Error())
+			return 0, "", 0, InternalError("failed to sign app call txn: " + err.Error())
 		}
 		signedGroup = append(signedGroup, signed...)
 	}
 
+	// simulate the transactions first, to refuse them with the reason they would fail
+	if err := c.simulateGroup(context.Background(), signedGroup); err != nil {
+		return 0, "", 0, err
+	}
 	// now send the transactions to the network
-	_, err = algod.SendRawTransaction(signedGroup).Do(context.Background())
+	_, err = algod.SendRawTransaction(context.Background(), signedGroup)
 	if err != nil {
-		return 0, "", parseSendTransactionError(err)
+		return 0, "", 0, parseSendTransactionError(err)
 	}
 	// we wait on te first transaction, the deposit app call, to get the leaf index
 	depositAppCallTxnId := crypto.GetTxID(txns[0])
-	confirmedTxn, err := transaction.WaitForConfirmation(algod, depositAppCallTxnId,
-		config.WaitRounds, context.Background())
+	confirmedTxn, err := waitForConfirmation(context.Background(), algod,
+		depositAppCallTxnId, config.WaitRounds)
 	if err != nil {
-		return 0, "", parseWaitForConfirmationError(err)
+		return 0, "", 0, parseWaitForConfirmationError(err)
 	}
 	leafIndex, _, err = getLeafIndexAndRoot(confirmedTxn)
 	if err != nil {
-		return 0, "", InternalError("failed to get leaf index: " + err.Error())
+		return 0, "", 0, InternalError("failed to get leaf index: " + err.Error())
 	}
 
-	return leafIndex, depositAppCallTxnId, nil
+	return leafIndex, depositAppCallTxnId, confirmedTxn.ConfirmedRound, nil
 }
 
-// CreateWithdrawalTxns creates the txn group to make a withdrawal on chain
-func CreateWithdrawalTxns(w *models.WithdrawalData) ([]types.Transaction, error) {
+// CreateWithdrawalTxns creates the txn group to make a withdrawal on chain.
+// The proof is built against w.Root if set, which must be one of the recent roots still
+// accepted by the contract, otherwise against the latest root; w.Root is set to the root used.
+// The zk proof is queued in the client prover and the group sized like for
+// CreateDepositTxns, with the fees paid by the TSS.
+// Before proving it fails with ErrNullifierSpent if the note withdrawn is already spent and
+// with ErrTreeDiverged if the merkle tree does not match the app roots onchain
+func (c *Client) CreateWithdrawalTxns(ctx context.Context, w *models.WithdrawalData,
+) ([]types.Transaction, error) {
 	if w.FromNote.LeafIndex == models.EmptyLeafIndex {
 		return nil, fmt.Errorf("empty leaf index")
 	}
 
-	root, err := getRoot(w.FromNote.LeafIndex)
+	// a spent note would be rejected by the app, no need to build the proof to find out
+	spent, err := c.IsNullifierSpent(ctx, w.FromNote.Nullifier())
 	if err != nil {
-		return nil, fmt.Errorf("failed to get root: %v", err)
+		return nil, err
 	}
-
-	merkleProof, err := createMerkleProof(w.FromNote.LeafValue(), w.FromNote.LeafIndex, root)
+	if spent {
+		return nil, ErrNullifierSpent
+	}
+	// no proof built from a tree diverged from the chain would be accepted
+	if _, err := c.CheckTreeOnchain(ctx); err != nil {
+		return nil, fmt.Errorf("failed to check merkle tree onchain: %w", err)
+	}
+	merkleProof, root, err := c.createMerkleProof(w.FromNote.LeafValue(), w.FromNote.LeafIndex,
+		w.Root)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create merkle proof: %v", err)
 	}
+	w.Root = root
 	var path [config.MerkleTreeLevels + 1]frontend.Variable
 	for i, v := range merkleProof {
 		path[i] = v
@@ -234,12 +325,13 @@ func CreateWithdrawalTxns(w *models.WithdrawalData) ([]types.Transaction, error)
 		Index:      w.FromNote.LeafIndex,
 		Path:       path,
 	}
-	zkArgs, err := zkp.ZkArgs(assignment, App.WithdrawalCc)
+	zkArgs, err := c.prover.Prove(ctx, "withdrawal", assignment, c.App.WithdrawalCc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get zk args for withdrawal: %v", err)
+		return nil, fmt.Errorf("failed to get zk args for withdrawal: %w", err)
 	}
 
-	withdrawalMethod, err := App.Schema.Contract.GetMethodByName(config.WithDrawalMethodName)
+	withdrawalMethod, err := c.App.Schema.Contract.GetMethodByName(
+		config.WithDrawalMethodName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get method %s: %v",
 			config.WithDrawalMethodName, err)
@@ -251,137 +343,168 @@ func CreateWithdrawalTxns(w *models.WithdrawalData) ([]types.Transaction, error)
 	withdrawalRecipientPosInForeignAccounts := 1
 	args = append(args, []byte{byte(withdrawalRecipientPosInForeignAccounts)})
 
-	// the fee recipient is the TSS account which will pay the fees
-	feeRecipient := App.TSS.Address
+	// the fee recipient is the TSS account which will pay the fees, unless the fees are
+	// configured to go to another address: the contract sends it the whole fee, so the TSS
+	// is not refunded, which the config requires to be confirmed with FeeRecipientNoRefund
+	feeRecipient := c.App.TSS.Address
+	if !config.Fees.Recipient.IsZero() {
+		feeRecipient = config.Fees.Recipient
+	}
 	foreignAccounts = append(foreignAccounts, feeRecipient.String())
 	feeRecipientPosInForeignAccounts := 2
 	args = append(args, []byte{byte(feeRecipientPosInForeignAccounts)})
 
-	// TODO: let the user set noChange
-	noChange := false
-	noChangeAbi, err := abiEncode(noChange, "bool")
+	noChangeAbi, err := abiEncode(w.NoChange, "bool")
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode noChange: %v", err)
 	}
 
 	args = append(args, noChangeAbi)
 
-	algod := AlgodClient()
-	sp, err := algod.SuggestedParams().Do(context.Background())
+	algod := c.AlgodClient()
+	sp, err := algod.SuggestedParams(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get suggested params: %v", err)
 	}
 	sp.Fee = 0
 	sp.FlatFee = true
-	sp.LastRoundValid = sp.FirstRoundValid + config.WaitRounds
+	sp.LastRoundValid = sp.FirstRoundValid + types.Round(config.WaitRounds)
 
 	// txn1 is the app call signed by the withdrawal verifier with the zk proof
 	txn1, err := transaction.MakeApplicationNoOpTxWithBoxes(
-		App.Id,
+		c.App.Id,
 		args,
 		foreignAccounts,
 		nil, nil, // foreignApps, foreignAssets
 		[]types.AppBoxReference{
-			{AppID: App.Id, Name: w.FromNote.Nullifier()},
-			{AppID: App.Id, Name: []byte("subtree")},
-			{AppID: App.Id, Name: []byte("roots")},
-			{AppID: App.Id, Name: []byte("roots")},
+			{AppID: c.App.Id, Name: w.FromNote.Nullifier()},
+			{AppID: c.App.Id, Name: []byte("subtree")},
+			{AppID: c.App.Id, Name: []byte("roots")},
+			{AppID: c.App.Id, Name: []byte("roots")},
 		},
 		sp,
-		App.WithdrawalVerifier.Address, // sender
-		nil,                            // note
-		types.Digest{},                 // group
-		[32]byte{},                     // lease
-		types.ZeroAddress,              // RekeyTo
+		c.App.WithdrawalVerifier.Address, // sender
+		nil,                              // note
+		types.Digest{},                   // group
+		[32]byte{},                       // lease
+		types.ZeroAddress,                // RekeyTo
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make application call txn: %v", err)
 	}
 
-	// now we add noop transactions signed by the feeRecipient,
+	// now we add noop transactions signed by the TSS,
 	// the first to pay the fees and the others to meet the opcode budget
-	noopMethod, err := App.Schema.Contract.GetMethodByName(config.NoOpMethodName)
+	noopMethod, err := c.App.Schema.Contract.GetMethodByName(config.NoOpMethodName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get method %s: %v", config.NoOpMethodName, err)
 	}
+	fallback := groupSize{
+		txns: config.VerifierTopLevelTxnNeeded,
+		fee:  transaction.MinTxnFee * config.WithdrawalMinFeeMultiplier,
+	}
+
+	build := func(size groupSize) ([]types.Transaction, error) {
+		txns := []types.Transaction{txn1}
+		for i := range size.txns - 1 { // 1 transaction already added
+			args := [][]byte{noopMethod.GetSelector()}
+			txn, err := transaction.MakeApplicationNoOpTx(
+				c.App.Id,
+				append(args, []byte{byte(i)}),
+				nil, nil, nil, // foreign accounts, foreignApps, foreignAssets
+				sp,
+				c.App.TSS.Address, // sender
+				nil,               // note
+				types.Digest{},    // group
+				[32]byte{},        // lease
+				types.ZeroAddress, // RekeyTo
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to make application call txn: %v", err)
+			}
+			txns = append(txns, txn)
+		}
+		// set the fee for the first noop transaction
+		txns[1].Fee = types.MicroAlgos(size.fee)
 
-	txns := []types.Transaction{txn1}
-	txnNeeded := config.VerifierTopLevelTxnNeeded - 1 // 1 transaction already added
-
-	for i := range txnNeeded {
-		args := [][]byte{noopMethod.GetSelector()}
-		txn, err := transaction.MakeApplicationNoOpTx(
-			App.Id,
-			append(args, []byte{byte(i)}),
-			nil, nil, nil, // foreign accounts, foreignApps, foreignAssets
-			sp,
-			feeRecipient,      // sender
-			nil,               // note
-			types.Digest{},    // group
-			[32]byte{},        // lease
-			types.ZeroAddress, // RekeyTo
-		)
+		groupID, err := crypto.ComputeGroupID(txns)
 		if err != nil {
-			return nil, fmt.Errorf("failed to make application call txn: %v", err)
+			return nil, fmt.Errorf("failed to compute group id: %v ", err)
 		}
-		txns = append(txns, txn)
-	}
-	// set the fee for the first noop transaction
-	txns[1].Fee = transaction.MinTxnFee * config.WithdrawalMinFeeMultiplier
-
-	groupID, err := crypto.ComputeGroupID(txns)
-	if err != nil {
-		return nil, fmt.Errorf("failed to compute group id: %v ", err)
-	}
-	for i := range txns {
-		txns[i].Group = groupID
+		for i := range txns {
+			txns[i].Group = groupID
+		}
+		return txns, nil
 	}
 
-	return txns, nil
+	// the first noop paying the fees is always needed
+	return build(c.sizeGroup(ctx, 2, fallback, build))
 }
 
-// SendWithdrawalToNetworkWithTSS sends the withdrawal txns to the network signed by the TSS.
-// It returns the leaf index of the change note, the ID of the first group txn, and any error
-func SendWithdrawalToNetworkWithTSS(txns []types.Transaction,
-) (leafIndex uint64, txnId string, txnConfirmationError *TxnConfirmationError) {
+// SendWithdrawalToNetworkWithTSS sends the withdrawal txns to the network signed by the TSS,
+// after simulating them like SendDepositToNetwork.
+// It returns the leaf index of the change note, the ID of the first group txn, the round it
+// was confirmed in, and any error.
+// For a withdrawal with no change the leaf index is models.EmptyLeafIndex
+func (c *Client) SendWithdrawalToNetworkWithTSS(txns []types.Transaction,
+) (leafIndex uint64, txnId string, round uint64,
+	txnConfirmationError *TxnConfirmationError) {
 
-	algod := AlgodClient()
+	algod := c.AlgodClient()
 	// sign the withdrawal app call transaction with the withdrawal verifier
 	signedGroup := []byte{}
-	_, signed1, err := crypto.SignLogicSigAccountTransaction(App.WithdrawalVerifier.Account,
-		txns[0])
+	_, signed1, err := crypto.SignLogicSigAccountTransaction(
+		c.App.WithdrawalVerifier.Account, txns[0])
 	if err != nil {
-		return 0, "", InternalError("failed to sign app call txn: " + err.Error())
+		return 0, "", 0, InternalError("failed to sign app call txn: " + err.Error())
 	}
 	signedGroup = append(signedGroup, signed1...)
 
 	// sign the rest with the TSS
 	for i := 1; i < len(txns); i++ {
-		_, signed, err := crypto.SignLogicSigAccountTransaction(App.TSS.Account, txns[i])
+		_, signed, err := crypto.SignLogicSigAccountTransaction(c.App.TSS.Account, txns[i])
 		if err != nil {
-			return 0, "", InternalError("failed to sign app call txn: " + err.Error())
+			return 0, "", 0, InternalError("failed to sign app call txn: " + err.Error())
 		}
 		signedGroup = append(signedGroup, signed...)
 	}
 
+	// simulate the transactions first, to refuse them with the reason they would fail
+	if err := c.simulateGroup(context.Background(), signedGroup); err != nil {
+		return 0, "", 0, err
+	}
 	// now send the transactions to the network
-	_, err = algod.SendRawTransaction(signedGroup).Do(context.Background())
+	_, err = algod.SendRawTransaction(context.Background(), signedGroup)
 	if err != nil {
-		return 0, "", parseSendTransactionError(err)
+		return 0, "", 0, parseSendTransactionError(err)
 	}
 
 	// we wait on te first transaction, the withdrawal app call, to get the leaf index
 	withdrawalAppCallTxnId := crypto.GetTxID(txns[0])
-	confirmedTxn, err := transaction.WaitForConfirmation(algod, withdrawalAppCallTxnId,
-		config.WaitRounds, context.Background())
+	confirmedTxn, err := waitForConfirmation(context.Background(), algod,
+		withdrawalAppCallTxnId, config.WaitRounds)
 	if err != nil {
-		return 0, "", parseWaitForConfirmationError(err)
+		return 0, "", 0, parseWaitForConfirmationError(err)
+	}
+	if isNoChangeWithdrawal(txns[0]) {
+		return models.EmptyLeafIndex, withdrawalAppCallTxnId, confirmedTxn.ConfirmedRound, nil
 	}
 	leafIndex, _, err = getLeafIndexAndRoot(confirmedTxn)
 	if err != nil {
-		return 0, "", InternalError("failed to get leaf index: " + err.Error())
+		return 0, "", 0, InternalError("failed to get leaf index: " + err.Error())
+	}
+	return leafIndex, withdrawalAppCallTxnId, confirmedTxn.ConfirmedRound, nil
+}
+
+// isNoChangeWithdrawal returns true if the withdrawal app call has the noChange arg set,
+// which is the last one
+func isNoChangeWithdrawal(txn types.Transaction) bool {
+	args := txn.ApplicationArgs
+	trueAbi, err := abiEncode(true, "bool")
+	if err != nil || len(args) == 0 {
+		return false
 	}
-	return leafIndex, withdrawalAppCallTxnId, nil
+	return bytes.Equal(args[len(args)-1], trueAbi)
 }
 
 // getLeafIndexAndRoot extracts the leaf index and root from the transaction result
@@ -390,9 +513,15 @@ func getLeafIndexAndRoot(txn sdk_models.PendingTransactionInfoResponse,
 	if len(txn.Logs) == 0 {
 		return 0, root, fmt.Errorf("no logs in transaction")
 	}
-	abiBytes := txn.Logs[len(txn.Logs)-1]
+	return ParseLeafIndexAndRoot(txn.Logs[len(txn.Logs)-1])
+}
+
+// ParseLeafIndexAndRoot parses the leaf index and root from the arc4 return value of a
+// deposit or withdrawal app call, its last log, with signature (uint64,byte[32])
+func ParseLeafIndexAndRoot(abiBytes []byte) (leafIndex uint64, root [32]byte, err error) {
 	if len(abiBytes) != 4+8+32 {
-		return 0, root, fmt.Errorf("invalid log length: expected 12 bytes, got %d", len(abiBytes))
+		return 0, root, fmt.Errorf("invalid log length: expected 44 bytes, got %d",
+			len(abiBytes))
 	}
 	leafIndex = binary.BigEndian.Uint64(abiBytes[4:12])
 	rootBytes := abiBytes[12:]
@@ -2,10 +2,10 @@ package config
 
 import (
 	"bufio"
+	"fmt"
 	"log"
 	"os"
-	"path/filepath"
-	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -13,7 +13,6 @@ import (
 // webserver constants
 const (
 	CacheControl = "public, max-age=600" // 600 sec = 10 min
-	Port         = "5555"
 )
 
 // other constants
@@ -21,43 +20,90 @@ const (
 	// Number of characters to highlight displaying long strings, e.g. addresses
 	NumCharsToHighlight = 5
 
-	// Number of rounds to wait for a transaction to be confirmed
-	WaitRounds = 30
+	// Maximum number of recipients of a batch withdrawal
+	MaxBatchWithdrawalRecipients = 5
 
-	// Interval between internal db cleanup runs
-	CleanupInterval = 10 * time.Minute // 10 minutes
+	// How long to wait for a newly inserted leaf to appear in the txns database
+	LeafSyncTimeout = 60 * time.Second
+
+	// Timeout of the dependency checks of the readiness endpoint
+	ReadyCheckTimeout = 5 * time.Second
+
+	// Maximum number of rounds the subscriber can be behind algod for the server to be ready
+	MaxSubscriberLag = 20
+
+	// Maximum time since algod saw the last round for the server to be ready
+	MaxTimeSinceLastRound = time.Minute
 )
 
 // Frontend fees
 var (
-	// The frontend withdrawal fee is determined by dividing the withdrawal amount
-	// by this divisor. If zero, there is no frontend fee
-	FrontendWithDrawalFeeDivisor = uint64(0)
+	// The frontend withdrawal fee policy, set by Load. By default there is no frontend fee
+	// and withdrawals only pay WithdrawalMinFee
+	Fees FeePolicy
 )
 
-// file paths
+// file paths and settings, set by Load
 var (
+	// Port the web server listens on
+	Port = "5555"
+
+	// Network profile providing the defaults of the settings, empty for none
+	Profile string
+
 	AppSetupDirPath string
 	InternalDbPath  string
 	TxnsDbPath      string
+	SecretKeyPath   string
+	PublicKeyPath   = "db/encrypt/generate-key/public_key.bin"
 	AlgodPath       string
 	AlgodToken      string
+
+	// If true, the server runs the subscriber writing the txns database itself
+	// instead of relying on a separate subscriber process
+	EmbeddedSubscriber bool
+
+	// Number of zk proofs generated in parallel, each using all the cores available
+	ProverWorkers = 1
+	// Maximum number of zk proofs waiting for a prover worker, after which new deposits
+	// and withdrawals are refused until the queue has room
+	ProverQueueSize = 10
+
+	// Number of rounds to wait for a transaction to be confirmed
+	WaitRounds uint64 = 30
+
+	// Interval between internal db cleanup runs
+	CleanupInterval = 10 * time.Minute
+
+	// Id of this server instance, recorded on the jobs it runs so that at startup it fails
+	// only its own interrupted jobs. Defaults to the hostname
+	InstanceId string
 )
 
-func init() {
-	// determine path to this package’s .env file at runtime
-	_, thisFile, _, _ := runtime.Caller(0)
-	envPath := filepath.Join(filepath.Dir(thisFile), ".env")
-	env, err := LoadEnv(envPath)
-	if err != nil {
-		log.Fatalf("failed to load env: %v", err)
+// envInt returns the positive integer value of key in env, or def if key is not set
+func envInt(env map[string]string, key string, def int) (int, error) {
+	value, ok := env[key]
+	if !ok || value == "" {
+		return def, nil
 	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s, must be a positive integer: %q", key, value)
+	}
+	return n, nil
+}
 
-	AppSetupDirPath = env["AppSetupDirPath"]
-	InternalDbPath = env["InternalDbPath"]
-	TxnsDbPath = env["TxnsDbPath"]
-	AlgodPath = env["AlgodPath"]
-	AlgodToken = env["AlgodToken"]
+// envUint returns the non-negative integer value of key in env, or 0 if key is not set
+func envUint(env map[string]string, key string) (uint64, error) {
+	value, ok := env[key]
+	if !ok || value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s, must be a non-negative integer: %q", key, value)
+	}
+	return n, nil
 }
 
 // LoadEnv reads a set of key-value pairs from a file and returns them as a map
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// FeeTier is the part of a withdrawal amount from From microalgos up to the next tier,
+// charged Bps basis points (1/100 of a percent)
+type FeeTier struct {
+	From uint64
+	Bps  uint64
+}
+
+// FeePolicy is the schedule of the frontend withdrawal fee.
+// The fee is Flat plus, for each tier, its rate on the part of the amount in the tier,
+// raised to Min and capped to Max. It is never lower than WithdrawalMinFee, which pays the
+// network fees
+type FeePolicy struct {
+	Flat  uint64    // microalgos
+	Tiers []FeeTier // ordered by From, empty for no percentage fee
+	Min   uint64    // microalgos
+	Max   uint64    // microalgos, 0 for no cap
+	// Recipient receives the withdrawal fees; if zero, the TSS account paying the network
+	// fees receives them
+	Recipient types.Address
+}
+
+// loadFeePolicy returns the fee policy set in env:
+//   - FeeFlat, FeeMin and FeeMax in microalgos
+//   - FeePercent as a percent of the whole amount, e.g. 0.5
+//   - FeeTiers, instead of FeePercent, as from:percent pairs separated by commas, e.g.
+//     0:1,1000000000:0.5 for 1% of the first 1000 algo and 0.5% of the rest
+//   - FeeRecipient as an Algorand address, only with FeeRecipientNoRefund true since the
+//     contract sends it the whole fee and the TSS account is not refunded of the network
+//     fees it pays
+func loadFeePolicy(env map[string]string) (FeePolicy, error) {
+	var p FeePolicy
+	var err error
+	if p.Flat, err = envUint(env, "FeeFlat"); err != nil {
+		return p, err
+	}
+	if p.Min, err = envUint(env, "FeeMin"); err != nil {
+		return p, err
+	}
+	if p.Max, err = envUint(env, "FeeMax"); err != nil {
+		return p, err
+	}
+
+	switch percent, tiers := env["FeePercent"], env["FeeTiers"]; {
+	case percent != "" && tiers != "":
+		return p, fmt.Errorf("set either FeePercent or FeeTiers, not both")
+	case percent != "":
+		bps, err := parsePercent(percent)
+		if err != nil {
+			return p, fmt.Errorf("invalid FeePercent: %v", err)
+		}
+		p.Tiers = []FeeTier{{From: 0, Bps: bps}}
+	case tiers != "":
+		if p.Tiers, err = parseFeeTiers(tiers); err != nil {
+			return p, fmt.Errorf("invalid FeeTiers: %v", err)
+		}
+	}
+
+	if p.Max != 0 && p.Max < max(p.Min, WithdrawalMinFee) {
+		return p, fmt.Errorf("FeeMax must be at least FeeMin and %d microalgos",
+			WithdrawalMinFee)
+	}
+	if recipient := env["FeeRecipient"]; recipient != "" {
+		if p.Recipient, err = types.DecodeAddress(recipient); err != nil {
+			return p, fmt.Errorf("invalid FeeRecipient: %v", err)
+		}
+	}
+	switch noRefund := env["FeeRecipientNoRefund"]; noRefund {
+	case "", "false":
+		if !p.Recipient.IsZero() {
+			return p, fmt.Errorf("FeeRecipient is set, so the TSS account pays the " +
+				"network fees without being refunded: set FeeRecipientNoRefund to true " +
+				"to confirm and keep the TSS account funded")
+		}
+	case "true":
+	default:
+		return p, fmt.Errorf("invalid FeeRecipientNoRefund, must be true or false: %q",
+			noRefund)
+	}
+	return p, nil
+}
+
+// parseFeeTiers parses from:percent pairs separated by commas, the first from being 0
+func parseFeeTiers(s string) ([]FeeTier, error) {
+	var tiers []FeeTier
+	for _, pair := range strings.Split(s, ",") {
+		from, percent, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("tier %q is not from:percent", pair)
+		}
+		f, err := strconv.ParseUint(strings.TrimSpace(from), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tier start %q", from)
+		}
+		bps, err := parsePercent(percent)
+		if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, FeeTier{From: f, Bps: bps})
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].From < tiers[j].From })
+	if tiers[0].From != 0 {
+		return nil, fmt.Errorf("the first tier must start from 0")
+	}
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].From == tiers[i-1].From {
+			return nil, fmt.Errorf("two tiers start from %d", tiers[i].From)
+		}
+	}
+	return tiers, nil
+}
+
+// parsePercent parses a percent between 0 and 100 into basis points
+func parsePercent(s string) (uint64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("invalid percent %q, must be between 0 and 100", s)
+	}
+	bps := math.Round(percent * 100)
+	if math.Abs(bps-percent*100) > 1e-6 {
+		return 0, fmt.Errorf("invalid percent %q, at most 2 decimals allowed", s)
+	}
+	return uint64(bps), nil
+}
@@ -0,0 +1,333 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultConfigPath is the config file read if none is given, relative to the working
+// directory
+const DefaultConfigPath = "config/.env"
+
+// envPrefix prefixes the environment variables setting the configuration, e.g.
+// HERMESVAULT_ALGOD_PATH sets AlgodPath
+const envPrefix = "HERMESVAULT_"
+
+// setting is a configuration key, which can be set, in increasing order of priority, by
+// the network profile, the config file, an environment variable and a command line flag
+type setting struct {
+	key    string
+	usage  string
+	secret bool // masked when printing the configuration
+	// value returns the effective value, if nil it is the value loaded
+	value func() string
+}
+
+// settings are the configuration keys, in the order they are printed
+var settings = []setting{
+	{key: "Profile", usage: "network profile providing the defaults: localnet, testnet " +
+		"or mainnet", value: func() string { return Profile }},
+	{key: "Port", usage: "port the web server listens on",
+		value: func() string { return Port }},
+	{key: "AppSetupDirPath", usage: "directory of the app setup files",
+		value: func() string { return AppSetupDirPath }},
+	{key: "InternalDbPath", usage: "internal database file",
+		value: func() string { return InternalDbPath }},
+	{key: "TxnsDbPath", usage: "txns database file",
+		value: func() string { return TxnsDbPath }},
+	{key: "SecretKeyPath", usage: "key encrypting the pending deposits (default " +
+		"secret_key.bin next to the internal database)",
+		value: func() string { return SecretKeyPath }},
+	{key: "PublicKeyPath", usage: "public key encrypting the nullifiers",
+		value: func() string { return PublicKeyPath }},
+	{key: "AlgodPath", usage: "algod URL or node directory, Algokit localnet if empty",
+		value: func() string { return AlgodPath }},
+	{key: "AlgodToken", usage: "algod API token", secret: true,
+		value: func() string { return AlgodToken }},
+	{key: "EmbeddedSubscriber", usage: "run the subscriber in the server (true or false)",
+		value: func() string { return strconv.FormatBool(EmbeddedSubscriber) }},
+	{key: "ProverWorkers", usage: "number of zk proofs generated in parallel",
+		value: func() string { return strconv.Itoa(ProverWorkers) }},
+	{key: "ProverQueueSize", usage: "number of zk proofs that can wait for a worker",
+		value: func() string { return strconv.Itoa(ProverQueueSize) }},
+	{key: "WaitRounds", usage: "rounds to wait for a transaction to be confirmed",
+		value: func() string { return strconv.FormatUint(WaitRounds, 10) }},
+	{key: "CleanupInterval", usage: "interval between internal db cleanups, e.g. 10m",
+		value: func() string { return CleanupInterval.String() }},
+	{key: "InstanceId", usage: "id of this server instance among those sharing the " +
+		"internal database (default the hostname)",
+		value: func() string { return InstanceId }},
+	{key: "FeeFlat", usage: "flat withdrawal fee in microalgos"},
+	{key: "FeePercent", usage: "withdrawal fee as a percent of the amount"},
+	{key: "FeeTiers", usage: "withdrawal fee tiers as from:percent pairs"},
+	{key: "FeeMin", usage: "minimum withdrawal fee in microalgos"},
+	{key: "FeeMax", usage: "maximum withdrawal fee in microalgos"},
+	{key: "FeeRecipient", usage: "address receiving the withdrawal fees"},
+	{key: "FeeRecipientNoRefund", usage: "confirm that with FeeRecipient the TSS account " +
+		"is not refunded of the network fees (true or false)"},
+}
+
+// profiles are the defaults of the named network profiles. Paths are relative to the
+// working directory.
+// The localnet profile has no AppSetupDirPath, which must be set to the setup files of the
+// app deployed to the localnet, since each deployment creates a new app
+var profiles = map[string]map[string]string{
+	"localnet": {
+		"InternalDbPath": "data/localnet/internal/internal.db",
+		"TxnsDbPath":     "data/localnet/txns/txns.db",
+		"AlgodPath":      "", // Algokit localnet
+	},
+	"testnet": {
+		"AppSetupDirPath": "avm/testnet",
+		"InternalDbPath":  "data/testnet/internal/internal.db",
+		"TxnsDbPath":      "data/testnet/txns/txns.db",
+		"AlgodPath":       "https://testnet-api.algonode.cloud",
+	},
+	"mainnet": {
+		"AppSetupDirPath": "avm/mainnet",
+		"InternalDbPath":  "data/internal/internal.db",
+		"TxnsDbPath":      "data/txns/txns.db",
+		"AlgodPath":       "https://mainnet-api.algonode.cloud",
+	},
+}
+
+// the command line flags registered by RegisterFlags
+var (
+	configPath string
+	// PrintConfig is set by the -print-config flag, asking to print the configuration
+	// and exit
+	PrintConfig bool
+)
+
+// loaded records the config file read by Load, the values loaded and where each was set
+var loaded struct {
+	path    string
+	values  map[string]string
+	sources map[string]string
+}
+
+// RegisterFlags registers in flagSet the flags selecting the config file, printing the
+// configuration and setting each configuration key, e.g. -algod-path for AlgodPath
+func RegisterFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&configPath, "config", "", "config file (default $"+envPrefix+
+		"CONFIG or "+DefaultConfigPath+" if it exists)")
+	flagSet.BoolVar(&PrintConfig, "print-config", false,
+		"print the effective configuration, with secrets masked, and exit")
+	for _, s := range settings {
+		flagSet.String(flagName(s.key), "", s.usage)
+	}
+}
+
+// LoadFlags loads the configuration like Load, with the config file and settings given
+// by the flags of flagSet, which must be registered with RegisterFlags and parsed
+func LoadFlags(flagSet *flag.FlagSet) error {
+	flags := make(map[string]string)
+	flagSet.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if f.Name == flagName(s.key) {
+				flags[s.key] = f.Value.String()
+			}
+		}
+	})
+	return load(configPath, flags)
+}
+
+// Load loads the configuration from the config file at path, the environment variables
+// and the network profile selected, and validates it.
+// If path is empty, it is $HERMESVAULT_CONFIG or DefaultConfigPath, which may not exist
+func Load(path string) error {
+	return load(path, nil)
+}
+
+func load(path string, flags map[string]string) error {
+	values := make(map[string]string)
+	sources := make(map[string]string)
+	set := func(env map[string]string, source string) {
+		for key, value := range env {
+			values[key] = value
+			sources[key] = source
+		}
+	}
+
+	if path == "" {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	mustExist := path != ""
+	if !mustExist {
+		path = DefaultConfigPath
+	}
+	file, err := LoadEnv(path)
+	switch {
+	case err == nil:
+		set(file, "config file")
+	case !mustExist && errors.Is(err, fs.ErrNotExist):
+		path = ""
+	default:
+		return fmt.Errorf("failed to load config file: %v", err)
+	}
+
+	env := make(map[string]string)
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(envName(s.key)); ok {
+			env[s.key] = value
+		}
+	}
+	set(env, "environment")
+	set(flags, "flag")
+
+	if name := values["Profile"]; name != "" {
+		defaults, ok := profiles[name]
+		if !ok {
+			return fmt.Errorf("unknown profile %q, must be localnet, testnet or mainnet",
+				name)
+		}
+		for key, value := range defaults {
+			if _, ok := values[key]; !ok {
+				values[key] = value
+				sources[key] = "profile " + name
+			}
+		}
+	}
+
+	if err := apply(values); err != nil {
+		return err
+	}
+	loaded.path, loaded.values, loaded.sources = path, values, sources
+	return nil
+}
+
+// apply validates the configuration values and sets them
+func apply(env map[string]string) error {
+	var err error
+	Profile = env["Profile"]
+	if port := env["Port"]; port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid Port, must be between 1 and 65535: %q", port)
+		}
+		Port = port
+	}
+
+	AppSetupDirPath = env["AppSetupDirPath"]
+	InternalDbPath = env["InternalDbPath"]
+	TxnsDbPath = env["TxnsDbPath"]
+	if AppSetupDirPath == "" && Profile == "localnet" {
+		return errors.New("AppSetupDirPath is not set, with the localnet profile set it " +
+			"to the setup files of the app deployed to the localnet")
+	}
+	for _, key := range []string{"AppSetupDirPath", "InternalDbPath", "TxnsDbPath"} {
+		if env[key] == "" {
+			return fmt.Errorf("%s is not set, set it or select a profile", key)
+		}
+	}
+	SecretKeyPath = env["SecretKeyPath"]
+	if SecretKeyPath == "" {
+		SecretKeyPath = filepath.Join(filepath.Dir(InternalDbPath), "secret_key.bin")
+	}
+	if path := env["PublicKeyPath"]; path != "" {
+		PublicKeyPath = path
+	}
+	AlgodPath = env["AlgodPath"]
+	AlgodToken = env["AlgodToken"]
+
+	switch subscriber := env["EmbeddedSubscriber"]; subscriber {
+	case "", "false":
+		EmbeddedSubscriber = false
+	case "true":
+		EmbeddedSubscriber = true
+	default:
+		return fmt.Errorf("invalid EmbeddedSubscriber, must be true or false: %q",
+			subscriber)
+	}
+	if ProverWorkers, err = envInt(env, "ProverWorkers", ProverWorkers); err != nil {
+		return err
+	}
+	if ProverQueueSize, err = envInt(env, "ProverQueueSize", ProverQueueSize); err != nil {
+		return err
+	}
+	waitRounds, err := envInt(env, "WaitRounds", int(WaitRounds))
+	if err != nil {
+		return err
+	}
+	WaitRounds = uint64(waitRounds)
+	if interval := env["CleanupInterval"]; interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid CleanupInterval, must be a positive duration "+
+				"like 10m: %q", interval)
+		}
+		CleanupInterval = d
+	}
+
+	InstanceId = env["InstanceId"]
+	if InstanceId == "" {
+		if InstanceId, err = os.Hostname(); err != nil {
+			return fmt.Errorf("InstanceId is not set and the hostname is unknown: %v", err)
+		}
+	}
+
+	fees, err := loadFeePolicy(env)
+	if err != nil {
+		return err
+	}
+	Fees = fees
+	return nil
+}
+
+// Print writes the effective configuration in the config file format, with the secrets
+// masked and where each setting was set
+func Print(w io.Writer) {
+	file := loaded.path
+	if file == "" {
+		file = "none"
+	}
+	fmt.Fprintf(w, "# config file: %s\n", file)
+	for _, s := range settings {
+		var value string
+		if s.value != nil {
+			value = s.value()
+		} else {
+			value = loaded.values[s.key]
+		}
+		if s.secret && value != "" {
+			value = "********"
+		}
+		source := loaded.sources[s.key]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(w, "%s = %q # %s\n", s.key, value, source)
+	}
+}
+
+// flagName returns the command line flag of a configuration key, e.g. algod-path
+func flagName(key string) string {
+	return strings.Join(words(key), "-")
+}
+
+// envName returns the environment variable of a configuration key, e.g.
+// HERMESVAULT_ALGOD_PATH
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.Join(words(key), "_"))
+}
+
+// words splits a camel case key into its lower case words
+func words(key string) []string {
+	var words []string
+	start := 0
+	for i, r := range key {
+		if i > 0 && unicode.IsUpper(r) {
+			words = append(words, strings.ToLower(key[start:i]))
+			start = i
+		}
+	}
+	return append(words, strings.ToLower(key[start:]))
+}
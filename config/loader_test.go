@@ -0,0 +1,150 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file with content, returning its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clearEnv unsets the environment variables of the settings for the duration of the test
+func clearEnv(t *testing.T) {
+	for _, s := range settings {
+		t.Setenv(envName(s.key), "")
+		os.Unsetenv(envName(s.key))
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, `
+Profile = "testnet"
+AlgodPath = "file-algod"
+TxnsDbPath = "file-txns.db"
+InternalDbPath = "file-internal.db"
+`)
+	t.Setenv(envName("TxnsDbPath"), "env-txns.db")
+	t.Setenv(envName("InternalDbPath"), "env-internal.db")
+	flags := map[string]string{"InternalDbPath": "flag-internal.db"}
+	if err := load(path, flags); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		key, value, want, source string
+	}{
+		{"AppSetupDirPath", AppSetupDirPath, "avm/testnet", "profile testnet"},
+		{"AlgodPath", AlgodPath, "file-algod", "config file"},
+		{"TxnsDbPath", TxnsDbPath, "env-txns.db", "environment"},
+		{"InternalDbPath", InternalDbPath, "flag-internal.db", "flag"},
+	} {
+		if test.value != test.want || loaded.sources[test.key] != test.source {
+			t.Errorf("%s = %q from %s, want %q from %s", test.key, test.value,
+				loaded.sources[test.key], test.want, test.source)
+		}
+	}
+	if SecretKeyPath != "secret_key.bin" {
+		t.Errorf("SecretKeyPath %q, expected next to the internal database", SecretKeyPath)
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	clearEnv(t)
+	for _, test := range []struct {
+		name   string
+		config string
+		err    string // a part of the error expected, none if it loads
+	}{
+		{"localnet without app setup", `Profile = "localnet"`, "AppSetupDirPath is not set"},
+		{"localnet with app setup", "Profile = localnet\nAppSetupDirPath = avm/local", ""},
+		{"mainnet", `Profile = "mainnet"`, ""},
+		{"unknown profile", `Profile = "devnet"`, `unknown profile "devnet"`},
+		{"no profile", `AppSetupDirPath = "avm/mainnet"`, "InternalDbPath is not set"},
+		{"invalid port", "Profile = mainnet\nPort = 70000", "invalid Port"},
+	} {
+		err := load(writeConfig(t, test.config), nil)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+		}
+	}
+	if err := load(writeConfig(t, "Profile = localnet\nAppSetupDirPath = avm/local"),
+		nil); err != nil {
+		t.Fatal(err)
+	}
+	if TxnsDbPath != "data/localnet/txns/txns.db" || AlgodPath != "" {
+		t.Errorf("localnet profile defaults not applied: %q, %q", TxnsDbPath, AlgodPath)
+	}
+}
+
+func TestSettingNames(t *testing.T) {
+	for _, test := range []struct {
+		key   string
+		words []string
+		flag  string
+		env   string
+	}{
+		{"Port", []string{"port"}, "port", "HERMESVAULT_PORT"},
+		{"AlgodPath", []string{"algod", "path"}, "algod-path", "HERMESVAULT_ALGOD_PATH"},
+		{"AppSetupDirPath", []string{"app", "setup", "dir", "path"}, "app-setup-dir-path",
+			"HERMESVAULT_APP_SETUP_DIR_PATH"},
+		{"FeeRecipientNoRefund", []string{"fee", "recipient", "no", "refund"},
+			"fee-recipient-no-refund", "HERMESVAULT_FEE_RECIPIENT_NO_REFUND"},
+	} {
+		if got := words(test.key); !slices.Equal(got, test.words) {
+			t.Errorf("words(%s) = %v, want %v", test.key, got, test.words)
+		}
+		if got := flagName(test.key); got != test.flag {
+			t.Errorf("flagName(%s) = %s, want %s", test.key, got, test.flag)
+		}
+		if got := envName(test.key); got != test.env {
+			t.Errorf("envName(%s) = %s, want %s", test.key, got, test.env)
+		}
+	}
+}
+
+func TestPrintMasksSecrets(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, "Profile = mainnet\nAlgodToken = \"secret-token\"")
+	if err := load(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	Print(&b)
+	printed := b.String()
+	if strings.Contains(printed, "secret-token") {
+		t.Errorf("the algod token is printed:\n%s", printed)
+	}
+	for _, line := range []string{
+		"# config file: " + path,
+		`AlgodToken = "********" # config file`,
+		`AppSetupDirPath = "avm/mainnet" # profile mainnet`,
+		`Profile = "mainnet" # config file`,
+	} {
+		if !strings.Contains(printed, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, printed)
+		}
+	}
+
+	if err := load(writeConfig(t, "Profile = mainnet"), nil); err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	Print(&b)
+	if !strings.Contains(b.String(), `AlgodToken = "" # default`+"\n") {
+		t.Errorf("an unset algod token is not printed empty:\n%s", b.String())
+	}
+}
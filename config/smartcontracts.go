@@ -18,10 +18,21 @@ const (
 	NoOpMethodName       = "noop"
 
 	UserDepositTxnIndex = 1 // index of the user pay txn in the deposit txn group (0 based)
+
+	// number of recent merkle roots kept by the contract and accepted for withdrawals
+	RootCount = 50
 )
 
 // transaction fees required
 const (
+	// logicsig opcode budget pooled in a group for each top level transaction
+	LogicSigBudgetPerTxn = 20_000
+	// maximum # of top level transactions in a group
+	MaxTxnGroupSize = 16
+
+	// The group size and fees are found simulating the transaction groups, the following
+	// are used when the simulation is not available
+
 	// # top level transactions needed for logicsig verifier opcode budget
 	VerifierTopLevelTxnNeeded = 8
 
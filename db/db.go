@@ -11,7 +11,7 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func RegisterUnconfirmedNote(n *models.Note) (int64, error) {
+func (s *SQLite) RegisterUnconfirmedNote(n *models.Note) (int64, error) {
 	// Encrypt the nullifier before storing it
 	encryptedNullifier, err := encrypt.Encrypt(n.Nullifier())
 	if err != nil {
@@ -23,7 +23,7 @@ func RegisterUnconfirmedNote(n *models.Note) (int64, error) {
 		nullifier,
 		txn_id
 		) VALUES (?, ?, ?)`
-	result, err := internalDb.Exec(sql,
+	result, err := s.internalDb.Exec(sql,
 		n.Commitment(),
 		encryptedNullifier,
 		n.TxnID,
@@ -38,7 +38,7 @@ func RegisterUnconfirmedNote(n *models.Note) (int64, error) {
 	return leafIndex, nil
 }
 
-func SaveNote(n *models.Note) error {
+func (s *SQLite) SaveNote(n *models.Note) error {
 	isNoteConfirmed := n.TxnID != models.EmptyTxnId &&
 		n.LeafIndex != models.EmptyLeafIndex
 
@@ -52,14 +52,14 @@ func SaveNote(n *models.Note) error {
 	}
 
 	sql := `INSERT INTO notes (leaf_index, commitment, txn_id, nullifier) VALUES (?, ?, ?, ?)`
-	_, err = internalDb.Exec(sql, n.LeafIndex, n.Commitment(), n.TxnID, encryptedNullifier)
+	_, err = s.internalDb.Exec(sql, n.LeafIndex, n.Commitment(), n.TxnID, encryptedNullifier)
 	if err != nil {
 		return fmt.Errorf("failed to insert note: %w", err)
 	}
 
 	// Only for use in TestNet
 	// debugSql := `INSERT INTO debug_notes (leaf_index, text) VALUES (?, ?)`
-	// _, err = internalDb.Exec(debugSql, n.LeafIndex, n.Text())
+	// _, err = s.internalDb.Exec(debugSql, n.LeafIndex, n.Text(s.appId))
 	// if err != nil {
 	// 	return fmt.Errorf("failed to insert debug note: %w", err)
 	// }
@@ -69,73 +69,105 @@ func SaveNote(n *models.Note) error {
 
 // GetLeafIndexByCommitment returns the leaf index of a note given its commitment
 // error will be sql.ErrNoRows if no rows are returned
-func GetLeafIndexByCommitment(commitment []byte) (uint64, error) {
+func (s *SQLite) GetLeafIndexByCommitment(commitment []byte) (uint64, error) {
 	query := `SELECT leaf_index FROM txns WHERE commitment = ?`
 	var index uint64
-	err := txnsDb.QueryRow(query, commitment).Scan(&index)
+	err := s.txnsDb.QueryRow(query, commitment).Scan(&index)
 	return index, err
 }
 
-// GetAllLeavesCommitments returns all leaf commitments in the database
-func GetAllLeavesCommitments() ([][]byte, error) {
-	query := `SELECT commitment FROM txns ORDER BY leaf_index ASC`
-	rows, err := txnsDb.Query(query)
+// GetLeavesCommitments returns the leaf commitments with leaf index in [start, end),
+// ordered by leaf index. It returns an error if any leaf in the range is missing
+func (s *SQLite) GetLeavesCommitments(start, end uint64) ([][]byte, error) {
+	query := `SELECT leaf_index, commitment FROM txns
+		WHERE leaf_index >= ? AND leaf_index < ? ORDER BY leaf_index ASC`
+	rows, err := s.txnsDb.Query(query, start, end)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	var commitments [][]byte
+	expectedIndex := start
 	for rows.Next() {
+		var leafIndex uint64
 		var commitment []byte
-		if err := rows.Scan(&commitment); err != nil {
+		if err := rows.Scan(&leafIndex, &commitment); err != nil {
 			return nil, err
 		}
+		if leafIndex != expectedIndex {
+			return nil, fmt.Errorf("missing leaf at index %d", expectedIndex)
+		}
 		commitments = append(commitments, commitment)
+		expectedIndex++
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	if expectedIndex != end {
+		return nil, fmt.Errorf("missing leaf at index %d", expectedIndex)
+	}
 
 	return commitments, nil
 }
 
 // GetRoot returns the Merkle root and the number of leaves in the tree
-func GetRoot() (root []byte, leafCount uint64, err error) {
+func (s *SQLite) GetRoot() (root []byte, leafCount uint64, err error) {
 	query := `SELECT value, leaf_count FROM roots`
-	err = txnsDb.QueryRow(query).Scan(&root, &leafCount)
+	err = s.txnsDb.QueryRow(query).Scan(&root, &leafCount)
 	return root, leafCount, err
 }
 
 // DeleteUnconfirmedNote deletes an unconfirmed note from the database.
 // It does not return an error if it fails
-func DeleteUnconfirmedNote(id int64) {
-	_, err := internalDb.Exec(`DELETE FROM unconfirmed_notes WHERE id = ?`, id)
+func (s *SQLite) DeleteUnconfirmedNote(id int64) {
+	_, err := s.internalDb.Exec(`DELETE FROM unconfirmed_notes WHERE id = ?`, id)
 	if err != nil {
 		log.Printf("Error deleting unconfirmed note: %v", err)
 	}
 }
 
+// HasUnconfirmedNote reports whether a note with the given commitment is registered as
+// unconfirmed
+func (s *SQLite) HasUnconfirmedNote(commitment []byte) (bool, error) {
+	var exists bool
+	err := s.internalDb.QueryRow(`SELECT EXISTS (
+		SELECT 1 FROM unconfirmed_notes WHERE commitment = ?)`, commitment).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up unconfirmed note: %w", err)
+	}
+	return exists, nil
+}
+
+func (s *SQLite) CountUnconfirmedNotes() (uint64, error) {
+	var count uint64
+	err := s.internalDb.QueryRow(`SELECT COUNT(*) FROM unconfirmed_notes`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count unconfirmed notes: %w", err)
+	}
+	return count, nil
+}
+
 // Close closes all database connections
-func Close() {
-	if err := internalDb.Close(); err != nil {
+func (s *SQLite) Close() {
+	if err := s.internalDb.Close(); err != nil {
 		log.Printf("Error closing internalDb: %v", err)
 	}
-	if err := txnsDb.Close(); err != nil {
+	if err := s.txnsDb.Close(); err != nil {
 		log.Printf("Error closing txnsDb: %v", err)
 	}
 }
 
 // GetStats returns the statistics from the database
-func GetStats() (*models.StatData, error) {
+func (s *SQLite) GetStats() (*models.StatData, error) {
 	statsSql := `SELECT
 		(SELECT value FROM stats WHERE key = 'total_deposits'),
 		(SELECT value FROM stats WHERE key = 'total_withdrawals'),
 		(SELECT value FROM stats WHERE key = 'total_fees'),
 		(SELECT value FROM stats WHERE key = 'count_deposits')`
 	var depositTotal, withdrawalTotal, feeTotal, depositCount uint64
-	err := txnsDb.QueryRow(statsSql).Scan(&depositTotal, &withdrawalTotal, &feeTotal,
+	err := s.txnsDb.QueryRow(statsSql).Scan(&depositTotal, &withdrawalTotal, &feeTotal,
 		&depositCount)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get stats: %w", err)
@@ -143,7 +175,7 @@ func GetStats() (*models.StatData, error) {
 
 	var noteCount uint64
 	notesSql := `SELECT COUNT(*) FROM txns`
-	err = txnsDb.QueryRow(notesSql).Scan(&noteCount)
+	err = s.txnsDb.QueryRow(notesSql).Scan(&noteCount)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get note count: %w", err)
 	}
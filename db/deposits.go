@@ -0,0 +1,201 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/db/encrypt"
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/algorand/go-algorand-sdk/v2/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// pendingDepositTTL is how long a deposit waits for the user to sign and confirm it
+const pendingDepositTTL = 10 * time.Minute
+
+// pendingDeposit is the serialized models.DepositData, which the SQLite store keeps
+// encrypted since the note is secret
+type pendingDeposit struct {
+	Amount         uint64   `json:"amount"`
+	Address        string   `json:"address"`
+	Note           string   `json:"note"`
+	TxnId          string   `json:"txnId"`
+	Txns           []string `json:"txns"` // base64 msgpack encoded
+	IndexTxnToSign int      `json:"indexTxnToSign"`
+}
+
+// depositGroupId returns the txn group id of a deposit
+func depositGroupId(d *models.DepositData) (types.Digest, error) {
+	if len(d.Txns) == 0 || d.Txns[0].Group == (types.Digest{}) {
+		return types.Digest{}, fmt.Errorf("missing group ID")
+	}
+	return d.Txns[0].Group, nil
+}
+
+// sealDeposit serializes and encrypts a deposit of the vault app appId
+func sealDeposit(d *models.DepositData, appId uint64) ([]byte, error) {
+	data, err := json.Marshal(pendingDeposit{
+		Amount:         d.Amount.Microalgos,
+		Address:        string(d.Address),
+		Note:           d.Note.Text(appId),
+		TxnId:          d.Note.TxnID,
+		Txns:           models.EncodeTxnsToBase64(d.Txns),
+		IndexTxnToSign: d.IndexTxnToSign,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize deposit: %w", err)
+	}
+	return encrypt.Seal(data)
+}
+
+// openDeposit decrypts and deserializes a deposit of the vault app appId sealed by
+// sealDeposit
+func openDeposit(sealed []byte, appId uint64) (*models.DepositData, error) {
+	data, err := encrypt.Open(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt deposit: %w", err)
+	}
+	var p pendingDeposit
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to deserialize deposit: %w", err)
+	}
+	note, err := models.Input(p.Note).ToNote(appId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse deposit note: %w", err)
+	}
+	note.TxnID = p.TxnId
+	txns := make([]types.Transaction, len(p.Txns))
+	for i, txn := range p.Txns {
+		txnBytes, err := base64.StdEncoding.DecodeString(txn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode deposit txn: %w", err)
+		}
+		if err := msgpack.Decode(txnBytes, &txns[i]); err != nil {
+			return nil, fmt.Errorf("failed to decode deposit txn: %w", err)
+		}
+	}
+	return &models.DepositData{
+		Amount:         models.NewAmount(p.Amount),
+		Address:        models.Address(p.Address),
+		Note:           note,
+		Txns:           txns,
+		IndexTxnToSign: p.IndexTxnToSign,
+	}, nil
+}
+
+func (s *SQLite) StoreDeposit(d *models.DepositData) (types.Digest, error) {
+	groupId, err := depositGroupId(d)
+	if err != nil {
+		return types.Digest{}, err
+	}
+	sealed, err := sealDeposit(d, s.appId)
+	if err != nil {
+		return types.Digest{}, err
+	}
+	_, err = s.internalDb.Exec(`INSERT INTO pending_deposits (group_id, data)
+		VALUES (?, ?)`, groupId[:], sealed)
+	if err != nil {
+		return types.Digest{}, fmt.Errorf("failed to insert pending deposit: %w", err)
+	}
+	return groupId, nil
+}
+
+func (s *SQLite) RetrieveDeposit(groupId types.Digest) (*models.DepositData, error) {
+	var sealed []byte
+	err := s.internalDb.QueryRow(`SELECT data FROM pending_deposits
+		WHERE group_id = ? AND created_at > datetime('now', ?)`,
+		groupId[:], fmt.Sprintf("-%d seconds", int(pendingDepositTTL.Seconds())),
+	).Scan(&sealed)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("depositID not found: %v", groupId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending deposit: %w", err)
+	}
+	return openDeposit(sealed, s.appId)
+}
+
+func (s *SQLite) DeleteDeposit(groupId types.Digest) {
+	_, err := s.internalDb.Exec(`DELETE FROM pending_deposits WHERE group_id = ?`,
+		groupId[:])
+	if err != nil {
+		log.Printf("Error deleting pending deposit: %v", err)
+	}
+}
+
+func (s *SQLite) CountPendingDeposits() (uint64, error) {
+	var count uint64
+	err := s.internalDb.QueryRow(`SELECT COUNT(*) FROM pending_deposits
+		WHERE created_at > datetime('now', ?)`,
+		fmt.Sprintf("-%d seconds", int(pendingDepositTTL.Seconds())),
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pending deposits: %w", err)
+	}
+	return count, nil
+}
+
+// CleanupPendingDeposits deletes the expired pending deposits
+func (s *SQLite) CleanupPendingDeposits() {
+	_, err := s.internalDb.Exec(`DELETE FROM pending_deposits
+		WHERE created_at <= datetime('now', ?)`,
+		fmt.Sprintf("-%d seconds", int(pendingDepositTTL.Seconds())))
+	if err != nil {
+		log.Printf("Error deleting expired pending deposits: %v", err)
+	}
+}
+
+func (m *Memory) StoreDeposit(d *models.DepositData) (types.Digest, error) {
+	groupId, err := depositGroupId(d)
+	if err != nil {
+		return types.Digest{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deposits[groupId] = memoryDeposit{data: d, createdAt: time.Now()}
+	return groupId, nil
+}
+
+func (m *Memory) RetrieveDeposit(groupId types.Digest) (*models.DepositData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deposits[groupId]
+	if !ok || time.Since(d.createdAt) > pendingDepositTTL {
+		return nil, fmt.Errorf("depositID not found: %v", groupId)
+	}
+	return d.data, nil
+}
+
+func (m *Memory) DeleteDeposit(groupId types.Digest) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.deposits, groupId)
+}
+
+func (m *Memory) CountPendingDeposits() (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var count uint64
+	for _, d := range m.deposits {
+		if time.Since(d.createdAt) <= pendingDepositTTL {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CleanupPendingDeposits deletes the expired pending deposits
+func (m *Memory) CleanupPendingDeposits() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for groupId, d := range m.deposits {
+		if time.Since(d.createdAt) > pendingDepositTTL {
+			delete(m.deposits, groupId)
+		}
+	}
+}
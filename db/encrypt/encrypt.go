@@ -13,35 +13,49 @@ import (
 	"strings"
 
 	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
 	"golang.org/x/term"
 )
 
+// publicKey is the key nullifiers are encrypted with, set by LoadPublicKey
 var publicKey *[32]byte
 
-const publicKeyRelativePath = "generate-key/public_key.bin"
+// secretKey is the key data the frontend needs to read back is encrypted with at rest,
+// set by LoadOrCreateSecretKey
+var secretKey *[32]byte
 
-func init() {
-	publicKey = new([32]byte)
+const publicKeyRelativePath = "generate-key/public_key.bin"
 
+// DefaultPublicKeyPath returns the path of the public key file in the generate-key
+// directory of this package
+func DefaultPublicKeyPath() string {
 	// get the directory of the current file
-	_, filename, _, ok := runtime.Caller(0)
-	if !ok {
-		panic("No caller information")
-	}
-	dir := path.Dir(filename)
+	_, filename, _, _ := runtime.Caller(0)
+	return filepath.Join(path.Dir(filename), publicKeyRelativePath)
+}
 
-	file, err := os.Open(filepath.Join(dir, publicKeyRelativePath))
+// LoadPublicKey loads the public key to encrypt nullifiers with from the given file
+func LoadPublicKey(publicKeyPath string) error {
+	file, err := os.Open(publicKeyPath)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to open public key file: %v", err)
 	}
 	defer file.Close()
-	if _, err := io.ReadFull(file, publicKey[:]); err != nil {
-		panic(err)
+
+	key := new([32]byte)
+	if _, err := io.ReadFull(file, key[:]); err != nil {
+		return fmt.Errorf("failed to read public key: %v", err)
 	}
+	publicKey = key
+	return nil
 }
 
 // Encrypt the provided nullifier
 func Encrypt(nullifier []byte) ([]byte, error) {
+	if publicKey == nil {
+		return nil, errors.New("public key not loaded")
+	}
+
 	// Generate an ephemeral key pair
 	ephemeralPublicKey, ephemeralPrivateKey, err := box.GenerateKey(rand.Reader)
 	if err != nil {
@@ -115,3 +129,67 @@ func Decrypt(ciphertext []byte) ([]byte, error) {
 
 	return nullifier, nil
 }
+
+// LoadOrCreateSecretKey loads the secret key to encrypt data at rest with from the given
+// file, creating the file with a new random key if it does not exist.
+// Frontend instances sharing a database must share the key file too
+func LoadOrCreateSecretKey(secretKeyPath string) error {
+	key := new([32]byte)
+	file, err := os.Open(secretKeyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+			return fmt.Errorf("failed to generate secret key: %v", err)
+		}
+		// O_EXCL so that we never overwrite a key created meanwhile by another instance
+		file, err := os.OpenFile(secretKeyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create secret key file: %v", err)
+		}
+		defer file.Close()
+		if _, err := file.Write(key[:]); err != nil {
+			return fmt.Errorf("failed to write secret key: %v", err)
+		}
+		secretKey = key
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open secret key file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := io.ReadFull(file, key[:]); err != nil {
+		return fmt.Errorf("failed to read secret key: %v", err)
+	}
+	secretKey = key
+	return nil
+}
+
+// Seal encrypts and authenticates data with the secret key
+func Seal(data []byte) ([]byte, error) {
+	if secretKey == nil {
+		return nil, errors.New("secret key not loaded")
+	}
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	// the nonce is prepended to the ciphertext
+	return secretbox.Seal(nonce[:], data, &nonce, secretKey), nil
+}
+
+// Open decrypts data encrypted by Seal
+func Open(ciphertext []byte) ([]byte, error) {
+	if secretKey == nil {
+		return nil, errors.New("secret key not loaded")
+	}
+	if len(ciphertext) < 24 {
+		return nil, errors.New("ciphertext too short")
+	}
+	var nonce [24]byte
+	copy(nonce[:], ciphertext[:24])
+	data, ok := secretbox.Open(nil, ciphertext[24:], &nonce, secretKey)
+	if !ok {
+		return nil, errors.New("decryption failed")
+	}
+	return data, nil
+}
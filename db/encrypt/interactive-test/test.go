@@ -28,6 +28,9 @@ func main() {
 	}
 
 	// Encrypt the nullifier using the public key loaded from the file.
+	if err := encrypt.LoadPublicKey(encrypt.DefaultPublicKeyPath()); err != nil {
+		log.Fatalf("Failed to load public key: %v", err)
+	}
 	ciphertext, err := encrypt.Encrypt(nullifier)
 	if err != nil {
 		log.Fatalf("Encryption failed: %v", err)
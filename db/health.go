@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// PingInternalDb checks that the internal database can be queried
+func (s *SQLite) PingInternalDb(ctx context.Context) error {
+	return ping(ctx, s.internalDb)
+}
+
+// PingTxnsDb checks that the txns database can be queried
+func (s *SQLite) PingTxnsDb(ctx context.Context) error {
+	return ping(ctx, s.txnsDb)
+}
+
+// GetWatermark returns the last round the subscriber saved in the txns database
+func (s *SQLite) GetWatermark(ctx context.Context) (uint64, error) {
+	var watermark uint64
+	err := s.txnsDb.QueryRowContext(ctx, `SELECT value FROM watermark WHERE id = 1`).
+		Scan(&watermark)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get watermark: %w", err)
+	}
+	return watermark, nil
+}
+
+// ping runs a query on db, since sqlite opens the database file lazily
+func ping(ctx context.Context, db *sql.DB) error {
+	var one int
+	if err := db.QueryRowContext(ctx, `SELECT 1`).Scan(&one); err != nil {
+		return fmt.Errorf("failed to query database: %w", err)
+	}
+	return nil
+}
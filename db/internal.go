@@ -5,42 +5,45 @@ import (
 	"fmt"
 	"log"
 
-	"github.com/giuliop/HermesVault-frontend/config"
-
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var (
+// SQLite is the Store backed by the SQLite databases of the application
+type SQLite struct {
 	// txnsDb is populated by the subscriber service reading txns from algod
 	txnsDb *sql.DB
 	// internalDb is populated by the frontend to store additional notes data
 	internalDb *sql.DB
-)
-var (
-	internalDbPath = config.InternalDbPath
-	txnsDbPath     = config.TxnsDbPath
-)
+	// appId is the vault app the notes stored in internalDb are bound to
+	appId uint64
+}
 
-func init() {
-	if err := initializeInternalDB(); err != nil {
-		log.Fatalf("failed to initialize internal database: %v", err)
+// Open opens the internal database at internalDbPath, creating it if needed, and the
+// transactions database at txnsDbPath in read-only mode, for the notes of the vault app
+// appId
+func Open(internalDbPath, txnsDbPath string, appId uint64) (*SQLite, error) {
+	s := &SQLite{appId: appId}
+	if err := s.initializeInternalDB(internalDbPath); err != nil {
+		return nil, fmt.Errorf("failed to initialize internal database: %w", err)
 	}
-	if err := initializeTxnsDB(); err != nil {
-		log.Fatalf("failed to initialize transactions database: %v", err)
+	if err := s.initializeTxnsDB(txnsDbPath); err != nil {
+		s.internalDb.Close()
+		return nil, fmt.Errorf("failed to initialize transactions database: %w", err)
 	}
+	return s, nil
 }
 
 // initializeTxnsDB opens a connection to the txnsDb in read-only mode
-func initializeTxnsDB() error {
+func (s *SQLite) initializeTxnsDB(txnsDbPath string) error {
 	var err error
 	// Open connection in read-only mode using DSN parameters.
 	dsn := fmt.Sprintf("file:%s?mode=ro", txnsDbPath)
-	txnsDb, err = sql.Open("sqlite3", dsn)
+	s.txnsDb, err = sql.Open("sqlite3", dsn)
 	if err != nil {
 		return fmt.Errorf("failed to open transactions database in read-only mode: %w", err)
 	}
 	// Set busy timeout to 5000ms (5 seconds)
-	_, err = txnsDb.Exec("PRAGMA busy_timeout = 5000")
+	_, err = s.txnsDb.Exec("PRAGMA busy_timeout = 5000")
 	if err != nil {
 		return fmt.Errorf("failed to set busy timeout on transactions database: %w", err)
 	}
@@ -48,11 +51,11 @@ func initializeTxnsDB() error {
 	return nil
 }
 
-// InitializeDB initializes the internalDb with WAL mode and creates necessary tables
+// initializeInternalDB opens the internalDb with WAL mode and creates necessary tables
 // (if they don't exist already)
-func initializeInternalDB() error {
+func (s *SQLite) initializeInternalDB(internalDbPath string) error {
 	var err error
-	internalDb, err = sql.Open("sqlite3", internalDbPath)
+	s.internalDb, err = sql.Open("sqlite3", internalDbPath)
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
@@ -60,6 +63,12 @@ func initializeInternalDB() error {
 	// The unconfirmed_notes table stores notes that the frontend has not received confirmation
 	// for yet form the blockchain. Once the txn inserting the note is confirmed, it is
 	// removed from this table and added to the notes table.
+	// The pending_deposits table stores the deposits waiting for the user to sign them.
+	// The jobs table stores the progress of the deposits and withdrawals sent in the
+	// background, without any secret note data.
+	// The receipts table stores the receipts of the confirmed jobs, without secret notes.
+	// The job_notes table stores the secret notes that may hold the remaining funds of a
+	// batch withdrawal job, to show them if it fails.
 	createTables := `
 	CREATE TABLE IF NOT EXISTS notes (
 		leaf_index INTEGER PRIMARY KEY,			-- note ndex in onchain merkle tree
@@ -75,6 +84,39 @@ func initializeInternalDB() error {
 		txn_id TEXT UNIQUE NOT NULL, 			-- id of first group txn that will insert note
 		created_at TEXT DEFAULT CURRENT_TIMESTAMP
 	) STRICT;
+
+	CREATE TABLE IF NOT EXISTS pending_deposits (
+		group_id BLOB PRIMARY KEY,              -- id of the deposit txn group
+		data BLOB NOT NULL,                     -- deposit data, encrypted with the secret key
+		created_at TEXT DEFAULT CURRENT_TIMESTAMP
+	) STRICT;
+
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,                    -- random hex id given to the user
+		kind TEXT NOT NULL,                     -- deposit, withdrawal or batch-withdrawal
+		instance TEXT NOT NULL,                 -- id of the server instance running it
+		state TEXT NOT NULL,                    -- queued, proving, submitted, confirmed, failed
+		queue_position INTEGER NOT NULL,        -- position in the prover queue if queued
+		txn_id TEXT NOT NULL,                   -- id of first group txn, once submitted
+		leaf_index INTEGER,                     -- index of the note inserted, once confirmed
+		error_type TEXT NOT NULL,               -- if failed, the type of error
+		message TEXT NOT NULL,                  -- if failed, the error message for the user
+		retry_after INTEGER NOT NULL,           -- if failed with server busy, seconds to wait
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	) STRICT;
+
+	CREATE TABLE IF NOT EXISTS receipts (
+		job_id TEXT NOT NULL,                   -- id of the job
+		step INTEGER NOT NULL,                  -- withdrawal index of a batch, otherwise 0
+		data BLOB NOT NULL,                     -- the receipt, json encoded
+		PRIMARY KEY (job_id, step)
+	) STRICT;
+
+	CREATE TABLE IF NOT EXISTS job_notes (
+		job_id TEXT PRIMARY KEY,                -- id of the batch withdrawal job
+		data BLOB NOT NULL                      -- the note texts, encrypted with the secret key
+	) STRICT;
 	`
 	// Only for use in TestNet
 	// CREATE TABLE IF NOT EXISTS debug_notes (
@@ -84,16 +126,16 @@ func initializeInternalDB() error {
 	// ) STRICT;
 
 	// Enable WAL
-	_, err = internalDb.Exec("PRAGMA journal_mode = WAL")
+	_, err = s.internalDb.Exec("PRAGMA journal_mode = WAL")
 	if err != nil {
 		return fmt.Errorf("failed to enable WAL: %w", err)
 	}
 	// Set busy timeout to 5000ms (5 seconds) to reduce "database is locked" errors
-	_, err = internalDb.Exec("PRAGMA busy_timeout = 5000")
+	_, err = s.internalDb.Exec("PRAGMA busy_timeout = 5000")
 	if err != nil {
 		return fmt.Errorf("failed to set busy timeout: %w", err)
 	}
-	_, err = internalDb.Exec(createTables)
+	_, err = s.internalDb.Exec(createTables)
 	if err != nil {
 		return fmt.Errorf("failed to create tables: %w", err)
 	}
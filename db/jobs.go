@@ -0,0 +1,320 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/db/encrypt"
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// jobTTL is how long a job is kept after its last update
+const jobTTL = 24 * time.Hour
+
+// jobTimeLayout is the layout of the job timestamps, the SQLite CURRENT_TIMESTAMP format
+const jobTimeLayout = "2006-01-02 15:04:05"
+
+func (s *SQLite) SaveJob(j *models.Job) error {
+	// the leaf index is NULL when empty, since SQLite integers cannot hold EmptyLeafIndex
+	var leafIndex sql.NullInt64
+	if j.LeafIndex != models.EmptyLeafIndex {
+		leafIndex = sql.NullInt64{Int64: int64(j.LeafIndex), Valid: true}
+	}
+	_, err := s.internalDb.Exec(`INSERT INTO jobs (id, kind, instance, state,
+		queue_position, txn_id, leaf_index, error_type, message, retry_after, created_at,
+		updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET state = excluded.state,
+			queue_position = excluded.queue_position, txn_id = excluded.txn_id,
+			leaf_index = excluded.leaf_index, error_type = excluded.error_type,
+			message = excluded.message, retry_after = excluded.retry_after,
+			updated_at = excluded.updated_at`,
+		j.Id, string(j.Kind), j.Instance, string(j.State), j.QueuePosition, j.TxnId, leafIndex,
+		j.ErrorType, j.Message, j.RetryAfter,
+		j.CreatedAt.UTC().Format(jobTimeLayout), j.UpdatedAt.UTC().Format(jobTimeLayout))
+	if err != nil {
+		return fmt.Errorf("failed to save job %s: %w", j.Id, err)
+	}
+	return nil
+}
+
+// jobColumns are the columns scanned by scanJob
+const jobColumns = `id, kind, instance, state, queue_position, txn_id, leaf_index,
+	error_type, message, retry_after, created_at, updated_at`
+
+// scanJob scans a row with the jobColumns into a job
+func scanJob(row interface{ Scan(dest ...any) error }) (*models.Job, error) {
+	var j models.Job
+	var kind, state, createdAt, updatedAt string
+	var leafIndex sql.NullInt64
+	err := row.Scan(&j.Id, &kind, &j.Instance, &state, &j.QueuePosition, &j.TxnId, &leafIndex,
+		&j.ErrorType, &j.Message, &j.RetryAfter, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	j.Kind, j.State = models.JobKind(kind), models.JobState(state)
+	j.LeafIndex = models.EmptyLeafIndex
+	if leafIndex.Valid {
+		j.LeafIndex = uint64(leafIndex.Int64)
+	}
+	if j.CreatedAt, err = time.Parse(jobTimeLayout, createdAt); err != nil {
+		return nil, fmt.Errorf("failed to parse job created_at %s: %w", createdAt, err)
+	}
+	if j.UpdatedAt, err = time.Parse(jobTimeLayout, updatedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse job updated_at %s: %w", updatedAt, err)
+	}
+	return &j, nil
+}
+
+func (s *SQLite) GetJob(id string) (*models.Job, error) {
+	row := s.internalDb.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id)
+	return scanJob(row)
+}
+
+func (s *SQLite) GetUnfinishedJobs(instance string) ([]*models.Job, error) {
+	rows, err := s.internalDb.Query(`SELECT `+jobColumns+` FROM jobs
+		WHERE instance = ? AND state NOT IN (?, ?)`, instance,
+		string(models.JobConfirmed), string(models.JobFailed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unfinished jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*models.Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *SQLite) SaveReceipt(jobId string, step int, r *models.Receipt) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode receipt of job %s: %w", jobId, err)
+	}
+	_, err = s.internalDb.Exec(`INSERT OR REPLACE INTO receipts (job_id, step, data)
+		VALUES (?, ?, ?)`, jobId, step, data)
+	if err != nil {
+		return fmt.Errorf("failed to save receipt of job %s: %w", jobId, err)
+	}
+	return nil
+}
+
+func (s *SQLite) GetReceipt(jobId string, step int) (*models.Receipt, error) {
+	var data []byte
+	err := s.internalDb.QueryRow(`SELECT data FROM receipts WHERE job_id = ? AND step = ?`,
+		jobId, step).Scan(&data)
+	if err != nil {
+		return nil, err
+	}
+	var r models.Receipt
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to decode receipt of job %s: %w", jobId, err)
+	}
+	return &r, nil
+}
+
+func (s *SQLite) GetReceipts(jobId string) ([]*models.Receipt, error) {
+	rows, err := s.internalDb.Query(`SELECT data FROM receipts WHERE job_id = ?
+		ORDER BY step`, jobId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query receipts of job %s: %w", jobId, err)
+	}
+	defer rows.Close()
+
+	var receipts []*models.Receipt
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan receipt: %w", err)
+		}
+		var r models.Receipt
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("failed to decode receipt of job %s: %w", jobId, err)
+		}
+		receipts = append(receipts, &r)
+	}
+	return receipts, rows.Err()
+}
+
+func (s *SQLite) SaveJobNotes(jobId string, notes []*models.Note) error {
+	if len(notes) == 0 {
+		_, err := s.internalDb.Exec(`DELETE FROM job_notes WHERE job_id = ?`, jobId)
+		if err != nil {
+			return fmt.Errorf("failed to delete notes of job %s: %w", jobId, err)
+		}
+		return nil
+	}
+	texts := make([]string, len(notes))
+	for i, note := range notes {
+		texts[i] = note.Text(s.appId)
+	}
+	data, err := json.Marshal(texts)
+	if err != nil {
+		return fmt.Errorf("failed to encode notes of job %s: %w", jobId, err)
+	}
+	sealed, err := encrypt.Seal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt notes of job %s: %w", jobId, err)
+	}
+	_, err = s.internalDb.Exec(`INSERT OR REPLACE INTO job_notes (job_id, data)
+		VALUES (?, ?)`, jobId, sealed)
+	if err != nil {
+		return fmt.Errorf("failed to save notes of job %s: %w", jobId, err)
+	}
+	return nil
+}
+
+func (s *SQLite) GetJobNotes(jobId string) ([]*models.Note, error) {
+	var sealed []byte
+	err := s.internalDb.QueryRow(`SELECT data FROM job_notes WHERE job_id = ?`, jobId).
+		Scan(&sealed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notes of job %s: %w", jobId, err)
+	}
+	data, err := encrypt.Open(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt notes of job %s: %w", jobId, err)
+	}
+	var texts []string
+	if err := json.Unmarshal(data, &texts); err != nil {
+		return nil, fmt.Errorf("failed to decode notes of job %s: %w", jobId, err)
+	}
+	notes := make([]*models.Note, len(texts))
+	for i, text := range texts {
+		if notes[i], err = models.Input(text).ToNote(s.appId); err != nil {
+			return nil, fmt.Errorf("failed to parse note of job %s: %w", jobId, err)
+		}
+	}
+	return notes, nil
+}
+
+// CleanupJobs deletes the jobs last updated more than a day ago, with their receipts and
+// notes
+func (s *SQLite) CleanupJobs() {
+	_, err := s.internalDb.Exec(`DELETE FROM jobs WHERE updated_at <= ?`,
+		time.Now().UTC().Add(-jobTTL).Format(jobTimeLayout))
+	if err != nil {
+		log.Printf("Error deleting old jobs: %v", err)
+	}
+	_, err = s.internalDb.Exec(`DELETE FROM receipts
+		WHERE job_id NOT IN (SELECT id FROM jobs)`)
+	if err != nil {
+		log.Printf("Error deleting old receipts: %v", err)
+	}
+	_, err = s.internalDb.Exec(`DELETE FROM job_notes
+		WHERE job_id NOT IN (SELECT id FROM jobs)`)
+	if err != nil {
+		log.Printf("Error deleting old job notes: %v", err)
+	}
+}
+
+func (m *Memory) SaveJob(j *models.Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := *j
+	m.jobs[j.Id] = &job
+	return nil
+}
+
+func (m *Memory) GetJob(id string) (*models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	job := *j
+	return &job, nil
+}
+
+func (m *Memory) GetUnfinishedJobs(instance string) ([]*models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var jobs []*models.Job
+	for _, j := range m.jobs {
+		if j.Instance == instance && !j.State.Done() {
+			job := *j
+			jobs = append(jobs, &job)
+		}
+	}
+	return jobs, nil
+}
+
+func (m *Memory) SaveReceipt(jobId string, step int, r *models.Receipt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	receipts := m.receipts[jobId]
+	for len(receipts) <= step {
+		receipts = append(receipts, nil)
+	}
+	receipt := *r
+	receipts[step] = &receipt
+	m.receipts[jobId] = receipts
+	return nil
+}
+
+func (m *Memory) GetReceipt(jobId string, step int) (*models.Receipt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	receipts := m.receipts[jobId]
+	if step < 0 || step >= len(receipts) || receipts[step] == nil {
+		return nil, sql.ErrNoRows
+	}
+	receipt := *receipts[step]
+	return &receipt, nil
+}
+
+func (m *Memory) GetReceipts(jobId string) ([]*models.Receipt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var receipts []*models.Receipt
+	for _, r := range m.receipts[jobId] {
+		if r != nil {
+			receipt := *r
+			receipts = append(receipts, &receipt)
+		}
+	}
+	return receipts, nil
+}
+
+func (m *Memory) SaveJobNotes(jobId string, notes []*models.Note) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(notes) == 0 {
+		delete(m.jobNotes, jobId)
+		return nil
+	}
+	m.jobNotes[jobId] = append([]*models.Note(nil), notes...)
+	return nil
+}
+
+func (m *Memory) GetJobNotes(jobId string) ([]*models.Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*models.Note(nil), m.jobNotes[jobId]...), nil
+}
+
+// CleanupJobs deletes the jobs last updated more than a day ago, with their receipts and
+// notes
+func (m *Memory) CleanupJobs() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, j := range m.jobs {
+		if time.Since(j.UpdatedAt) > jobTTL {
+			delete(m.jobs, id)
+			delete(m.receipts, id)
+			delete(m.jobNotes, id)
+		}
+	}
+}
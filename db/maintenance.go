@@ -2,33 +2,11 @@ package db
 
 import (
 	"bytes"
-	"context"
 	"database/sql"
 	"log"
 	"time"
 )
 
-// StartCleanupRoutine starts a goroutine that periodically runs cleanup.
-// It returns a cancel function that can be used to stop the routine.
-func StartCleanupRoutine(ctx context.Context, interval time.Duration) context.CancelFunc {
-	ctx, cancel := context.WithCancel(ctx)
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				// log.Println("Running cleanup of unconfirmed notes...")
-				CleanupUnconfirmedNotes()
-			case <-ctx.Done():
-				log.Println("Cleanup routine stopped")
-				return
-			}
-		}
-	}()
-	return cancel
-}
-
 // CleanupUnconfirmedNotes cleans up unconfirmed_notes rows
 // For each note:
 //   - It retrieves the corresponding transaction from txnsDb using txn_id
@@ -36,9 +14,9 @@ func StartCleanupRoutine(ctx context.Context, interval time.Duration) context.Ca
 //   - If so, it moves it tothe notes table
 //   - Otherwise, it logs an error and leaves the note unconfirmed
 //   - Finally, if the note is older than 7 days, it deletes it as stale
-func CleanupUnconfirmedNotes() {
+func (s *SQLite) CleanupUnconfirmedNotes() {
 	// Query all rows from unconfirmed_notes.
-	rows, err := internalDb.Query(`
+	rows, err := s.internalDb.Query(`
 		SELECT id, commitment, nullifier, txn_id, created_at
 		FROM unconfirmed_notes
 	`)
@@ -74,7 +52,7 @@ func CleanupUnconfirmedNotes() {
 		// Query the transaction record by txn_id.
 		var txnLeafIndex int
 		var txnCommitment []byte
-		err = txnsDb.QueryRow(`
+		err = s.txnsDb.QueryRow(`
 			SELECT leaf_index, commitment
 			FROM txns
 			WHERE txn_id = ?
@@ -83,8 +61,8 @@ func CleanupUnconfirmedNotes() {
 		if err == sql.ErrNoRows {
 			log.Printf("No matching transaction found for unconfirmed note id %d with txn_id %s", id, txnID)
 			// Cleanup: if the note wasn't processed and is older than 7 days, delete it.
-			if time.Since(noteTime) > 7*24*time.Hour {
-				_, err = internalDb.Exec(`DELETE FROM unconfirmed_notes WHERE id = ?`, id)
+			if time.Since(noteTime) > unconfirmedNoteMaxAge {
+				_, err = s.internalDb.Exec(`DELETE FROM unconfirmed_notes WHERE id = ?`, id)
 				if err != nil {
 					log.Printf("failed to delete stale unconfirmed note id %d: %v", id, err)
 					continue
@@ -99,7 +77,7 @@ func CleanupUnconfirmedNotes() {
 			// Transaction found; verify that the commitment matches.
 			if bytes.Equal(txnCommitment, commitment) {
 				// Begin a transaction.
-				tx, err := internalDb.Begin()
+				tx, err := s.internalDb.Begin()
 				if err != nil {
 					log.Printf("failed to begin transaction for unconfirmed note id %d: %v", id, err)
 					continue
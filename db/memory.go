@@ -0,0 +1,277 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// Memory is an in-memory Store, meant for tests and local development.
+// The transactions, root, statistics and watermark written by the subscriber service in
+// the SQLite store are set with InsertTxn, SetRoot, SetStats and SetWatermark
+type Memory struct {
+	mu sync.Mutex
+
+	// internal data
+	notes            map[uint64]memoryNote // by leaf index
+	unconfirmedNotes map[int64]memoryNote  // by id
+	nextId           int64
+	deposits         map[types.Digest]memoryDeposit // pending deposits by group id
+	jobs             map[string]*models.Job         // by id
+	receipts         map[string][]*models.Receipt   // by job id, in step order
+	jobNotes         map[string][]*models.Note      // by job id
+
+	// txns data
+	txns      map[uint64]memoryTxn // by leaf index
+	root      []byte
+	leafCount uint64
+	stats     models.StatData
+	watermark uint64
+}
+
+// memoryNote is a note stored in Memory
+type memoryNote struct {
+	commitment []byte
+	nullifier  []byte
+	txnId      string
+	createdAt  time.Time
+}
+
+// memoryDeposit is a pending deposit stored in Memory
+type memoryDeposit struct {
+	data      *models.DepositData
+	createdAt time.Time
+}
+
+// memoryTxn is a transaction inserting a leaf in the merkle tree
+type memoryTxn struct {
+	commitment []byte
+	txnId      string
+}
+
+// NewMemory returns an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		notes:            make(map[uint64]memoryNote),
+		unconfirmedNotes: make(map[int64]memoryNote),
+		deposits:         make(map[types.Digest]memoryDeposit),
+		jobs:             make(map[string]*models.Job),
+		receipts:         make(map[string][]*models.Receipt),
+		jobNotes:         make(map[string][]*models.Note),
+		txns:             make(map[uint64]memoryTxn),
+	}
+}
+
+func (m *Memory) RegisterUnconfirmedNote(n *models.Note) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.unconfirmedNotes {
+		if u.txnId == n.TxnID {
+			return 0, fmt.Errorf("failed to register unconfirmed note %v: duplicate txn id",
+				n)
+		}
+	}
+	m.nextId++
+	m.unconfirmedNotes[m.nextId] = memoryNote{
+		commitment: n.Commitment(),
+		nullifier:  n.Nullifier(),
+		txnId:      n.TxnID,
+		createdAt:  time.Now(),
+	}
+	return m.nextId, nil
+}
+
+func (m *Memory) SaveNote(n *models.Note) error {
+	isNoteConfirmed := n.TxnID != models.EmptyTxnId &&
+		n.LeafIndex != models.EmptyLeafIndex
+
+	if !isNoteConfirmed {
+		return fmt.Errorf("malformed confirmed note: %v", n)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.notes[n.LeafIndex]; ok {
+		return fmt.Errorf("failed to insert note: duplicate leaf index %d", n.LeafIndex)
+	}
+	m.notes[n.LeafIndex] = memoryNote{
+		commitment: n.Commitment(),
+		nullifier:  n.Nullifier(),
+		txnId:      n.TxnID,
+		createdAt:  time.Now(),
+	}
+	return nil
+}
+
+func (m *Memory) DeleteUnconfirmedNote(id int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.unconfirmedNotes, id)
+}
+
+func (m *Memory) HasUnconfirmedNote(commitment []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.unconfirmedNotes {
+		if bytes.Equal(u.commitment, commitment) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) CountUnconfirmedNotes() (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return uint64(len(m.unconfirmedNotes)), nil
+}
+
+func (m *Memory) GetLeafIndexByCommitment(commitment []byte) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for leafIndex, txn := range m.txns {
+		if bytes.Equal(txn.commitment, commitment) {
+			return leafIndex, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (m *Memory) GetLeavesCommitments(start, end uint64) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var commitments [][]byte
+	for i := start; i < end; i++ {
+		txn, ok := m.txns[i]
+		if !ok {
+			return nil, fmt.Errorf("missing leaf at index %d", i)
+		}
+		commitments = append(commitments, txn.commitment)
+	}
+	return commitments, nil
+}
+
+func (m *Memory) GetRoot() (root []byte, leafCount uint64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.root == nil {
+		return nil, 0, sql.ErrNoRows
+	}
+	return m.root, m.leafCount, nil
+}
+
+func (m *Memory) GetStats() (*models.StatData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.stats
+	stats.NoteCount = uint64(len(m.txns))
+	return &stats, nil
+}
+
+// CleanupUnconfirmedNotes moves to the notes the unconfirmed notes whose transaction is
+// found with a matching commitment, and deletes the stale ones like the SQLite store
+func (m *Memory) CleanupUnconfirmedNotes() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, note := range m.unconfirmedNotes {
+		leafIndex, txn, found := m.txnById(note.txnId)
+		switch {
+		case !found:
+			if time.Since(note.createdAt) > unconfirmedNoteMaxAge {
+				delete(m.unconfirmedNotes, id)
+				log.Printf("Deleted stale unconfirmed note id %d", id)
+			}
+		case bytes.Equal(txn.commitment, note.commitment):
+			m.notes[leafIndex] = note
+			delete(m.unconfirmedNotes, id)
+		default:
+			log.Printf("Commitment mismatch for unconfirmed note id %d with txn_id %s", id,
+				note.txnId)
+		}
+	}
+}
+
+// txnById returns the transaction with the given txn id and its leaf index
+func (m *Memory) txnById(txnId string) (uint64, memoryTxn, bool) {
+	for leafIndex, txn := range m.txns {
+		if txn.txnId == txnId {
+			return leafIndex, txn, true
+		}
+	}
+	return 0, memoryTxn{}, false
+}
+
+func (m *Memory) Close() {}
+
+// InsertTxn inserts a leaf in the merkle tree with the transaction that inserted it
+func (m *Memory) InsertTxn(leafIndex uint64, commitment []byte, txnId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.txns[leafIndex] = memoryTxn{commitment: commitment, txnId: txnId}
+}
+
+// SetRoot sets the merkle tree root and the number of leaves in the tree
+func (m *Memory) SetRoot(root []byte, leafCount uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.root, m.leafCount = root, leafCount
+}
+
+func (m *Memory) PingInternalDb(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (m *Memory) PingTxnsDb(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (m *Memory) GetWatermark(ctx context.Context) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.watermark, nil
+}
+
+// SetStats sets the statistics returned by GetStats. NoteCount is ignored and computed
+// from the inserted txns
+func (m *Memory) SetStats(stats models.StatData) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats = stats
+}
+
+// SetWatermark sets the last round saved by the subscriber
+func (m *Memory) SetWatermark(round uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.watermark = round
+}
+
+// IsNoteSaved reports whether a confirmed note is stored at leafIndex
+func (m *Memory) IsNoteSaved(leafIndex uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.notes[leafIndex]
+	return ok
+}
+
+// UnconfirmedNoteCount returns the number of unconfirmed notes stored
+func (m *Memory) UnconfirmedNoteCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.unconfirmedNotes)
+}
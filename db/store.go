@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// NoteStore stores the notes created by the frontend.
+// Notes are first registered as unconfirmed when their transactions are sent to the
+// network, and saved once confirmed
+type NoteStore interface {
+	// RegisterUnconfirmedNote stores a note whose transactions have not been confirmed
+	// yet, returning its id
+	RegisterUnconfirmedNote(n *models.Note) (int64, error)
+	// SaveNote stores a confirmed note, which must have a leaf index and txn id
+	SaveNote(n *models.Note) error
+	// DeleteUnconfirmedNote deletes an unconfirmed note. It does not return an error if
+	// it fails
+	DeleteUnconfirmedNote(id int64)
+	// HasUnconfirmedNote reports whether a note with the given commitment is registered
+	// as unconfirmed
+	HasUnconfirmedNote(commitment []byte) (bool, error)
+}
+
+// TxnsReader reads the transactions and the merkle tree populated by the subscriber
+// service reading txns from algod
+type TxnsReader interface {
+	// GetLeafIndexByCommitment returns the leaf index of a note given its commitment.
+	// Error will be sql.ErrNoRows if the commitment is not found
+	GetLeafIndexByCommitment(commitment []byte) (uint64, error)
+	// GetLeavesCommitments returns the leaf commitments with leaf index in [start, end),
+	// ordered by leaf index. It returns an error if any leaf in the range is missing
+	GetLeavesCommitments(start, end uint64) ([][]byte, error)
+	// GetRoot returns the Merkle root and the number of leaves in the tree
+	GetRoot() (root []byte, leafCount uint64, err error)
+}
+
+// DepositStore keeps the deposits created and waiting for the user to sign and confirm
+// them, by their txn group id. Deposits expire after 10 minutes
+type DepositStore interface {
+	// StoreDeposit stores a deposit returning its group id
+	StoreDeposit(d *models.DepositData) (types.Digest, error)
+	// RetrieveDeposit returns the deposit with the given group id, failing if it is not
+	// found or has expired
+	RetrieveDeposit(groupId types.Digest) (*models.DepositData, error)
+	// DeleteDeposit deletes a deposit. It does not return an error if it fails
+	DeleteDeposit(groupId types.Digest)
+}
+
+// JobStore keeps the deposits and withdrawals sent to the network in the background, so
+// that users can follow them by id. Jobs are kept for a day after their last update
+type JobStore interface {
+	// SaveJob inserts a job or updates it if it exists
+	SaveJob(j *models.Job) error
+	// GetJob returns the job with the given id. Error will be sql.ErrNoRows if not found
+	GetJob(id string) (*models.Job, error)
+	// GetUnfinishedJobs returns the jobs of instance not confirmed or failed
+	GetUnfinishedJobs(instance string) ([]*models.Job, error)
+	// SaveReceipt stores the receipt of step of a job, kept as long as the job. A batch
+	// withdrawal job has one step per withdrawal, the other jobs only step 0
+	SaveReceipt(jobId string, step int, r *models.Receipt) error
+	// GetReceipt returns the receipt of step of the job with the given id.
+	// Error will be sql.ErrNoRows if not found
+	GetReceipt(jobId string, step int) (*models.Receipt, error)
+	// GetReceipts returns the receipts of the job with the given id in step order, none if
+	// there are not any
+	GetReceipts(jobId string) ([]*models.Receipt, error)
+	// SaveJobNotes stores the secret notes that may hold the remaining funds of a batch
+	// withdrawal job, replacing the ones stored before. They are kept encrypted as long as
+	// the job, and deleted if notes is empty
+	SaveJobNotes(jobId string, notes []*models.Note) error
+	// GetJobNotes returns the notes stored by SaveJobNotes, none if there are not any
+	GetJobNotes(jobId string) ([]*models.Note, error)
+}
+
+// StatsReader reads the vault statistics
+type StatsReader interface {
+	GetStats() (*models.StatData, error)
+}
+
+// BacklogReader counts the data the internal database is waiting to resolve
+type BacklogReader interface {
+	// CountPendingDeposits returns the number of unexpired deposits waiting for the user
+	CountPendingDeposits() (uint64, error)
+	// CountUnconfirmedNotes returns the number of notes whose transactions have not been
+	// confirmed yet
+	CountUnconfirmedNotes() (uint64, error)
+}
+
+// HealthChecker checks that the databases can be used
+type HealthChecker interface {
+	// PingInternalDb checks that the internal database can be queried
+	PingInternalDb(ctx context.Context) error
+	// PingTxnsDb checks that the txns database can be queried
+	PingTxnsDb(ctx context.Context) error
+	// GetWatermark returns the last round the subscriber saved in the txns database
+	GetWatermark(ctx context.Context) (uint64, error)
+}
+
+// Store is the storage used by the application
+type Store interface {
+	NoteStore
+	DepositStore
+	JobStore
+	TxnsReader
+	StatsReader
+	BacklogReader
+	HealthChecker
+
+	// CleanupUnconfirmedNotes saves the unconfirmed notes whose transactions have been
+	// confirmed and deletes the stale ones
+	CleanupUnconfirmedNotes()
+	// CleanupPendingDeposits deletes the expired deposits
+	CleanupPendingDeposits()
+	// CleanupJobs deletes the jobs not updated for a day, with their receipts and notes
+	CleanupJobs()
+	// Close closes the store
+	Close()
+}
+
+var (
+	_ Store = (*SQLite)(nil)
+	_ Store = (*Memory)(nil)
+)
+
+// unconfirmedNoteMaxAge is the age after which an unconfirmed note whose transaction is
+// not found is deleted as stale
+const unconfirmedNoteMaxAge = 7 * 24 * time.Hour
+
+// StartCleanupRoutine starts a goroutine that periodically runs the store cleanup.
+// It returns a cancel function that can be used to stop the routine.
+func StartCleanupRoutine(ctx context.Context, store Store, interval time.Duration,
+) context.CancelFunc {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// log.Println("Running cleanup of unconfirmed notes...")
+				store.CleanupUnconfirmedNotes()
+				store.CleanupPendingDeposits()
+				store.CleanupJobs()
+			case <-ctx.Done():
+				log.Println("Cleanup routine stopped")
+				return
+			}
+		}
+	}()
+	return cancel
+}
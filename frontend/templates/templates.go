@@ -3,6 +3,9 @@ package templates
 import (
 	"fmt"
 	"html/template"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/models"
 )
 
 var (
@@ -12,6 +15,14 @@ var (
 	ConfirmDeposit    *template.Template
 	ConfirmWithdrawal *template.Template
 	Stats             *template.Template
+
+	WithdrawBatch          *template.Template
+	ConfirmBatchWithdrawal *template.Template
+
+	NoteStatusForm *template.Template
+	NoteStatus     *template.Template
+
+	Receipt *template.Template
 )
 
 func InitTemplates() {
@@ -34,12 +45,23 @@ func InitTemplates() {
 		"safeHTMLAttr": func(s string) template.HTMLAttr {
 			return template.HTMLAttr(s)
 		},
+		// the withdrawal fee policy and the address receiving the fees, if not the TSS
+		"feePolicy": models.FeePolicyText,
+		"feeRecipient": func() string {
+			if config.Fees.Recipient.IsZero() {
+				return ""
+			}
+			return config.Fees.Recipient.String()
+		},
 	}
 	tmpl := template.Must(template.New("main").Funcs(funcMap).ParseFiles(
 		"frontend/templates/main.html",
 		"frontend/templates/confirm_deposit.html",
 		"frontend/templates/confirm_withdrawal.html",
 		"frontend/templates/stats.html",
+		"frontend/templates/batch_withdrawal.html",
+		"frontend/templates/note_status.html",
+		"frontend/templates/receipt.html",
 	))
 	Main = tmpl.Lookup("main")
 	Deposit = tmpl.Lookup("depositForm")
@@ -47,4 +69,9 @@ func InitTemplates() {
 	ConfirmWithdrawal = tmpl.Lookup("confirmWithdrawal")
 	ConfirmDeposit = tmpl.Lookup("confirmDeposit")
 	Stats = tmpl.Lookup("stats")
+	WithdrawBatch = tmpl.Lookup("withdrawBatchForm")
+	ConfirmBatchWithdrawal = tmpl.Lookup("confirmBatchWithdrawal")
+	NoteStatusForm = tmpl.Lookup("noteStatusForm")
+	NoteStatus = tmpl.Lookup("noteStatus")
+	Receipt = tmpl.Lookup("receipt")
 }
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// The JSON API mirrors the HTMX handlers under the /api/v1/ prefix.
+// Requests and responses are JSON, amounts in requests are algo strings parsed like the
+// forms' inputs, and errors are returned as an apiErrorResponse with one of the codes below
+const APIPrefix = "/api/v1/"
+
+// maxAPIRequestBodySize is the maximum size in bytes of an API request body
+const maxAPIRequestBodySize = 64 * 1024
+
+// API error codes
+const (
+	apiErrBadRequest         = "bad_request"
+	apiErrMethodNotAllowed   = "method_not_allowed"
+	apiErrInvalidInput       = "invalid_input"
+	apiErrNoteNotFound       = "note_not_found"
+	apiErrNoteAmountTooSmall = "note_amount_too_small"
+	apiErrSessionNotFound    = "session_not_found"
+	apiErrDataMismatch       = "data_mismatch"
+	apiErrServerBusy         = "server_busy"
+	apiErrDepositFeeChanged  = "deposit_fee_changed"
+	apiErrJobNotFound        = "job_not_found"
+	apiErrTxnsDbLagging      = "txns_db_lagging"
+	apiErrTreeDiverged       = "tree_diverged"
+	apiErrNoteSpent          = "note_spent"
+	apiErrInternal           = "internal_error"
+
+	// codes for errors sending transactions, see txnErrorCode
+	apiErrTxnTimeout                   = "txn_timeout"
+	apiErrTxnRejected                  = "txn_rejected"
+	apiErrTxnOverSpend                 = "txn_overspend"
+	apiErrTxnExpired                   = "txn_expired"
+	apiErrTxnInternal                  = "txn_internal_error"
+	apiErrTxnMinimumBalanceRequirement = "txn_minimum_balance_requirement"
+	apiErrTxnRootExpired               = "txn_root_expired"
+	apiErrTxnBudgetExhausted           = "txn_budget_exhausted"
+)
+
+// apiError is the body of an API error
+type apiError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"` // invalid input fields, if any
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// apiAmount is the API representation of models.Amount
+type apiAmount struct {
+	Microalgos uint64 `json:"microalgos"`
+	Algo       string `json:"algo"`
+}
+
+func newAPIAmount(a models.Amount) apiAmount {
+	return apiAmount{
+		Microalgos: a.Microalgos,
+		Algo:       models.MicroAlgosToAlgoString(a.Microalgos),
+	}
+}
+
+// writeJSON writes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
+// writeAPIError writes an API error response
+func writeAPIError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, apiErrorResponse{Error: apiError{Code: code, Message: message}})
+}
+
+// writeAPIInputError writes an API error response for the given invalid input fields
+func writeAPIInputError(w http.ResponseWriter, fields map[string]string) {
+	writeJSON(w, http.StatusUnprocessableEntity, apiErrorResponse{Error: apiError{
+		Code:    apiErrInvalidInput,
+		Message: "Invalid input",
+		Fields:  fields,
+	}})
+}
+
+// writeAPITxnError writes an API error response for an error sending transactions
+func writeAPITxnError(w http.ResponseWriter, err *avm.TxnConfirmationError,
+	message string) {
+	if err.Type == avm.ErrServerBusy {
+		setRetryAfter(w, err.RetryAfter)
+	}
+	code, status := txnErrorCode(err.Type)
+	writeAPIError(w, status, code, message)
+}
+
+// txnErrorCode returns the API error code and HTTP status for an error type
+func txnErrorCode(t avm.SendTxnErrorType) (string, int) {
+	switch t {
+	case avm.ErrWaitTimeout:
+		return apiErrTxnTimeout, http.StatusRequestTimeout
+	case avm.ErrRejected:
+		return apiErrTxnRejected, http.StatusUnprocessableEntity
+	case avm.ErrOverSpend:
+		return apiErrTxnOverSpend, http.StatusUnprocessableEntity
+	case avm.ErrExpired:
+		return apiErrTxnExpired, http.StatusRequestTimeout
+	case avm.ErrMinimumBalanceRequirement:
+		return apiErrTxnMinimumBalanceRequirement, http.StatusUnprocessableEntity
+	case avm.ErrServerBusy:
+		return apiErrServerBusy, http.StatusServiceUnavailable
+	case avm.ErrTreeDivergence:
+		return apiErrTreeDiverged, http.StatusServiceUnavailable
+	case avm.ErrNoteSpent:
+		return apiErrNoteSpent, http.StatusUnprocessableEntity
+	case avm.ErrRootExpired:
+		return apiErrTxnRootExpired, http.StatusConflict
+	case avm.ErrBudgetExhausted:
+		return apiErrTxnBudgetExhausted, http.StatusInternalServerError
+	default:
+		return apiErrTxnInternal, http.StatusInternalServerError
+	}
+}
+
+// decodeAPIRequest decodes the JSON body of a POST request into v.
+// If it fails, it writes the error response and returns false
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, apiErrMethodNotAllowed,
+			"Method not allowed")
+		return false
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequestBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		log.Printf("Error decoding API request: %v", err)
+		writeAPIError(w, http.StatusBadRequest, apiErrBadRequest,
+			"Request body must be a valid JSON object")
+		return false
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		writeAPIError(w, http.StatusBadRequest, apiErrBadRequest,
+			"Request body must contain a single JSON object")
+		return false
+	}
+	return true
+}
+
+// allowAPIGet checks that the request method is GET, writing the error response if not
+func allowAPIGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, apiErrMethodNotAllowed,
+			"Method not allowed")
+		return false
+	}
+	return true
+}
+
+type apiStatsResponse struct {
+	DepositCount    uint64    `json:"depositCount"`
+	NoteCount       uint64    `json:"noteCount"`
+	SpentNoteCount  uint64    `json:"spentNoteCount"`
+	DepositTotal    apiAmount `json:"depositTotal"`
+	WithdrawalTotal apiAmount `json:"withdrawalTotal"`
+	FeeTotal        apiAmount `json:"feeTotal"`
+	TVL             apiAmount `json:"tvl"`
+}
+
+// APIStatsHandler returns the vault statistics
+func (h *Handlers) APIStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowAPIGet(w, r) {
+		return
+	}
+
+	statData, err := h.store.GetStats()
+	if err != nil {
+		log.Printf("Error retrieving stats: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal,
+			"Error retrieving statistics, try again later")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiStatsResponse{
+		DepositCount:    statData.DepositCount,
+		NoteCount:       statData.NoteCount,
+		SpentNoteCount:  statData.NoteCount - statData.DepositCount,
+		DepositTotal:    newAPIAmount(statData.DepositTotal),
+		WithdrawalTotal: newAPIAmount(statData.WithdrawalTotal),
+		FeeTotal:        newAPIAmount(statData.FeeTotal),
+		TVL:             newAPIAmount(*statData.TVL()),
+	})
+}
+
+type apiMaxDepositResponse struct {
+	Address    models.Address `json:"address"`
+	MaxDeposit apiAmount      `json:"maxDeposit"`
+	// the deposit group fee the max deposit is net of, to send back with the deposit
+	DepositFee apiAmount `json:"depositFee"`
+}
+
+// APIMaxDepositHandler returns the maximum amount the address in the query can deposit
+func (h *Handlers) APIMaxDepositHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowAPIGet(w, r) {
+		return
+	}
+
+	address, err := models.Input(r.URL.Query().Get("address")).ToAddress()
+	if err != nil {
+		writeAPIInputError(w, map[string]string{"address": "Invalid Algorand address"})
+		return
+	}
+
+	maxAmount, fee, err := h.maxDepositAmount(address)
+	if err != nil {
+		log.Printf("Error computing max deposit amount for %s: %v", address, err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal,
+			fmt.Sprintf("Failed to compute max deposit amount for %s", address))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiMaxDepositResponse{
+		Address:    address,
+		MaxDeposit: newAPIAmount(models.NewAmount(maxAmount)),
+		DepositFee: newAPIAmount(models.NewAmount(fee)),
+	})
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/zkp"
+)
+
+type apiDepositRequest struct {
+	Amount  string `json:"amount"`
+	Address string `json:"address"`
+	// the depositFee returned by max-deposit, in algo, to deposit the whole balance net
+	// of it
+	DepositFee string `json:"depositFee"`
+}
+
+type apiDepositResponse struct {
+	Amount         apiAmount      `json:"amount"`
+	Address        models.Address `json:"address"`
+	Note           string         `json:"note"`
+	Txns           []string       `json:"txns"` // base64 msgpack encoded unsigned txns
+	IndexTxnToSign int            `json:"indexTxnToSign"`
+}
+
+type apiConfirmDepositRequest struct {
+	Amount    string `json:"amount"`
+	Address   string `json:"address"`
+	Note      string `json:"note"`
+	SignedTxn string `json:"signedTxn"` // base64 msgpack encoded signed txn
+	// if true, the deposit is sent in the background returning a job to follow it
+	Async bool `json:"async"`
+}
+
+type apiConfirmDepositResponse struct {
+	LeafIndex uint64     `json:"leafIndex"`
+	TxnId     string     `json:"txnId"`
+	Receipt   apiReceipt `json:"receipt"`
+}
+
+// APIDepositHandler creates a new deposit returning the secret note and the transaction
+// group, of which the user has to sign the txn at indexTxnToSign and send it with the
+// note to APIConfirmDepositHandler
+func (h *Handlers) APIDepositHandler(w http.ResponseWriter, r *http.Request) {
+	var req apiDepositRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	amount, errAmount := models.Input(req.Amount).ToAmount()
+	address, errAddress := models.Input(req.Address).ToAddress()
+	depositFee, errFee := parseDepositFee(req.DepositFee)
+	fields := map[string]string{}
+	if errAmount != nil {
+		log.Printf("Error parsing deposit amount: %v", errAmount)
+		fields["amount"] = "Invalid algo amount"
+	}
+	if errAddress != nil {
+		log.Printf("Error parsing deposit address: %v", errAddress)
+		fields["address"] = "Invalid Algorand address"
+	}
+	if errFee != nil {
+		log.Printf("Error parsing deposit fee: %v", errFee)
+		fields["depositFee"] = "Invalid algo amount"
+	}
+	if len(fields) > 0 {
+		writeAPIInputError(w, fields)
+		return
+	}
+
+	depositData, err := h.prepareDeposit(r.Context(), amount, address, depositFee)
+	if errors.Is(err, avm.ErrDepositFeeChanged) {
+		log.Printf("Error preparing deposit: %v", err)
+		writeAPIError(w, http.StatusConflict, apiErrDepositFeeChanged,
+			depositFeeChangedMessage)
+		return
+	}
+	var busy *zkp.BusyError
+	if errors.As(err, &busy) {
+		log.Printf("Error preparing deposit: %v", err)
+		setRetryAfter(w, busy.RetrySeconds())
+		writeAPIError(w, http.StatusServiceUnavailable, apiErrServerBusy,
+			serverBusyMessage(busy.RetrySeconds()))
+		return
+	}
+	if err != nil {
+		log.Printf("Error preparing deposit: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal,
+			"Something went wrong. Please try again")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiDepositResponse{
+		Amount:         newAPIAmount(depositData.Amount),
+		Address:        depositData.Address,
+		Note:           depositData.Note.Text(h.appId()),
+		Txns:           models.EncodeTxnsToBase64(depositData.Txns),
+		IndexTxnToSign: depositData.IndexTxnToSign,
+	})
+}
+
+// APIConfirmDepositHandler sends to the network a deposit created by APIDepositHandler
+// with the txn signed by the user.
+// With async it returns right away the job sending it, to follow with APIJobHandler
+func (h *Handlers) APIConfirmDepositHandler(w http.ResponseWriter, r *http.Request) {
+	var req apiConfirmDepositRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	amount, errAmount := models.Input(req.Amount).ToAmount()
+	address, errAddress := models.Input(req.Address).ToAddress()
+	note, errNote := models.Input(req.Note).ToNote(h.appId())
+	signedTxnBytes, signedTxn, errSignedTxn := decodeSignedTxn(req.SignedTxn)
+	fields := map[string]string{}
+	if errAmount != nil {
+		log.Printf("Error parsing deposit amount: %v", errAmount)
+		fields["amount"] = "Invalid deposit amount"
+	}
+	if errAddress != nil {
+		log.Printf("Error parsing deposit address: %v", errAddress)
+		fields["address"] = "Invalid Algorand address"
+	}
+	if errNote != nil {
+		log.Printf("Error parsing deposit note: %v", errNote)
+		fields["note"] = "Invalid note"
+	}
+	if errSignedTxn != nil {
+		log.Printf("Error decoding signed transaction: %v", errSignedTxn)
+		fields["signedTxn"] = "The signed transaction is malformed"
+	}
+	if len(fields) > 0 {
+		writeAPIInputError(w, fields)
+		return
+	}
+
+	groupId := signedTxn.Txn.Group
+	depositData, err := h.store.RetrieveDeposit(groupId)
+	if err != nil {
+		log.Printf("Error retrieving deposit data: %v", err)
+		writeAPIError(w, http.StatusNotFound, apiErrSessionNotFound,
+			"Deposit not found or expired. Please create a new one")
+		return
+	}
+	h.store.DeleteDeposit(groupId)
+
+	if amount.Microalgos != depositData.Amount.Microalgos || address != depositData.Address ||
+		note.Text(h.appId()) != depositData.Note.Text(h.appId()) {
+		log.Printf("deposit data does not match. Request submitted:\nAmount: %v\nAddress: "+
+			"%v\nNote: <redacted>\n, while memory store had Amount: %v\nAddress: %v\n"+
+			"Note: <redacted>\n",
+			amount, address, depositData.Amount, depositData.Address)
+		writeAPIError(w, http.StatusBadRequest, apiErrDataMismatch,
+			"The deposit data does not match the deposit created")
+		return
+	}
+
+	if req.Async {
+		job, err := h.startDepositJob(depositData, signedTxnBytes)
+		if err != nil {
+			log.Printf("Error starting deposit job: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, apiErrInternal,
+				"Something went wrong. Please try again")
+			return
+		}
+		writeAPIJobStarted(w, job)
+		return
+	}
+
+	receipt, confirmationError := h.sendDeposit(depositData, signedTxnBytes, nil)
+	if confirmationError != nil {
+		log.Printf("Error sending deposit transaction: %v", confirmationError.Error())
+		writeAPITxnError(w, confirmationError,
+			h.depositErrorMessage(confirmationError, address))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiConfirmDepositResponse{
+		LeafIndex: depositData.Note.LeafIndex,
+		TxnId:     depositData.Note.TxnID,
+		Receipt:   newAPIReceipt(receipt),
+	})
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+type apiWithdrawRequest struct {
+	Amount   string `json:"amount"` // ignored if NoChange
+	Address  string `json:"address"`
+	Note     string `json:"note"`
+	NoChange bool   `json:"noChange"` // withdraw the whole note with no change note
+}
+
+type apiWithdrawResponse struct {
+	Amount     apiAmount      `json:"amount"`
+	Fee        apiAmount      `json:"fee"`
+	Address    models.Address `json:"address"`
+	Change     apiAmount      `json:"change"`
+	ChangeNote string         `json:"changeNote,omitempty"` // empty if NoChange
+	NoChange   bool           `json:"noChange"`
+}
+
+type apiConfirmWithdrawRequest struct {
+	Amount     string `json:"amount"` // ignored if NoChange
+	Address    string `json:"address"`
+	FromNote   string `json:"fromNote"`
+	ChangeNote string `json:"changeNote"` // ignored if NoChange
+	NoChange   bool   `json:"noChange"`
+	// if true, the withdrawal is sent in the background returning a job to follow it
+	Async bool `json:"async"`
+}
+
+type apiConfirmWithdrawResponse struct {
+	LeafIndex *uint64    `json:"leafIndex,omitempty"` // leaf index of the change note, if any
+	TxnId     string     `json:"txnId"`
+	Receipt   apiReceipt `json:"receipt"`
+}
+
+// APIWithdrawHandler validates a withdrawal returning its fee and the new secret note for
+// the change, to be sent back with the withdrawal data to APIConfirmWithdrawHandler
+func (h *Handlers) APIWithdrawHandler(w http.ResponseWriter, r *http.Request) {
+	var req apiWithdrawRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	var amount models.Amount
+	var errAmount error
+	if !req.NoChange {
+		amount, errAmount = models.Input(req.Amount).ToAmount()
+	}
+	address, errAddress := models.Input(req.Address).ToAddress()
+	note, errNote := models.Input(req.Note).ToNote(h.appId())
+	fields := map[string]string{}
+	if errAmount != nil {
+		log.Printf("Error parsing withdrawal amount: %v", errAmount)
+		fields["amount"] = "Invalid algo amount"
+	}
+	if errAddress != nil {
+		log.Printf("Error parsing withdrawal address: %v", errAddress)
+		fields["address"] = "Invalid Algorand address"
+	}
+	if errNote != nil {
+		log.Printf("Error parsing withdrawal note: %v", errNote)
+		fields["note"] = "The note you provided is not valid: " + errNote.Error()
+	}
+	if len(fields) > 0 {
+		writeAPIInputError(w, fields)
+		return
+	}
+
+	if !h.setAPILeafIndex(r.Context(), w, note) {
+		return
+	}
+	if req.NoChange {
+		withdrawData, err := models.NewNoChangeWithdrawal(address, note)
+		if err != nil {
+			log.Printf("Error creating no change withdrawal: %v", err)
+			writeAPIError(w, http.StatusUnprocessableEntity, apiErrNoteAmountTooSmall,
+				"Note amount too small to withdraw")
+			return
+		}
+		writeJSON(w, http.StatusOK, apiWithdrawResponse{
+			Amount:   newAPIAmount(withdrawData.Amount),
+			Fee:      newAPIAmount(withdrawData.Fee),
+			Address:  address,
+			Change:   newAPIAmount(models.NewAmount(0)),
+			NoChange: true,
+		})
+		return
+	}
+	changeNote, err := models.GenerateChangeNote(amount, note)
+	if err != nil && err.Error() == "note amount too small" {
+		writeAPIError(w, http.StatusUnprocessableEntity, apiErrNoteAmountTooSmall,
+			"Note amount too small. The maximum you can withdraw is "+
+				note.MaxWithdrawalAmount().Algostring+" algo")
+		return
+	}
+	if err != nil {
+		log.Printf("Error generating new note: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal,
+			"Something went wrong. Please try again")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiWithdrawResponse{
+		Amount:     newAPIAmount(amount),
+		Fee:        newAPIAmount(amount.Fee()),
+		Address:    address,
+		Change:     newAPIAmount(models.NewAmount(changeNote.Amount)),
+		ChangeNote: changeNote.Text(h.appId()),
+	})
+}
+
+// APIConfirmWithdrawHandler sends a withdrawal to the network.
+// With async it returns right away the job sending it, to follow with APIJobHandler
+func (h *Handlers) APIConfirmWithdrawHandler(w http.ResponseWriter, r *http.Request) {
+	var req apiConfirmWithdrawRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	var amount models.Amount
+	var changeNote *models.Note
+	var errAmount, errChangeNote error
+	if !req.NoChange {
+		amount, errAmount = models.Input(req.Amount).ToAmount()
+		changeNote, errChangeNote = models.Input(req.ChangeNote).ToNote(h.appId())
+	}
+	address, errAddress := models.Input(req.Address).ToAddress()
+	fromNote, errFromNote := models.Input(req.FromNote).ToNote(h.appId())
+	fields := map[string]string{}
+	if errAmount != nil {
+		log.Printf("Error parsing withdrawal amount: %v", errAmount)
+		fields["amount"] = "Invalid withdrawal amount"
+	}
+	if errAddress != nil {
+		log.Printf("Error parsing withdrawal address: %v", errAddress)
+		fields["address"] = "Invalid withdrawal address"
+	}
+	if errFromNote != nil {
+		log.Printf("Error parsing withdrawal old note: %v", errFromNote)
+		fields["fromNote"] = "Invalid deposit secret note"
+	}
+	if errChangeNote != nil {
+		log.Printf("Error parsing withdrawal new note: %v", errChangeNote)
+		fields["changeNote"] = "Invalid new secret note"
+	}
+	if len(fields) > 0 {
+		writeAPIInputError(w, fields)
+		return
+	}
+
+	// the proof would be built against a stale root, which the contract may not accept
+	if h.txnsDbLagging(r.Context()) {
+		writeAPITxnsDbLagging(w)
+		return
+	}
+	if !h.setAPILeafIndex(r.Context(), w, fromNote) {
+		return
+	}
+
+	var withdrawData *models.WithdrawalData
+	if req.NoChange {
+		var err error
+		withdrawData, err = models.NewNoChangeWithdrawal(address, fromNote)
+		if err != nil {
+			log.Printf("Error creating no change withdrawal: %v", err)
+			writeAPIError(w, http.StatusUnprocessableEntity, apiErrNoteAmountTooSmall,
+				"Note amount too small to withdraw")
+			return
+		}
+	} else {
+		withdrawData = &models.WithdrawalData{
+			Amount:     amount,
+			Fee:        amount.Fee(),
+			Address:    address,
+			FromNote:   fromNote,
+			ChangeNote: changeNote,
+		}
+	}
+
+	if req.Async {
+		job, err := h.startWithdrawalJob(withdrawData)
+		if err != nil {
+			log.Printf("Error starting withdrawal job: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, apiErrInternal,
+				"Something went wrong. Please try again")
+			return
+		}
+		writeAPIJobStarted(w, job)
+		return
+	}
+
+	receipt, confirmationError := h.sendWithdrawal(r.Context(), withdrawData, nil)
+	if confirmationError != nil {
+		log.Printf("Error sending withdrawal transaction: %v", confirmationError.Error())
+		writeAPITxnError(w, confirmationError, withdrawalErrorMessage(confirmationError))
+		return
+	}
+
+	response := apiConfirmWithdrawResponse{
+		TxnId:   withdrawData.ChangeNote.TxnID,
+		Receipt: newAPIReceipt(receipt),
+	}
+	if !withdrawData.NoChange {
+		response.LeafIndex = &withdrawData.ChangeNote.LeafIndex
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// setAPILeafIndex sets the leaf index of the note from the txns database.
+// If it fails, it writes the error response and returns false
+func (h *Handlers) setAPILeafIndex(ctx context.Context, w http.ResponseWriter,
+	note *models.Note) bool {
+	var err error
+	note.LeafIndex, err = h.store.GetLeafIndexByCommitment(note.Commitment())
+	switch err {
+	case nil:
+		return true
+	case sql.ErrNoRows:
+		log.Printf("Leaf index not found for commitment: %v", note.Commitment())
+		if h.txnsDbLagging(ctx) {
+			writeAPITxnsDbLagging(w)
+			return false
+		}
+		writeAPIError(w, http.StatusUnprocessableEntity, apiErrNoteNotFound,
+			"The note you provided is not valid")
+		return false
+	default:
+		log.Printf("Error getting leaf index by commitment: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal,
+			"Something went wrong. Please try again")
+		return false
+	}
+}
+
+// writeAPITxnsDbLagging writes the API error response for a lagging txns database
+func writeAPITxnsDbLagging(w http.ResponseWriter) {
+	setRetryAfter(w, txnsDbLagRetrySeconds)
+	writeAPIError(w, http.StatusServiceUnavailable, apiErrTxnsDbLagging,
+		txnsDbLaggingMessage)
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/frontend/templates"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/zkp"
+)
+
+// BatchWithdrawHandler serves the batch withdrawal form and, on POST, the confirmation
+// page for a withdrawal from one note to several recipients
+func (h *Handlers) BatchWithdrawHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Cache-Control", config.CacheControl)
+
+		// Check if this is an HTMX request, if not, render the full page
+		if RenderFullPageIfNotHtmx(w, r, "withdraw-batch") {
+			return
+		}
+
+		data := struct{ MaxRecipients int }{config.MaxBatchWithdrawalRecipients}
+		if err := templates.WithdrawBatch.Execute(w, data); err != nil {
+			log.Printf("Error executing batch withdraw template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			log.Printf("Error parsing form: %v", err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		recipients, errRecipients := models.Input(r.FormValue("recipients")).ToRecipients()
+		note, errNote := models.Input(r.FormValue("note")).ToNote(h.appId())
+		errorMsg := ""
+		if errRecipients != nil {
+			log.Printf("Error parsing batch withdrawal recipients: %v", errRecipients)
+			errorMsg += "Invalid recipients: " + html.EscapeString(errRecipients.Error()) +
+				"<br>"
+		} else if len(recipients) > config.MaxBatchWithdrawalRecipients {
+			errorMsg += fmt.Sprintf("You can pay at most %d recipients at once<br>",
+				config.MaxBatchWithdrawalRecipients)
+		}
+		if errNote != nil {
+			log.Printf("Error parsing batch withdrawal note: %v", errNote)
+			errorMsg += "The note you provided is not valid: " +
+				html.EscapeString(errNote.Error())
+		}
+		if errorMsg != "" {
+			http.Error(w, errorMsg, http.StatusUnprocessableEntity)
+			return
+		}
+
+		var err error
+		note.LeafIndex, err = h.store.GetLeafIndexByCommitment(note.Commitment())
+		switch err {
+		case nil:
+		case sql.ErrNoRows:
+			log.Printf("Leaf index not found for commitment: %v", note.Commitment())
+			if h.txnsDbLagging(r.Context()) {
+				setRetryAfter(w, txnsDbLagRetrySeconds)
+				http.Error(w, txnsDbLaggingMessage, http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, "The note you provided is not in the vault.<br>"+
+				`<a class="underlined" hx-get="note-status" hx-target="#ui">`+
+				"Check the note</a> to learn if it is spent or still pending<br>",
+				http.StatusUnprocessableEntity)
+			return
+		default:
+			log.Printf("Error getting leaf index by commitment: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		batchData, err := models.NewBatchWithdrawal(note, recipients)
+		if err != nil && err.Error() == "note amount too small" {
+			http.Error(w, "Note amount too small to pay all the recipients.<br>"+
+				"The note holds <b>"+note.AmountAlgoString()+" algo</b> and each "+
+				"withdrawal has a fee of at least <b>"+
+				models.MicroAlgosToAlgoString(models.MinWithdrawalFee())+" algo</b>",
+				http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			log.Printf("Error generating batch withdrawal notes: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := struct {
+			*models.BatchWithdrawalData
+			Recipients string
+			AppId      uint64
+		}{batchData, r.FormValue("recipients"), h.appId()}
+		if err := templates.ConfirmBatchWithdrawal.Execute(w, data); err != nil {
+			log.Printf("Error executing batch confirm template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ConfirmBatchWithdrawHandler starts sending the chain of withdrawals of a batch withdrawal
+// in the background, rendering the job page to follow it
+func (h *Handlers) ConfirmBatchWithdrawHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error parsing form: %v", err)
+		http.Error(w, modalWithdrawalFailed("Bad request"), http.StatusBadRequest)
+		return
+	}
+	recipients, errRecipients := models.Input(r.FormValue("recipients")).ToRecipients()
+	fromNote, errFromNote := models.Input(r.FormValue("fromNote")).ToNote(h.appId())
+	finalNote := strings.TrimSpace(r.FormValue("changeNote"))
+	var changeNotes []*models.Note
+	var errChangeNotes error
+	for _, text := range r.Form["changeNotes"] {
+		note, err := models.Input(text).ToNote(h.appId())
+		if err != nil {
+			errChangeNotes = err
+			break
+		}
+		changeNotes = append(changeNotes, note)
+	}
+
+	errorMsg := ""
+	if errRecipients != nil || len(recipients) > config.MaxBatchWithdrawalRecipients {
+		log.Printf("Error parsing batch withdrawal recipients: %v", errRecipients)
+		errorMsg += "Invalid recipients<br>"
+	}
+	if errFromNote != nil {
+		log.Printf("Error parsing batch withdrawal old note: %v", errFromNote)
+		errorMsg += "Invalid deposit secret note<br>"
+	}
+	if errChangeNotes != nil || len(changeNotes) == 0 ||
+		changeNotes[len(changeNotes)-1].Text(h.appId()) != finalNote {
+		log.Printf("Error parsing batch withdrawal new notes: %v", errChangeNotes)
+		errorMsg += "Invalid new secret note<br>"
+	}
+	if errorMsg != "" {
+		log.Printf("Invalid batch withdrawal data: %s", errorMsg)
+		http.Error(w, modalWithdrawalFailed(errorMsg), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// the proofs would be built against a stale root, which the contract may not accept
+	if h.txnsDbLagging(r.Context()) {
+		setRetryAfter(w, txnsDbLagRetrySeconds)
+		http.Error(w, modalWithdrawalFailed(txnsDbLaggingMessage),
+			http.StatusServiceUnavailable)
+		return
+	}
+	var err error
+	fromNote.LeafIndex, err = h.store.GetLeafIndexByCommitment(fromNote.Commitment())
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		log.Printf("Leaf index not found for commitment: %v", fromNote.Commitment())
+		http.Error(w, modalWithdrawalFailed("The note you provided is not in the vault"),
+			http.StatusUnprocessableEntity)
+		return
+	default:
+		log.Printf("Error getting leaf index by commitment: %v", err)
+		http.Error(w, modalWithdrawalFailed("Something went wrong"),
+			http.StatusInternalServerError)
+		return
+	}
+
+	batchData, err := models.ChainBatchWithdrawal(fromNote, recipients, changeNotes)
+	if err != nil {
+		log.Printf("Error chaining batch withdrawal: %v", err)
+		http.Error(w, modalWithdrawalFailed("Invalid withdrawal data"),
+			http.StatusUnprocessableEntity)
+		return
+	}
+
+	job, err := h.startBatchWithdrawalJob(batchData)
+	if err != nil {
+		log.Printf("Error starting batch withdrawal job: %v", err)
+		http.Error(w, modalWithdrawalFailed("Something went wrong"),
+			http.StatusInternalServerError)
+		return
+	}
+	writeJobStarted(w, job)
+}
+
+// startBatchWithdrawalJob sends the chain of withdrawals of a batch withdrawal in the
+// background, one after the other, waiting for the change note of each to be in the tree
+// before spending it in the next one. It returns the job to follow it.
+// Before each withdrawal it stores with the job the notes that may hold the remaining
+// funds, so that if one fails, or the server stops, the job reports the right one
+func (h *Handlers) startBatchWithdrawalJob(batchData *models.BatchWithdrawalData,
+) (*models.Job, error) {
+	return h.startJob(models.BatchWithdrawalJob, func(t *jobTracker) {
+		var receipts []*models.Receipt
+		for i, withdrawData := range batchData.Withdrawals {
+			if i > 0 {
+				t.next()
+				err := h.avm.WaitForLeaf(withdrawData.FromNote.LeafIndex,
+					config.LeafSyncTimeout)
+				if err != nil {
+					log.Printf("Error waiting for batch change note leaf: %v", err)
+					h.saveJobNotes(t, withdrawData.FromNote)
+					t.failed(avm.InternalError("change note not synced: "+err.Error()),
+						batchFailedMessage(receipts, "The previous withdrawal has not "+
+							"reached our database yet. Please wait a few minutes and "+
+							"withdraw the remaining recipients with this secret note:"))
+					return
+				}
+			}
+
+			h.saveJobNotes(t, withdrawData.FromNote, withdrawData.ChangeNote)
+			ctx := zkp.WithQueuePosition(context.Background(), t.queuePosition)
+			receipt, confirmationError := h.sendWithdrawal(ctx, withdrawData, t.submitted)
+			if confirmationError != nil {
+				log.Printf("Batch withdrawal job %s %d/%d failed: %v", t.job.Id, i+1,
+					len(batchData.Withdrawals), confirmationError.Error())
+				var msg string
+				switch {
+				case confirmationError.Type == avm.ErrWaitTimeout:
+					msg = "The withdrawal to " + string(withdrawData.Address) + " has " +
+						"not been confirmed by the blockchain yet. Please wait a few " +
+						"minutes and check if it was received. If so, your remaining " +
+						"funds are in the second secret note below, otherwise in the first:"
+				case i == 0:
+					msg = withdrawalErrorMessage(confirmationError)
+					h.saveJobNotes(t)
+				default:
+					msg = "The withdrawal to " + string(withdrawData.Address) +
+						" failed. Your remaining funds are in this secret note:"
+					h.saveJobNotes(t, withdrawData.FromNote)
+				}
+				t.failed(confirmationError, batchFailedMessage(receipts, msg))
+				return
+			}
+			receipts = append(receipts, receipt)
+			if i < len(batchData.Withdrawals)-1 {
+				t.addReceipt(receipt)
+			}
+		}
+		// the remaining funds are in the last change note, which the user saved
+		h.saveJobNotes(t)
+		t.confirmed(receipts[len(receipts)-1])
+	})
+}
+
+// saveJobNotes stores notes as the ones that may hold the remaining funds of the batch
+// withdrawal job of t
+func (h *Handlers) saveJobNotes(t *jobTracker, notes ...*models.Note) {
+	if err := h.store.SaveJobNotes(t.job.Id, notes); err != nil {
+		log.Printf("Error saving notes of job %s: %v", t.job.Id, err)
+	}
+}
+
+// batchFailedMessage returns the message for the user of a failed batch withdrawal,
+// listing the withdrawals completed before it failed
+func batchFailedMessage(receipts []*models.Receipt, message string) string {
+	if len(receipts) == 0 {
+		return message
+	}
+	var b strings.Builder
+	b.WriteString("These withdrawals were completed: ")
+	for i, receipt := range receipts {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s algo to %s (txn %s)", receipt.Amount.Algostring,
+			receipt.Address, receipt.TxnId)
+	}
+	b.WriteString(". ")
+	b.WriteString(message)
+	return b.String()
+}
+
+// modalBatchJobFailed renders a failed batch withdrawal job, with the notes that may hold
+// the remaining funds and the receipts of the withdrawals completed
+func (h *Handlers) modalBatchJobFailed(job *models.Job) string {
+	message := html.EscapeString(job.Message)
+	notes, err := h.store.GetJobNotes(job.Id)
+	if err != nil {
+		log.Printf("Error getting notes of job %s: %v", job.Id, err)
+	}
+	for _, note := range notes {
+		message += h.noteHtml(note)
+	}
+	if receipts := h.batchReceiptsHtml(job.Id); receipts != "" {
+		message += "<br>" + receipts
+	}
+	return modalWithdrawalFailed(message)
+}
+
+// batchReceiptsHtml renders the links to the receipts of the completed withdrawals of the
+// batch withdrawal job with the given id, one per line
+func (h *Handlers) batchReceiptsHtml(id string) string {
+	receipts, err := h.store.GetReceipts(id)
+	if err != nil {
+		log.Printf("Error getting receipts of job %s: %v", id, err)
+	}
+	lines := make([]string, len(receipts))
+	for step, receipt := range receipts {
+		lines[step] = fmt.Sprintf("%s algo to %s: %s", receipt.Amount.Algostring,
+			receipt.Address, receiptLinks(id, step))
+	}
+	return strings.Join(lines, "<br>")
+}
+
+// noteHtml renders a secret note to be saved by the user
+func (h *Handlers) noteHtml(note *models.Note) string {
+	return `<span class="<small> boxed-text ok color border bg">` + note.Text(h.appId()) +
+		`</span>`
+}
@@ -7,43 +7,44 @@ import (
 	"net/http"
 
 	"github.com/giuliop/HermesVault-frontend/avm"
-	"github.com/giuliop/HermesVault-frontend/config"
-	"github.com/giuliop/HermesVault-frontend/db"
-	"github.com/giuliop/HermesVault-frontend/memstore"
+	"github.com/giuliop/HermesVault-frontend/metrics"
 	"github.com/giuliop/HermesVault-frontend/models"
 
 	"github.com/algorand/go-algorand-sdk/v2/encoding/msgpack"
-	"github.com/algorand/go-algorand-sdk/v2/transaction"
 	"github.com/algorand/go-algorand-sdk/v2/types"
 )
 
-// maxDepositAmount returns the maximum amount in microalgos that a user can deposit.
-// This is the current balance, minus the MBR, minus the deposit txn fee.
-func maxDepositAmount(address models.Address) (uint64, error) {
-	balance, mbr, err := avm.GetBalanceAndMBR(string(address))
+// maxDepositAmount returns the maximum amount in microalgos that a user can deposit and
+// the deposit group fee quoted, to be sent back with the deposit.
+// This is the current balance, minus the MBR, minus the deposit group fee.
+func (h *Handlers) maxDepositAmount(address models.Address) (amount, fee uint64,
+	err error) {
+	balance, mbr, err := h.avm.GetBalanceAndMBR(string(address))
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
 	var netBalance uint64
 
+	fee = h.avm.DepositFee()
 	// if the MBR is just the minimum, the account could be closed-out
-	if mbr == avm.MinimumBalance {
+	if mbr == h.avm.MinimumBalance {
 		netBalance = balance
 	} else if balance < mbr {
-		return 0, nil
+		return 0, fee, nil
 	} else {
 		netBalance = balance - mbr
 	}
 
-	fee := uint64(config.DepositMinFeeMultiplier * transaction.MinTxnFee)
 	if netBalance <= fee {
-		return 0, nil
+		return 0, fee, nil
 	}
-	return netBalance - fee, nil
+	return netBalance - fee, fee, nil
 }
 
-func ConfirmDepositHandler(w http.ResponseWriter, r *http.Request) {
+// ConfirmDepositHandler starts sending a deposit in the background, rendering the job
+// page following it
+func (h *Handlers) ConfirmDepositHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
@@ -56,7 +57,7 @@ func ConfirmDepositHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	amount, errAmount := models.Input(r.FormValue("amount")).ToAmount()
 	address, errAddress := models.Input(r.FormValue("address")).ToAddress()
-	note, errNote := models.Input(r.FormValue("note")).ToNote()
+	note, errNote := models.Input(r.FormValue("note")).ToNote(h.appId())
 
 	errorMsg := ""
 	if errAmount != nil {
@@ -77,17 +78,7 @@ func ConfirmDepositHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	signedTxnBase64 := r.FormValue("signedTxn")
-	signedTxnBytes, err := base64.StdEncoding.DecodeString(signedTxnBase64)
-	if err != nil {
-		log.Printf("Error decoding signed transaction: %v", err)
-		http.Error(w, modalDepositFailed("The signed transaction is malformed"),
-			http.StatusBadRequest)
-		return
-	}
-
-	var signedTxn types.SignedTxn
-	err = msgpack.Decode(signedTxnBytes, &signedTxn)
+	signedTxnBytes, signedTxn, err := decodeSignedTxn(r.FormValue("signedTxn"))
 	if err != nil {
 		log.Printf("Error decoding signed transaction: %v", err)
 		http.Error(w, modalDepositFailed("The signed transaction is malformed"),
@@ -96,18 +87,17 @@ func ConfirmDepositHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	groupId := signedTxn.Txn.Group
-	ms := memstore.UserSessions
-	depositData, err := ms.RetrieveDeposit(groupId)
+	depositData, err := h.store.RetrieveDeposit(groupId)
 	if err != nil {
 		log.Printf("Error retrieving deposit data: %v", err)
 		http.Error(w, modalDepositFailed("Something went wrong"),
 			http.StatusInternalServerError)
 		return
 	}
-	ms.DeleteDeposit(groupId)
+	h.store.DeleteDeposit(groupId)
 
 	if amount.Microalgos != depositData.Amount.Microalgos || address != depositData.Address ||
-		note.Text() != depositData.Note.Text() {
+		note.Text(h.appId()) != depositData.Note.Text(h.appId()) {
 		log.Printf("deposit data does not match. Form submitted:\nAmount: %v\nAddress: "+
 			"%v\nNote: <redacted>\n, while memory store had Amount: %v\nAddress: %v\n"+
 			"Note: <redacted>\n",
@@ -116,96 +106,69 @@ func ConfirmDepositHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	noteId, err := db.RegisterUnconfirmedNote(depositData.Note)
+	job, err := h.startDepositJob(depositData, signedTxnBytes)
 	if err != nil {
-		log.Printf("Error saving unconfirmed deposit: %v", err)
+		log.Printf("Error starting deposit job: %v", err)
 		http.Error(w, modalDepositFailed("Something went wrong"),
 			http.StatusInternalServerError)
 		return
 	}
+	writeJobStarted(w, job)
+}
+
+// decodeSignedTxn decodes a base64 msgpack encoded signed transaction, returning both
+// the raw bytes and the decoded transaction
+func decodeSignedTxn(signedTxnBase64 string) ([]byte, *types.SignedTxn, error) {
+	signedTxnBytes, err := base64.StdEncoding.DecodeString(signedTxnBase64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode base64: %v", err)
+	}
+	var signedTxn types.SignedTxn
+	err = msgpack.Decode(signedTxnBytes, &signedTxn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode msgpack: %v", err)
+	}
+	return signedTxnBytes, &signedTxn, nil
+}
 
-	var leafIndex uint64
+// sendDeposit registers the deposit note as unconfirmed, sends the deposit transactions
+// with the user signed one to the network and, once they are confirmed, saves the note
+// with its leaf index to the database, returning the deposit receipt.
+// If onSubmit is not nil it is called with the id of the first group txn before sending
+// the transactions
+func (h *Handlers) sendDeposit(depositData *models.DepositData, signedTxnBytes []byte,
+	onSubmit func(txnId string)) (*models.Receipt, *avm.TxnConfirmationError) {
+	var leafIndex, round uint64
 	var txnId string
 	var confirmationError *avm.TxnConfirmationError
 	var saveNoteToDbError error
 
+	defer func() { metrics.Deposits.Inc(txnOutcome(confirmationError)) }()
+
+	noteId, err := h.store.RegisterUnconfirmedNote(depositData.Note)
+	if err != nil {
+		confirmationError = avm.InternalError(
+			"failed to save unconfirmed deposit: " + err.Error())
+		return nil, confirmationError
+	}
+
 	// We can delete the unconfirmed note from the database if one of these is true:
 	// * txn confirmed by the blockchain and note saved to the database
 	// * error sending the txn other that timeout waiting for confirmation
 	// Otherwise we keep the unconfirmed note, the cleanup process will eventually handle it
 	defer func() {
-		if (confirmationError == nil && saveNoteToDbError == nil) ||
-			confirmationError.Type != avm.ErrWaitTimeout {
-			db.DeleteUnconfirmedNote(noteId)
+		if !keepUnconfirmedNote(confirmationError, saveNoteToDbError) {
+			h.store.DeleteUnconfirmedNote(noteId)
 		}
 	}()
 
-	leafIndex, txnId, confirmationError = avm.SendDepositToNetwork(depositData.Txns,
+	if onSubmit != nil {
+		onSubmit(depositData.Note.TxnID)
+	}
+	leafIndex, txnId, round, confirmationError = h.avm.SendDepositToNetwork(depositData.Txns,
 		signedTxnBytes)
-
 	if confirmationError != nil {
-		switch confirmationError.Type {
-
-		case avm.ErrRejected:
-			log.Printf("Deposit transaction rejected: %v", confirmationError.Error())
-			msg := `Your deposit transaction was rejected by the network.<br>
-					Please try again`
-			http.Error(w, modalDepositFailed(msg), http.StatusUnprocessableEntity)
-			return
-
-		case avm.ErrOverSpend:
-			log.Printf("Deposit transaction overspent: %v", confirmationError.Error())
-			var msg string
-
-			maxSpend, err := maxDepositAmount(address)
-			if err == nil {
-				msg = fmt.Sprintf("The maximum amount you can deposit is %s ALGO",
-					models.MicroAlgosToAlgoString(maxSpend))
-			} else {
-				msg = `You do not have enough funds to cover this deposit`
-			}
-			http.Error(w, modalDepositFailed(msg), http.StatusUnprocessableEntity)
-			return
-
-		case avm.ErrMinimumBalanceRequirement:
-			log.Printf("Deposit transaction fails MBR: %v", confirmationError.Error())
-			var msg string
-
-			maxSpend, err := maxDepositAmount(address)
-			if err == nil {
-				msg = fmt.Sprintf("The maximum amount you can deposit is %s ALGO",
-					models.MicroAlgosToAlgoString(maxSpend))
-			} else {
-				msg = `You do not have enough funds to cover the account minimum
-					   balance requirement with this deposit`
-			}
-			http.Error(w, modalDepositFailed(msg), http.StatusUnprocessableEntity)
-			return
-
-		case avm.ErrExpired:
-			log.Printf("Deposit transaction expired: %v", confirmationError.Error())
-			msg := `Too much time has passed and your deposit transaction has expired.
-					<br>Please try again`
-			http.Error(w, modalDepositFailed(msg), http.StatusRequestTimeout)
-			return
-
-		case avm.ErrWaitTimeout:
-			log.Printf("Deposit transaction timed out: %v", confirmationError.Error())
-			msg := `Your deposit has not been confirmed by the network yet.<br>
-					Please wait a few minutes and check your wallet to see if the
-					deposit was sent.<br>
-					If not, please try again.`
-			http.Error(w, modalDepositFailed(msg), http.StatusRequestTimeout)
-			return
-
-		case avm.ErrInternal:
-			log.Printf("Internal error sending deposit transaction: %v",
-				confirmationError.Error())
-			msg := `Something went wrong. Your deposit was not processed.<br>
-					Please try again.`
-			http.Error(w, modalDepositFailed(msg), http.StatusInternalServerError)
-			return
-		}
+		return nil, confirmationError
 	}
 
 	// Log successful deposit
@@ -218,26 +181,22 @@ func ConfirmDepositHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Deposit txnId mismatch. %v != %v", txnId, depositData.Note.TxnID)
 	}
 
-	successHtml := `
-		<dialog class="modal">
-		  <h1>&#9989; Deposit successful</h1>
-		  <p>
-			You can use your new secret note to withdraw your funds in the future.
-		  </p>
-		  <button hx-get="withdraw" onclick="this.parentElement.close()">
-			Close
-		  </button>
-		</dialog>
-		<script>
-		  document.querySelectorAll('dialog')[0].showModal()
-		</script>
-	`
-	fmt.Fprint(w, successHtml)
-
-	saveNoteToDbError = db.SaveNote(depositData.Note)
+	saveNoteToDbError = h.store.SaveNote(depositData.Note)
 	if saveNoteToDbError != nil {
 		log.Printf("Error saving deposit to db: %v", saveNoteToDbError)
 	}
+	return models.NewDepositReceipt(depositData, round, h.blockTime(round)), nil
+}
+
+// keepUnconfirmedNote reports whether the unconfirmed note of a deposit or withdrawal must
+// be kept for the cleanup process: if the txn timed out it may still be confirmed, and if
+// it was confirmed but the note was not saved the cleanup process will save it
+func keepUnconfirmedNote(confirmationError *avm.TxnConfirmationError,
+	saveNoteToDbError error) bool {
+	if confirmationError == nil {
+		return saveNoteToDbError != nil
+	}
+	return confirmationError.Type == avm.ErrWaitTimeout
 }
 
 func modalDepositFailed(message string) string {
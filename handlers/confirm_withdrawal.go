@@ -1,18 +1,22 @@
 package handlers
 
 import (
-	"fmt"
+	"context"
+	"database/sql"
+	"html"
 	"log"
 	"net/http"
 
 	"github.com/giuliop/HermesVault-frontend/avm"
-	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/metrics"
 	"github.com/giuliop/HermesVault-frontend/models"
 
 	"github.com/algorand/go-algorand-sdk/v2/crypto"
 )
 
-func ConfirmWithdrawHandler(w http.ResponseWriter, r *http.Request) {
+// ConfirmWithdrawHandler starts sending a withdrawal in the background, rendering the job
+// page following it
+func (h *Handlers) ConfirmWithdrawHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
@@ -23,10 +27,17 @@ func ConfirmWithdrawHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, modalWithdrawalFailed("Bad request"), http.StatusBadRequest)
 		return
 	}
-	amount, errAmount := models.Input(r.FormValue("amount")).ToAmount()
+	// with noChange the whole note is withdrawn, so there is no amount or change note
+	noChange := r.FormValue("noChange") == "true"
+	var amount models.Amount
+	var changeNote *models.Note
+	var errAmount, errChangeNote error
+	if !noChange {
+		amount, errAmount = models.Input(r.FormValue("amount")).ToAmount()
+		changeNote, errChangeNote = models.Input(r.FormValue("changeNote")).ToNote(h.appId())
+	}
 	address, errAddress := models.Input(r.FormValue("address")).ToAddress()
-	fromNote, errFromNote := models.Input(r.FormValue("fromNote")).ToNote()
-	changeNote, errChangeNote := models.Input(r.FormValue("changeNote")).ToNote()
+	fromNote, errFromNote := h.noteFromForm(r, "fromNote")
 
 	errorMsg := ""
 	if errAmount != nil {
@@ -39,7 +50,8 @@ func ConfirmWithdrawHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	if errFromNote != nil {
 		log.Printf("Error parsing withdrawal old note: %v", errFromNote)
-		errorMsg += "Invalid deposit secret note<br>"
+		errorMsg += "Invalid deposit secret note: " +
+			html.EscapeString(errFromNote.Error()) + "<br>"
 	}
 	if errChangeNote != nil {
 		log.Printf("Error parsing withdrawal new note: %v", errChangeNote)
@@ -50,87 +62,142 @@ func ConfirmWithdrawHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, modalWithdrawalFailed(errorMsg), http.StatusUnprocessableEntity)
 		return
 	}
+	// the proof would be built against a stale root, which the contract may not accept
+	if h.txnsDbLagging(r.Context()) {
+		setRetryAfter(w, txnsDbLagRetrySeconds)
+		http.Error(w, modalWithdrawalFailed(txnsDbLaggingMessage),
+			http.StatusServiceUnavailable)
+		return
+	}
 	var err error
-	fromNote.LeafIndex, err = db.GetLeafIndexByCommitment(fromNote.Commitment())
-	if err != nil {
+	fromNote.LeafIndex, err = h.store.GetLeafIndexByCommitment(fromNote.Commitment())
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		log.Printf("Leaf index not found for commitment: %v", fromNote.Commitment())
+		http.Error(w, modalWithdrawalFailed("The note you provided is not in the vault"),
+			http.StatusUnprocessableEntity)
+		return
+	default:
 		log.Printf("Error getting leaf index by commitment: %v", err)
 		http.Error(w, modalWithdrawalFailed("Something went wrong"),
 			http.StatusInternalServerError)
 		return
 	}
 
-	withdrawData := &models.WithdrawalData{
-		Amount:     amount,
-		Fee:        amount.Fee(),
-		Address:    address,
-		FromNote:   fromNote,
-		ChangeNote: changeNote,
-	}
-
-	txns, err := avm.CreateWithdrawalTxns(withdrawData)
-	if err != nil {
-		log.Printf("Error creating withdrawal transactions: %v", err)
-		http.Error(w, modalWithdrawalFailed("Something went wrong"),
-			http.StatusInternalServerError)
-		return
+	var withdrawData *models.WithdrawalData
+	if noChange {
+		withdrawData, err = models.NewNoChangeWithdrawal(address, fromNote)
+		if err != nil {
+			log.Printf("Error creating no change withdrawal: %v", err)
+			http.Error(w, modalWithdrawalFailed("The note amount is too small to withdraw"),
+				http.StatusUnprocessableEntity)
+			return
+		}
+	} else {
+		withdrawData = &models.WithdrawalData{
+			Amount:     amount,
+			Fee:        amount.Fee(),
+			Address:    address,
+			FromNote:   fromNote,
+			ChangeNote: changeNote,
+		}
 	}
 
-	withdrawData.ChangeNote.TxnID = crypto.GetTxID(txns[0])
-	noteId, err := db.RegisterUnconfirmedNote(withdrawData.ChangeNote)
+	job, err := h.startWithdrawalJob(withdrawData)
 	if err != nil {
-		log.Printf("Error saving unconfirmed withdrawal: %v", err)
+		log.Printf("Error starting withdrawal job: %v", err)
 		http.Error(w, modalWithdrawalFailed("Something went wrong"),
 			http.StatusInternalServerError)
 		return
 	}
+	writeJobStarted(w, job)
+}
 
-	var leafIndex uint64
+// sendWithdrawal creates the withdrawal transactions, registers the change note as
+// unconfirmed, sends the transactions to the network and, once they are confirmed, saves
+// the change note with its leaf index to the database, returning the withdrawal receipt.
+// For a withdrawal with no change there is no change note to register or save.
+// If the withdrawal is rejected because the root of the proof is no longer accepted by the
+// contract (e.g. other frontends inserted many leaves meanwhile), it retries once with a
+// proof against a fresher root.
+// The proof is dropped if ctx is done while it waits in the prover queue.
+// If onSubmit is not nil it is called with the id of the first group txn before sending
+// the transactions
+func (h *Handlers) sendWithdrawal(ctx context.Context, withdrawData *models.WithdrawalData,
+	onSubmit func(txnId string)) (*models.Receipt, *avm.TxnConfirmationError) {
+	var leafIndex, round uint64
 	var txnId string
+	var noteId int64
 	var confirmationError *avm.TxnConfirmationError
 	var saveNoteToDbError error
 
+	defer func() { metrics.Withdrawals.Inc(txnOutcome(confirmationError)) }()
+
 	// We can delete the unconfirmed note if one of these is true:
 	// * txn confirmed by the blockchain and note saved to the database
 	// * error sending the txn other that timeout waiting for confirmation
 	// Otherwise we keep the unconfirmed note, the cleanup process will eventually handle it
+	// A withdrawal with no change has no unconfirmed note, and noteId is 0 until one is
+	// registered
 	defer func() {
-		if (confirmationError == nil && saveNoteToDbError == nil) ||
-			confirmationError.Type != avm.ErrWaitTimeout {
-			db.DeleteUnconfirmedNote(noteId)
+		if withdrawData.NoChange || noteId == 0 {
+			return
+		}
+		if !keepUnconfirmedNote(confirmationError, saveNoteToDbError) {
+			h.store.DeleteUnconfirmedNote(noteId)
 		}
 	}()
 
-	leafIndex, txnId, confirmationError = avm.SendWithdrawalToNetworkWithTSS(txns)
-	if confirmationError != nil {
-		switch confirmationError.Type {
-		case avm.ErrRejected:
-			log.Printf("Withdrawal transaction rejected: %v", confirmationError.Error())
-			msg := `Your withdrawal was rejected by the network.<br>
-					Please check your secret note and try again.`
-			http.Error(w, modalWithdrawalFailed(msg), http.StatusUnprocessableEntity)
-			return
-		case avm.ErrWaitTimeout:
-			log.Printf("Withdrawal transaction timed out: %v", confirmationError.Error())
-			msg := `Your withdrawal has not been confirmed by the blockchain yet.<br>
-					Please wait a few minutes and check your wallet to see if the withdrawal was received.<br>
-					If not, please try again.`
-			http.Error(w, modalWithdrawalFailed(msg), http.StatusRequestTimeout)
-			return
-		case avm.ErrInternal:
-			log.Printf("Internal error sending withdrawal transaction: %v",
-				confirmationError.Error())
-			msg := `Something went wrong. Your withdrawal was not processed.<br>
-					Please try again.`
-			http.Error(w, modalWithdrawalFailed(msg), http.StatusInternalServerError)
-			return
-		default:
-			log.Printf("Unknown error sending withdrawal transaction: %v",
-				confirmationError.Error())
-			msg := `Something went wrong. Your withdrawal was not processed.<br>
-					Please try again.`
-			http.Error(w, modalWithdrawalFailed(msg), http.StatusInternalServerError)
-			return
+	for attempt := 1; ; attempt++ {
+		txns, err := h.avm.CreateWithdrawalTxns(ctx, withdrawData)
+		if err != nil {
+			confirmationError = avm.CreateTxnsError(
+				"failed to create withdrawal transactions", err)
+			return nil, confirmationError
+		}
+
+		withdrawData.ChangeNote.TxnID = crypto.GetTxID(txns[0])
+		if !withdrawData.NoChange {
+			noteId, err = h.store.RegisterUnconfirmedNote(withdrawData.ChangeNote)
+			if err != nil {
+				confirmationError = avm.InternalError(
+					"failed to save unconfirmed withdrawal: " + err.Error())
+				return nil, confirmationError
+			}
 		}
+
+		if onSubmit != nil {
+			onSubmit(withdrawData.ChangeNote.TxnID)
+		}
+		leafIndex, txnId, round, confirmationError =
+			h.avm.SendWithdrawalToNetworkWithTSS(txns)
+		if attempt > 1 || confirmationError == nil {
+			break
+		}
+		expired := confirmationError.Type == avm.ErrRootExpired
+		if confirmationError.Type == avm.ErrRejected {
+			// the root may have expired after the simulation, or the simulation could not
+			// tell the reason of the rejection
+			expired, err = h.avm.IsRootExpired(withdrawData.Root)
+			if err != nil {
+				log.Printf("Error checking withdrawal root: %v", err)
+				break
+			}
+		}
+		if !expired {
+			break
+		}
+		log.Printf("Withdrawal rejected with expired root, retrying with fresher root: %v",
+			confirmationError.Error())
+		if !withdrawData.NoChange {
+			h.store.DeleteUnconfirmedNote(noteId)
+			noteId = 0
+		}
+		withdrawData.Root = nil
+	}
+	if confirmationError != nil {
+		return nil, confirmationError
 	}
 
 	// Log successful withdrawal
@@ -142,28 +209,15 @@ func ConfirmWithdrawHandler(w http.ResponseWriter, r *http.Request) {
 	if txnId != withdrawData.ChangeNote.TxnID {
 		log.Printf("Withdrawal txnId mismatch: %v != %v", txnId, withdrawData.ChangeNote.TxnID)
 	}
+	if withdrawData.NoChange {
+		return models.NewWithdrawalReceipt(withdrawData, round, h.blockTime(round)), nil
+	}
 
-	successHtml := `
-		<dialog class="modal">
-		  <h1>&#9989; Withdrawal successful</h1>
-		  <p>
-			You can use your new secret note to withdraw any remaining balance in the future.
-		  </p>
-		  <button hx-get="withdraw" onclick="this.parentElement.close()">
-			Close
-		  </button>
-		</dialog>
-		<script>
-		  document.querySelectorAll('dialog')[0].showModal()
-		</script>
-	`
-
-	fmt.Fprint(w, successHtml)
-
-	saveNoteToDbError = db.SaveNote(withdrawData.ChangeNote)
+	saveNoteToDbError = h.store.SaveNote(withdrawData.ChangeNote)
 	if saveNoteToDbError != nil {
 		log.Printf("Error saving withdrawal to db: %v", saveNoteToDbError)
 	}
+	return models.NewWithdrawalReceipt(withdrawData, round, h.blockTime(round)), nil
 }
 
 func modalWithdrawalFailed(message string) string {
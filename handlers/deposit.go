@@ -1,19 +1,22 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 
 	"github.com/giuliop/HermesVault-frontend/avm"
 	"github.com/giuliop/HermesVault-frontend/config"
 	"github.com/giuliop/HermesVault-frontend/frontend/templates"
-	"github.com/giuliop/HermesVault-frontend/memstore"
 	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/zkp"
 
 	"github.com/algorand/go-algorand-sdk/v2/crypto"
 )
 
-func DepositHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) DepositHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		w.Header().Set("Cache-Control", config.CacheControl)
@@ -35,6 +38,7 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		amount, errAmount := models.Input(r.FormValue("amount")).ToAmount()
 		address, errAddress := models.Input(r.FormValue("address")).ToAddress()
+		depositFee, errFee := parseDepositFee(r.FormValue("depositFee"))
 		errorMsg := ""
 		if errAmount != nil {
 			log.Printf("Error parsing deposit amount: %v", errAmount)
@@ -44,46 +48,41 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error parsing deposit address: %v", errAddress)
 			errorMsg += "Invalid Algorand address<br>"
 		}
+		if errFee != nil {
+			log.Printf("Error parsing deposit fee: %v", errFee)
+			errorMsg += "Invalid deposit fee<br>"
+		}
 		if errorMsg != "" {
 			http.Error(w, errorMsg, http.StatusUnprocessableEntity)
 			return
 		}
 
-		note, err := models.GenerateNote(amount.Microalgos)
-		if err != nil {
-			log.Printf("Error generating new note: %v", err)
-			http.Error(w, "Something went wrong. Please try again",
-				http.StatusInternalServerError)
+		depositData, err := h.prepareDeposit(r.Context(), amount, address, depositFee)
+		if errors.Is(err, avm.ErrDepositFeeChanged) {
+			log.Printf("Error preparing deposit: %v", err)
+			http.Error(w, depositFeeChangedMessage, http.StatusConflict)
 			return
 		}
-
-		txns, err := avm.CreateDepositTxns(amount, address, note)
-		if err != nil {
-			log.Printf("Error creating deposit transactions: %v", err)
-			http.Error(w, "Something went wrong. Please try again",
-				http.StatusInternalServerError)
+		var busy *zkp.BusyError
+		if errors.As(err, &busy) {
+			log.Printf("Error preparing deposit: %v", err)
+			setRetryAfter(w, busy.RetrySeconds())
+			http.Error(w, serverBusyMessage(busy.RetrySeconds()),
+				http.StatusServiceUnavailable)
 			return
 		}
-		note.TxnID = crypto.GetTxID(txns[0])
-
-		depositData := models.DepositData{
-			Amount:         amount,
-			Address:        address,
-			Note:           note,
-			Txns:           txns,
-			IndexTxnToSign: config.UserDepositTxnIndex,
-		}
-
-		ms := memstore.UserSessions
-		_, err = ms.StoreDeposit(&depositData)
 		if err != nil {
-			log.Printf("Error storing deposit: %v", err)
+			log.Printf("Error preparing deposit: %v", err)
 			http.Error(w, "Something went wrong. Please try again",
 				http.StatusInternalServerError)
 			return
 		}
 
-		if err := templates.ConfirmDeposit.Execute(w, &depositData); err != nil {
+		data := struct {
+			*models.DepositData
+			AppId uint64
+		}{depositData, h.appId()}
+		if err := templates.ConfirmDeposit.Execute(w, data); err != nil {
 			log.Printf("Error executing success template: %v", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
@@ -92,3 +91,51 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+// depositFeeChangedMessage tells the user that the fee quoted with the max deposit is no
+// longer offered
+const depositFeeChangedMessage = "The deposit fee changed since you got the max " +
+	"deposit amount. Please get it again"
+
+// parseDepositFee parses the deposit fee quoted with the max deposit, 0 if not given
+func parseDepositFee(input string) (uint64, error) {
+	if input == "" {
+		return 0, nil
+	}
+	fee, err := models.Input(input).ToAmount()
+	return fee.Microalgos, err
+}
+
+// prepareDeposit generates a new note for the deposit, creates the deposit transactions
+// and stores them in the user sessions waiting for the user to sign.
+// depositFee is the fee quoted with the max deposit, 0 if none, to deposit the whole
+// balance net of it.
+// If the prover queue is full the error wraps a *zkp.BusyError, if the fee quoted is no
+// longer offered it is avm.ErrDepositFeeChanged
+func (h *Handlers) prepareDeposit(ctx context.Context, amount models.Amount,
+	address models.Address, depositFee uint64) (*models.DepositData, error) {
+	note, err := models.GenerateNote(amount.Microalgos)
+	if err != nil {
+		return nil, fmt.Errorf("error generating new note: %v", err)
+	}
+
+	txns, err := h.avm.CreateDepositTxns(ctx, amount, address, note, depositFee)
+	if err != nil {
+		return nil, fmt.Errorf("error creating deposit transactions: %w", err)
+	}
+	note.TxnID = crypto.GetTxID(txns[0])
+
+	depositData := &models.DepositData{
+		Amount:         amount,
+		Address:        address,
+		Note:           note,
+		Txns:           txns,
+		IndexTxnToSign: config.UserDepositTxnIndex,
+	}
+
+	_, err = h.store.StoreDeposit(depositData)
+	if err != nil {
+		return nil, fmt.Errorf("error storing deposit: %v", err)
+	}
+	return depositData, nil
+}
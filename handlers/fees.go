@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+type apiFeeTier struct {
+	From    apiAmount `json:"from"`
+	Percent string    `json:"percent"`
+}
+
+// apiFeePolicy is the API representation of config.Fees
+type apiFeePolicy struct {
+	Description string       `json:"description"`
+	Flat        apiAmount    `json:"flat"`
+	Tiers       []apiFeeTier `json:"tiers"` // percent of the part of the amount in each tier
+	Min         apiAmount    `json:"min"`
+	Max         *apiAmount   `json:"max"`       // null if there is no cap
+	Recipient   string       `json:"recipient"` // address receiving the fees
+}
+
+type apiFeeQuoteResponse struct {
+	Amount apiAmount    `json:"amount"`
+	Fee    apiAmount    `json:"fee"`
+	Total  apiAmount    `json:"total"` // amount plus fee, taken from the note
+	Policy apiFeePolicy `json:"policy"`
+}
+
+// newAPIFeePolicy returns the fee policy with the address receiving the fees, the TSS
+// unless another recipient is configured
+func (h *Handlers) newAPIFeePolicy() apiFeePolicy {
+	p := config.Fees
+	policy := apiFeePolicy{
+		Description: models.FeePolicyText(),
+		Flat:        newAPIAmount(models.NewAmount(p.Flat)),
+		Tiers:       []apiFeeTier{},
+		Min:         newAPIAmount(models.NewAmount(models.MinWithdrawalFee())),
+		Recipient:   h.avm.App.TSS.Address.String(),
+	}
+	for _, tier := range p.Tiers {
+		policy.Tiers = append(policy.Tiers, apiFeeTier{
+			From:    newAPIAmount(models.NewAmount(tier.From)),
+			Percent: models.FormatBps(tier.Bps),
+		})
+	}
+	if p.Max != 0 {
+		feeMax := newAPIAmount(models.NewAmount(p.Max))
+		policy.Max = &feeMax
+	}
+	if !p.Recipient.IsZero() {
+		policy.Recipient = p.Recipient.String()
+	}
+	return policy
+}
+
+// APIFeeQuoteHandler returns the fee of a withdrawal of the amount in the query, with the
+// fee policy
+func (h *Handlers) APIFeeQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowAPIGet(w, r) {
+		return
+	}
+	amount, err := models.Input(r.URL.Query().Get("amount")).ToAmount()
+	if err != nil {
+		writeAPIInputError(w, map[string]string{"amount": "Invalid algo amount"})
+		return
+	}
+	fee := amount.Fee()
+	writeJSON(w, http.StatusOK, apiFeeQuoteResponse{
+		Amount: newAPIAmount(amount),
+		Fee:    newAPIAmount(fee),
+		Total:  newAPIAmount(models.NewAmount(amount.Microalgos + fee.Microalgos)),
+		Policy: h.newAPIFeePolicy(),
+	})
+}
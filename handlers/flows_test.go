@@ -0,0 +1,1025 @@
+// Test the deposit and withdrawal flows of the JSON API offline, with a fake algod and an
+// in-memory store.
+// The circuits are compiled with a test only setup, which takes a while, so the flows are
+// skipped with -short
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/avm/fakealgod"
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/handlers"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/zkp"
+
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/algorand/go-algorand-sdk/v2/encoding/msgpack"
+	"github.com/algorand/go-algorand-sdk/v2/transaction"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+var appDir = flag.String("app", "../avm/mainnet", "the app setup directory")
+
+const algo = 1_000_000 // microalgos
+
+var (
+	fake    *fakealgod.Algod
+	store   *db.Memory
+	handler *handlers.Handlers
+	mux     *http.ServeMux
+	appId   uint64 // the vault app the notes are bound to
+)
+
+// scenario is a flow to test, returning an error if it does not behave as expected
+type scenario struct {
+	name string
+	run  func() error
+}
+
+// TestFlows runs the scenarios in order against the same fake algod and store
+func TestFlows(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiling the circuits takes a while")
+	}
+	log.Println("Compiling the circuits with a test only setup...")
+	app, err := avm.LoadTestApp(*appDir)
+	if err != nil {
+		t.Fatalf("Error loading app setup: %s", err)
+	}
+	appId = app.Id
+
+	store = db.NewMemory()
+	fake = fakealgod.New(app, store)
+	// the TSS pays the withdrawal fees before being refunded by the app
+	fake.Fund(app.TSS.Address, algo)
+	fake.Fund(crypto.GetApplicationAddress(app.Id), fakealgod.MinBalance)
+
+	client, err := avm.NewClient(fake, app, store, zkp.NewProver(1, 10))
+	if err != nil {
+		t.Fatalf("Error creating avm client: %s", err)
+	}
+	h := handlers.New(store, client)
+	handler = h
+	mux = http.NewServeMux()
+	api := handlers.APIPrefix
+	mux.HandleFunc(api+"deposits", h.APIDepositHandler)
+	mux.HandleFunc(api+"deposits/confirm", h.APIConfirmDepositHandler)
+	mux.HandleFunc(api+"max-deposit", h.APIMaxDepositHandler)
+	mux.HandleFunc(api+"withdrawals", h.APIWithdrawHandler)
+	mux.HandleFunc(api+"withdrawals/confirm", h.APIConfirmWithdrawHandler)
+	mux.HandleFunc(api+"jobs/{id}", h.APIJobHandler)
+	mux.HandleFunc(api+"jobs/{id}/receipt", h.APIReceiptHandler)
+	mux.HandleFunc(api+"notes/status", h.APINoteStatusHandler)
+	mux.HandleFunc(api+"fees/quote", h.APIFeeQuoteHandler)
+	mux.HandleFunc("/readyz", h.ReadyzHandler)
+	mux.HandleFunc("/confirm-withdraw-batch", h.ConfirmBatchWithdrawHandler)
+	mux.HandleFunc("/job", h.JobHandler)
+
+	scenarios := []scenario{
+		{"deposit and withdrawals", testDepositAndWithdrawals},
+		{"async withdrawal job and receipt", testAsyncWithdrawal},
+		{"batch withdrawal job", testBatchWithdrawal},
+		{"mistyped and legacy notes", testNoteEncoding},
+		{"note status", testNoteStatus},
+		{"tiered fees to a fee recipient", testFeePolicy},
+		{"group sized by simulation", testGroupSize},
+		{"whole balance deposit", testWholeBalanceDeposit},
+		{"readiness checks", testReadiness},
+		{"lagging txns database", testTxnsDbLag},
+		{"merkle tree diverged from the chain", testTreeDiverged},
+		{"double spend is rejected", testDoubleSpend},
+		{"expired root is proven again", testRootExpired},
+		{"logic eval rejection", testRejection},
+		{"opcode budget exhausted", testBudgetExhausted},
+		{"overspend", testOverSpend},
+		{"minimum balance requirement", testMinimumBalance},
+		{"expired deposit", testExpiry},
+		{"confirmation timeout", testTimeout},
+		{"interrupted jobs of this instance only", testInterruptedJobs},
+	}
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if err := s.run(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// testDepositAndWithdrawals deposits, withdraws part of the note and then the rest with
+// no change, checking the recipient balances
+func testDepositAndWithdrawals() error {
+	user := newFundedAccount(20 * algo)
+	note, err := deposit(user, "10")
+	if err != nil {
+		return err
+	}
+	recipient := crypto.GenerateAccount()
+	changeNote, err := withdraw(recipient.Address, "3", note)
+	if err != nil {
+		return err
+	}
+	if balance := fake.Balance(recipient.Address); balance != 3*algo {
+		return fmt.Errorf("recipient balance %d, expected %d", balance, 3*algo)
+	}
+	withdrawal, err := models.NewNoChangeWithdrawal(models.Address(
+		recipient.Address.String()), mustNote(changeNote))
+	if err != nil {
+		return err
+	}
+	if _, err := withdrawNoChange(recipient.Address, changeNote); err != nil {
+		return err
+	}
+	expected := 3*algo + withdrawal.Amount.Microalgos
+	if balance := fake.Balance(recipient.Address); balance != expected {
+		return fmt.Errorf("recipient balance %d, expected %d", balance, expected)
+	}
+	return nil
+}
+
+// testAsyncWithdrawal withdraws in the background, following the job until confirmed,
+// and then gets its receipt, without and with the change note
+func testAsyncWithdrawal() error {
+	start := time.Now().Truncate(time.Second)
+	user := newFundedAccount(10 * algo)
+	note, err := deposit(user, "5")
+	if err != nil {
+		return err
+	}
+	recipient := crypto.GenerateAccount()
+	var w struct {
+		ChangeNote string `json:"changeNote"`
+	}
+	err = post("withdrawals", map[string]any{
+		"amount":  "2",
+		"address": recipient.Address.String(),
+		"note":    note,
+	}, &w)
+	if err != nil {
+		return fmt.Errorf("error creating withdrawal: %w", err)
+	}
+	var job jobData
+	err = post("withdrawals/confirm", map[string]any{
+		"amount":     "2",
+		"address":    recipient.Address.String(),
+		"fromNote":   note,
+		"changeNote": w.ChangeNote,
+		"async":      true,
+	}, &job)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(5 * time.Minute)
+	for job.State != "confirmed" && job.State != "failed" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("job %s still %s", job.Id, job.State)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if err := get("jobs/"+job.Id, &job); err != nil {
+			return err
+		}
+	}
+	if job.State != "confirmed" || job.LeafIndex == nil || job.TxnId == "" {
+		return fmt.Errorf("job not confirmed: %+v", job)
+	}
+	if balance := fake.Balance(recipient.Address); balance != 2*algo {
+		return fmt.Errorf("recipient balance %d, expected %d", balance, 2*algo)
+	}
+
+	var receipt struct {
+		Kind   string `json:"kind"`
+		TxnId  string `json:"txnId"`
+		Round  uint64 `json:"round"`
+		Amount struct {
+			Microalgos uint64 `json:"microalgos"`
+		} `json:"amount"`
+		ConfirmedAt   *time.Time `json:"confirmedAt"`
+		TxnFees       *struct{}  `json:"txnFees"`
+		WithdrawalFee struct {
+			Microalgos uint64 `json:"microalgos"`
+		} `json:"withdrawalFee"`
+		Address   string  `json:"address"`
+		LeafIndex *uint64 `json:"leafIndex"`
+		Note      string  `json:"note"`
+	}
+	if err := get("jobs/"+job.Id+"/receipt", &receipt); err != nil {
+		return err
+	}
+	if receipt.ConfirmedAt == nil || receipt.ConfirmedAt.Before(start) ||
+		receipt.TxnFees != nil ||
+		receipt.WithdrawalFee.Microalgos != models.CalculateWithdrawalFee(2*algo) {
+		return fmt.Errorf("unexpected receipt time or fees: %+v", receipt)
+	}
+	if receipt.Kind != "withdrawal" || receipt.TxnId != job.TxnId || receipt.Round == 0 ||
+		receipt.Amount.Microalgos != 2*algo || receipt.Address != recipient.Address.String() ||
+		receipt.LeafIndex == nil || *receipt.LeafIndex != *job.LeafIndex ||
+		receipt.Note != "" {
+		return fmt.Errorf("unexpected receipt: %+v", receipt)
+	}
+	err = post("jobs/"+job.Id+"/receipt", map[string]any{"note": note}, &receipt)
+	if err := expectAPIError(err, "invalid_input"); err != nil {
+		return fmt.Errorf("receipt with the spent note: %w", err)
+	}
+	err = post("jobs/"+job.Id+"/receipt", map[string]any{"note": w.ChangeNote}, &receipt)
+	if err != nil {
+		return err
+	}
+	if receipt.Note != w.ChangeNote {
+		return fmt.Errorf("receipt note %q, expected the change note", receipt.Note)
+	}
+	return nil
+}
+
+// testNoteStatus checks the status of a note unknown, then deposited and then spent
+func testNoteStatus() error {
+	user := newFundedAccount(10 * algo)
+	d, err := prepareDeposit(user, "5")
+	if err != nil {
+		return err
+	}
+	if err := expectNoteState(d.Note, "unknown"); err != nil {
+		return err
+	}
+	note, err := confirmDeposit(user, d)
+	if err != nil {
+		return err
+	}
+	if err := expectNoteState(note, "in_tree"); err != nil {
+		return err
+	}
+	if _, err := withdrawNoChange(user.Address, note); err != nil {
+		return err
+	}
+	return expectNoteState(note, "spent")
+}
+
+// expectNoteState returns nil if the note status has the expected state
+func expectNoteState(note string, state string) error {
+	var status struct {
+		State     string  `json:"state"`
+		LeafIndex *uint64 `json:"leafIndex"`
+	}
+	if err := post("notes/status", map[string]any{"note": note}, &status); err != nil {
+		return err
+	}
+	if status.State != state {
+		return fmt.Errorf("note state %q, expected %q", status.State, state)
+	}
+	if inTree := state == "in_tree" || state == "spent"; inTree != (status.LeafIndex != nil) {
+		return fmt.Errorf("note in state %q with leaf index %v", state, status.LeafIndex)
+	}
+	return nil
+}
+
+// testFeePolicy withdraws with a tiered fee policy paying a distinct fee recipient,
+// checking the quoted fee is the one received
+func testFeePolicy() error {
+	feeRecipient := crypto.GenerateAccount()
+	defer func(fees config.FeePolicy) { config.Fees = fees }(config.Fees)
+	config.Fees = config.FeePolicy{
+		Tiers:     []config.FeeTier{{From: 0, Bps: 100}, {From: 5 * algo, Bps: 50}},
+		Max:       algo,
+		Recipient: feeRecipient.Address,
+	}
+
+	// 1% of the first 5 algo and 0.5% of the other 15
+	expectedFee := uint64(50_000 + 75_000)
+	var quote struct {
+		Fee struct {
+			Microalgos uint64 `json:"microalgos"`
+		} `json:"fee"`
+		Policy struct {
+			Recipient string `json:"recipient"`
+		} `json:"policy"`
+	}
+	if err := get("fees/quote?amount=20", &quote); err != nil {
+		return err
+	}
+	if quote.Fee.Microalgos != expectedFee ||
+		quote.Policy.Recipient != feeRecipient.Address.String() {
+		return fmt.Errorf("unexpected quote: %+v", quote)
+	}
+
+	user := newFundedAccount(40 * algo)
+	note, err := deposit(user, "30")
+	if err != nil {
+		return err
+	}
+	recipient := crypto.GenerateAccount()
+	if _, err := withdraw(recipient.Address, "20", note); err != nil {
+		return err
+	}
+	if balance := fake.Balance(feeRecipient.Address); balance != expectedFee {
+		return fmt.Errorf("fee recipient balance %d, expected %d", balance, expectedFee)
+	}
+	return nil
+}
+
+// testGroupSize checks that the deposit group is padded and its fee set from simulating it,
+// and with the config defaults when the simulation is not available
+func testGroupSize() error {
+	user := newFundedAccount(10 * algo)
+	defaultFee := uint64(config.DepositMinFeeMultiplier * transaction.MinTxnFee)
+	d, err := prepareDeposit(user, "5")
+	if err != nil {
+		return err
+	}
+	txn, err := d.userTxn()
+	if err != nil {
+		return err
+	}
+	if len(d.Txns) >= config.VerifierTopLevelTxnNeeded || uint64(txn.Fee) >= defaultFee {
+		return fmt.Errorf("expected less than %d txns and a fee below %d, got %d txns "+
+			"and fee %d", config.VerifierTopLevelTxnNeeded, defaultFee, len(d.Txns), txn.Fee)
+	}
+	if _, err := confirmDeposit(user, d); err != nil {
+		return err
+	}
+
+	fake.DisableSimulation(true)
+	defer fake.DisableSimulation(false)
+	if d, err = prepareDeposit(user, "1"); err != nil {
+		return err
+	}
+	if txn, err = d.userTxn(); err != nil {
+		return err
+	}
+	if len(d.Txns) != config.VerifierTopLevelTxnNeeded || uint64(txn.Fee) != defaultFee {
+		return fmt.Errorf("expected the default %d txns and fee %d, got %d txns and fee %d",
+			config.VerifierTopLevelTxnNeeded, defaultFee, len(d.Txns), txn.Fee)
+	}
+	_, err = confirmDeposit(user, d)
+	return err
+}
+
+// testWholeBalanceDeposit deposits the max deposit amount with the fee quoted, checking
+// that the account is closed into the contract paying the simulated fee, which goes into
+// no one's note, and that a whole balance deposit net of a fee not offered is refused
+func testWholeBalanceDeposit() error {
+	user := newFundedAccount(10 * algo)
+	// a first deposit sizes the deposit group
+	if _, err := deposit(user, "1"); err != nil {
+		return err
+	}
+	type amount struct {
+		Microalgos uint64 `json:"microalgos"`
+		Algo       string `json:"algo"`
+	}
+	var resp struct {
+		MaxDeposit amount `json:"maxDeposit"`
+		DepositFee amount `json:"depositFee"`
+	}
+	if err := get("max-deposit?address="+user.Address.String(), &resp); err != nil {
+		return err
+	}
+	balance := fake.Balance(user.Address)
+	if resp.DepositFee.Microalgos+resp.MaxDeposit.Microalgos != balance {
+		return fmt.Errorf("max deposit %d and fee %d, expected the balance %d",
+			resp.MaxDeposit.Microalgos, resp.DepositFee.Microalgos, balance)
+	}
+
+	staleFee := models.NewAmount(resp.DepositFee.Microalgos + 1)
+	err := post("deposits", map[string]string{
+		"amount":     models.MicroAlgosToAlgoString(balance - staleFee.Microalgos),
+		"address":    user.Address.String(),
+		"depositFee": staleFee.Algostring,
+	}, &depositData{})
+	if err := expectAPIError(err, "deposit_fee_changed"); err != nil {
+		return err
+	}
+
+	var d depositData
+	err = post("deposits", map[string]string{
+		"amount":     resp.MaxDeposit.Algo,
+		"address":    user.Address.String(),
+		"depositFee": resp.DepositFee.Algo,
+	}, &d)
+	if err != nil {
+		return err
+	}
+	d.Amount = resp.MaxDeposit.Algo
+	txn, err := d.userTxn()
+	if err != nil {
+		return err
+	}
+	defaultFee := uint64(config.DepositMinFeeMultiplier * transaction.MinTxnFee)
+	if txn.CloseRemainderTo.IsZero() || uint64(txn.Fee) >= defaultFee ||
+		uint64(txn.Fee)+resp.MaxDeposit.Microalgos != balance {
+		return fmt.Errorf("expected a close out paying a fee below %d of the balance %d "+
+			"net of the max deposit %d, got close to %s and fee %d", defaultFee, balance,
+			resp.MaxDeposit.Microalgos, txn.CloseRemainderTo, txn.Fee)
+	}
+	if _, err := confirmDeposit(user, &d); err != nil {
+		return err
+	}
+	if balance := fake.Balance(user.Address); balance != 0 {
+		return fmt.Errorf("expected the account closed, got balance %d", balance)
+	}
+	return nil
+}
+
+// testReadiness checks that the server is ready, and not ready while the subscriber lags
+// behind algod
+func testReadiness() error {
+	ready := func() (int, string, error) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp struct {
+			Status string `json:"status"`
+			Checks map[string]struct {
+				OK    bool   `json:"ok"`
+				Error string `json:"error"`
+				AtTip *bool  `json:"atTip"`
+			} `json:"checks"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			return 0, "", err
+		}
+		if atTip := resp.Checks["merkleTree"].AtTip; atTip != nil && !*atTip {
+			return 0, "", fmt.Errorf("the merkle tree is not at the tip of the chain")
+		}
+		var failed string
+		for name, check := range resp.Checks {
+			if !check.OK {
+				failed += name + ": " + check.Error + "; "
+			}
+		}
+		return w.Code, failed, nil
+	}
+
+	code, failed, err := ready()
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("expected ready, got status %d: %s", code, failed)
+	}
+
+	status, _ := fake.Status(context.Background())
+	store.SetWatermark(status.LastRound - config.MaxSubscriberLag - 1)
+	code, failed, err = ready()
+	store.SetWatermark(status.LastRound)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusServiceUnavailable || !strings.HasPrefix(failed, "subscriber") {
+		return fmt.Errorf("expected the subscriber check to fail, got status %d: %s",
+			code, failed)
+	}
+	return nil
+}
+
+// testTxnsDbLag withdraws a note not yet in the txns database, which is refused as
+// lagging while the subscriber is behind algod and as not found once it catches up
+func testTxnsDbLag() error {
+	user := newFundedAccount(10 * algo)
+	d, err := prepareDeposit(user, "5")
+	if err != nil {
+		return err
+	}
+	status, _ := fake.Status(context.Background())
+	store.SetWatermark(status.LastRound - config.MaxSubscriberLag - 1)
+	_, errWithdraw := withdraw(user.Address, "1", d.Note)
+	errConfirm := post("withdrawals/confirm", map[string]any{
+		"address":  user.Address.String(),
+		"fromNote": d.Note,
+		"noChange": true,
+	}, &struct{}{})
+	store.SetWatermark(status.LastRound)
+	if err := expectAPIError(errWithdraw, "txns_db_lagging"); err != nil {
+		return err
+	}
+	if err := expectAPIError(errConfirm, "txns_db_lagging"); err != nil {
+		return err
+	}
+	_, err = withdraw(user.Address, "1", d.Note)
+	return expectAPIError(err, "note_not_found")
+}
+
+// testTreeDiverged withdraws while the app roots onchain miss the local root, which is
+// refused, and then once they match again
+func testTreeDiverged() error {
+	user := newFundedAccount(10 * algo)
+	note, err := deposit(user, "5")
+	if err != nil {
+		return err
+	}
+	fake.HideRootsNext()
+	_, err = withdraw(user.Address, "1", note)
+	if err := expectAPIError(err, "tree_diverged"); err != nil {
+		return err
+	}
+	_, err = withdraw(user.Address, "1", note)
+	return err
+}
+
+// testDoubleSpend withdraws twice from the same note, the second time refused before
+// proving since the nullifier box exists
+func testDoubleSpend() error {
+	user := newFundedAccount(10 * algo)
+	note, err := deposit(user, "5")
+	if err != nil {
+		return err
+	}
+	if _, err := withdraw(user.Address, "1", note); err != nil {
+		return err
+	}
+	_, err = withdraw(user.Address, "1", note)
+	return expectAPIError(err, "note_spent")
+}
+
+// testRootExpired has the root of a withdrawal expire once proven, as if other frontends
+// had inserted many leaves meanwhile, so that it must be proven again against a fresher
+// root
+func testRootExpired() error {
+	user := newFundedAccount(10 * algo)
+	note, err := deposit(user, "5")
+	if err != nil {
+		return err
+	}
+	fake.ExpireRootsNext()
+	_, err = withdraw(user.Address, "1", note)
+	return err
+}
+
+// testNoteEncoding withdraws with a mistyped note, which must be refused reporting the
+// mistyped character, and then with the same note in the legacy hex encoding
+func testNoteEncoding() error {
+	user := newFundedAccount(10 * algo)
+	note, err := deposit(user, "5")
+	if err != nil {
+		return err
+	}
+	position := 42
+	mistyped := []byte(note)
+	if mistyped[position-1] == 'q' {
+		mistyped[position-1] = 'p'
+	} else {
+		mistyped[position-1] = 'q'
+	}
+	_, err = withdraw(user.Address, "1", string(mistyped))
+	var e *apiError
+	if !errors.As(err, &e) || e.Code != "invalid_input" {
+		return fmt.Errorf("expected invalid_input error, got %v", err)
+	}
+	expected := fmt.Sprintf("character %d is probably mistyped", position)
+	if msg := e.Fields["note"]; !strings.Contains(msg, expected) {
+		return fmt.Errorf("expected note error reporting %q, got %q", expected, msg)
+	}
+
+	n := mustNote(note)
+	_, err = withdraw(user.Address, "1", n.Text(appId+1))
+	if err := expectAPIError(err, "invalid_input"); err != nil {
+		return fmt.Errorf("note of another app: %w", err)
+	}
+	legacy := fmt.Sprintf("%016x%x%x", n.Amount, n.K, n.R)
+	_, err = withdraw(user.Address, "1", legacy)
+	return err
+}
+
+// testRejection has the deposit rejected by the app
+func testRejection() error {
+	user := newFundedAccount(10 * algo)
+	d, err := prepareDeposit(user, "5")
+	if err != nil {
+		return err
+	}
+	fake.RejectNext("assert failed pc=42")
+	_, err = confirmDeposit(user, d)
+	return expectAPIError(err, "txn_rejected")
+}
+
+// testBudgetExhausted has the deposit run out of opcode budget in its simulation
+func testBudgetExhausted() error {
+	user := newFundedAccount(10 * algo)
+	d, err := prepareDeposit(user, "5")
+	if err != nil {
+		return err
+	}
+	fake.RejectNext("dynamic cost budget exceeded, executing pushint: " +
+		"local program cost was 700")
+	_, err = confirmDeposit(user, d)
+	return expectAPIError(err, "txn_budget_exhausted")
+}
+
+// testOverSpend confirms two deposits prepared when the balance covered each of them,
+// but not both
+func testOverSpend() error {
+	user := newFundedAccount(10 * algo)
+	first, err := prepareDeposit(user, "6")
+	if err != nil {
+		return err
+	}
+	second, err := prepareDeposit(user, "6")
+	if err != nil {
+		return err
+	}
+	if _, err := confirmDeposit(user, first); err != nil {
+		return err
+	}
+	_, err = confirmDeposit(user, second)
+	return expectAPIError(err, "txn_overspend")
+}
+
+// testMinimumBalance deposits leaving less than the minimum balance in the account
+func testMinimumBalance() error {
+	fee := uint64(config.DepositMinFeeMultiplier * transaction.MinTxnFee)
+	user := newFundedAccount(10*algo + fee + fakealgod.MinBalance/2)
+	_, err := deposit(user, "10")
+	return expectAPIError(err, "txn_minimum_balance_requirement")
+}
+
+// testExpiry confirms a deposit after its last valid round
+func testExpiry() error {
+	user := newFundedAccount(10 * algo)
+	d, err := prepareDeposit(user, "5")
+	if err != nil {
+		return err
+	}
+	fake.AdvanceRounds(config.WaitRounds + 1)
+	_, err = confirmDeposit(user, d)
+	return expectAPIError(err, "txn_expired")
+}
+
+// testTimeout has the deposit never confirmed, leaving its note pending.
+// It must run last: the fake inserts the note in its tree but never confirms it, so the
+// leaf is missing from the store
+func testTimeout() error {
+	user := newFundedAccount(10 * algo)
+	d, err := prepareDeposit(user, "5")
+	if err != nil {
+		return err
+	}
+	fake.DropNext()
+	_, err = confirmDeposit(user, d)
+	if err := expectAPIError(err, "txn_timeout"); err != nil {
+		return err
+	}
+	return expectNoteState(d.Note, "pending")
+}
+
+// testBatchWithdrawal pays several recipients from one note in a background job, then
+// has a batch from a spent note fail at its first withdrawal, which leaves no notes with
+// the job since the user already has the note the batch started from
+func testBatchWithdrawal() error {
+	user := newFundedAccount(20 * algo)
+	note, err := deposit(user, "10")
+	if err != nil {
+		return err
+	}
+	recipients := []crypto.Account{crypto.GenerateAccount(), crypto.GenerateAccount(),
+		crypto.GenerateAccount()}
+	var lines []string
+	for i, r := range recipients {
+		lines = append(lines, fmt.Sprintf("%s %d", r.Address, i+1))
+	}
+	job, err := confirmBatchWithdrawal(strings.Join(lines, "\n"), note)
+	if err != nil {
+		return err
+	}
+	if job.State != "confirmed" {
+		return fmt.Errorf("batch job not confirmed: %+v", job)
+	}
+	for i, r := range recipients {
+		if balance := fake.Balance(r.Address); balance != uint64(i+1)*algo {
+			return fmt.Errorf("recipient %d balance %d, expected %d", i, balance,
+				uint64(i+1)*algo)
+		}
+	}
+	if notes, err := store.GetJobNotes(job.Id); err != nil || len(notes) != 0 {
+		return fmt.Errorf("confirmed batch job notes %v, error %v", notes, err)
+	}
+	if len(job.Receipts) != len(recipients) {
+		return fmt.Errorf("confirmed batch job receipts %+v, expected one per recipient",
+			job.Receipts)
+	}
+	for i, r := range recipients {
+		receipt := job.Receipts[i]
+		if receipt.Address != r.Address.String() ||
+			receipt.Amount.Microalgos != uint64(i+1)*algo {
+			return fmt.Errorf("batch receipt %d %+v, expected %d algo to %s", i, receipt,
+				i+1, r.Address)
+		}
+	}
+	if job.Receipts[2].TxnId != job.TxnId {
+		return fmt.Errorf("last batch receipt txn %s, expected the job txn %s",
+			job.Receipts[2].TxnId, job.TxnId)
+	}
+	var receipt struct {
+		TxnId string `json:"txnId"`
+	}
+	if err := get("jobs/"+job.Id+"/receipt?step=1", &receipt); err != nil {
+		return err
+	}
+	if receipt.TxnId != job.Receipts[1].TxnId {
+		return fmt.Errorf("batch receipt of step 1 %+v, expected %+v", receipt,
+			job.Receipts[1])
+	}
+	err = get("jobs/"+job.Id+"/receipt?step=3", &receipt)
+	if err := expectAPIError(err, "job_not_found"); err != nil {
+		return err
+	}
+
+	note, err = deposit(user, "5")
+	if err != nil {
+		return err
+	}
+	if _, err := withdraw(user.Address, "1", note); err != nil {
+		return err
+	}
+	recipient := crypto.GenerateAccount()
+	job, err = confirmBatchWithdrawal(fmt.Sprintf("%s 1\n%s 1", recipient.Address,
+		recipient.Address), note)
+	if err != nil {
+		return err
+	}
+	if job.State != "failed" || job.Error == nil || job.Error.Code != "note_spent" {
+		return fmt.Errorf("batch job from a spent note not failed: %+v", job)
+	}
+	if notes, err := store.GetJobNotes(job.Id); err != nil || len(notes) != 0 {
+		return fmt.Errorf("failed batch job notes %v, error %v", notes, err)
+	}
+	return nil
+}
+
+// confirmBatchWithdrawal submits the batch withdrawal form paying recipients from note
+// and waits for the job started to be done
+func confirmBatchWithdrawal(recipients string, note string) (*jobData, error) {
+	recipientList, err := models.Input(recipients).ToRecipients()
+	if err != nil {
+		return nil, err
+	}
+	batch, err := models.NewBatchWithdrawal(mustNote(note), recipientList)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{"recipients": {recipients}, "fromNote": {note}}
+	for _, w := range batch.Withdrawals {
+		form.Add("changeNotes", w.ChangeNote.Text(appId))
+	}
+	form.Set("changeNote", form["changeNotes"][len(batch.Withdrawals)-1])
+	r := httptest.NewRequest(http.MethodPost, "/confirm-withdraw-batch",
+		strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	jobUrl, found := strings.CutPrefix(w.Header().Get("HX-Push-Url"), "/job?id=")
+	if w.Code != http.StatusOK || !found {
+		return nil, fmt.Errorf("status %d: %s", w.Code, w.Body.String())
+	}
+	job := &jobData{Id: jobUrl}
+	deadline := time.Now().Add(5 * time.Minute)
+	for job.State != "confirmed" && job.State != "failed" {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("job %s still %s", job.Id, job.State)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if err := get("jobs/"+job.Id, job); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// testInterruptedJobs checks that at startup only the unfinished jobs of this instance are
+// failed, not those of the other instances sharing the store
+func testInterruptedJobs() error {
+	own, err := models.NewJob(models.WithdrawalJob, config.InstanceId)
+	if err != nil {
+		return err
+	}
+	other, err := models.NewJob(models.WithdrawalJob, "other-instance")
+	if err != nil {
+		return err
+	}
+	for _, job := range []*models.Job{own, other} {
+		if err := store.SaveJob(job); err != nil {
+			return err
+		}
+	}
+	handler.FailInterruptedJobs()
+	var ownJob, otherJob jobData
+	if err := get("jobs/"+own.Id, &ownJob); err != nil {
+		return err
+	}
+	if err := get("jobs/"+other.Id, &otherJob); err != nil {
+		return err
+	}
+	if ownJob.State != "failed" || otherJob.State != "queued" {
+		return fmt.Errorf("own job %s and other instance job %s, expected failed and queued",
+			ownJob.State, otherJob.State)
+	}
+	return nil
+}
+
+// apiError is an error response of the API
+type apiError struct {
+	Status int
+	Code   string            `json:"code"`
+	Msg    string            `json:"message"`
+	Fields map[string]string `json:"fields"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Msg)
+}
+
+// expectAPIError returns nil if err is an API error with code
+func expectAPIError(err error, code string) error {
+	if err == nil {
+		return fmt.Errorf("expected %s error, got success", code)
+	}
+	var e *apiError
+	if errors.As(err, &e) && e.Code == code {
+		return nil
+	}
+	return fmt.Errorf("expected %s error, got %v", code, err)
+}
+
+// jobData is a job returned by the API
+type jobData struct {
+	Id        string    `json:"id"`
+	State     string    `json:"state"`
+	TxnId     string    `json:"txnId"`
+	LeafIndex *uint64   `json:"leafIndex"`
+	Error     *apiError `json:"error"`
+	Receipts  []struct {
+		TxnId   string `json:"txnId"`
+		Address string `json:"address"`
+		Amount  struct {
+			Microalgos uint64 `json:"microalgos"`
+		} `json:"amount"`
+	} `json:"receipts"`
+}
+
+// post sends req to the API endpoint and decodes the response into resp
+func post(endpoint string, req any, resp any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r := httptest.NewRequest(http.MethodPost, handlers.APIPrefix+endpoint,
+		bytes.NewReader(body))
+	return serve(r, resp)
+}
+
+// get sends a GET request to the API endpoint and decodes the response into resp
+func get(endpoint string, resp any) error {
+	return serve(httptest.NewRequest(http.MethodGet, handlers.APIPrefix+endpoint, nil), resp)
+}
+
+// serve serves the API request r and decodes the response into resp
+func serve(r *http.Request, resp any) error {
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK && w.Code != http.StatusAccepted {
+		var errResp struct {
+			Error apiError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil {
+			return fmt.Errorf("status %d: %s", w.Code, w.Body.String())
+		}
+		errResp.Error.Status = w.Code
+		return &errResp.Error
+	}
+	return json.Unmarshal(w.Body.Bytes(), resp)
+}
+
+// newFundedAccount returns a new account funded with amount microalgos
+func newFundedAccount(amount uint64) crypto.Account {
+	account := crypto.GenerateAccount()
+	fake.Fund(account.Address, amount)
+	return account
+}
+
+// depositData is a deposit created by the API, to be confirmed
+type depositData struct {
+	Amount         string   `json:"-"`
+	Note           string   `json:"note"`
+	Txns           []string `json:"txns"`
+	IndexTxnToSign int      `json:"indexTxnToSign"`
+}
+
+// deposit makes a deposit of amount algo from user, returning the note
+func deposit(user crypto.Account, amount string) (string, error) {
+	d, err := prepareDeposit(user, amount)
+	if err != nil {
+		return "", err
+	}
+	return confirmDeposit(user, d)
+}
+
+func prepareDeposit(user crypto.Account, amount string) (*depositData, error) {
+	var d depositData
+	err := post("deposits", map[string]string{
+		"amount":  amount,
+		"address": user.Address.String(),
+	}, &d)
+	if err != nil {
+		return nil, fmt.Errorf("error creating deposit: %w", err)
+	}
+	d.Amount = amount
+	return &d, nil
+}
+
+// userTxn returns the txn of the deposit to be signed by the user
+func (d *depositData) userTxn() (types.Transaction, error) {
+	var txn types.Transaction
+	txnBytes, err := base64.StdEncoding.DecodeString(d.Txns[d.IndexTxnToSign])
+	if err != nil {
+		return txn, err
+	}
+	err = msgpack.Decode(txnBytes, &txn)
+	return txn, err
+}
+
+// confirmDeposit signs the user txn of the deposit and confirms it, returning the note
+func confirmDeposit(user crypto.Account, d *depositData) (string, error) {
+	txn, err := d.userTxn()
+	if err != nil {
+		return "", err
+	}
+	_, signedTxn, err := crypto.SignTransaction(user.PrivateKey, txn)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		LeafIndex uint64 `json:"leafIndex"`
+	}
+	err = post("deposits/confirm", map[string]string{
+		"amount":    d.Amount,
+		"address":   user.Address.String(),
+		"note":      d.Note,
+		"signedTxn": base64.StdEncoding.EncodeToString(signedTxn),
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	return d.Note, nil
+}
+
+// withdraw withdraws amount algo from note to recipient, returning the change note
+func withdraw(recipient types.Address, amount string, note string) (string, error) {
+	var w struct {
+		ChangeNote string `json:"changeNote"`
+	}
+	err := post("withdrawals", map[string]any{
+		"amount":  amount,
+		"address": recipient.String(),
+		"note":    note,
+	}, &w)
+	if err != nil {
+		return "", fmt.Errorf("error creating withdrawal: %w", err)
+	}
+	var resp struct {
+		LeafIndex *uint64 `json:"leafIndex"`
+	}
+	err = post("withdrawals/confirm", map[string]any{
+		"amount":     amount,
+		"address":    recipient.String(),
+		"fromNote":   note,
+		"changeNote": w.ChangeNote,
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	return w.ChangeNote, nil
+}
+
+// withdrawNoChange withdraws the whole note to recipient, returning the txn id
+func withdrawNoChange(recipient types.Address, note string) (string, error) {
+	var resp struct {
+		TxnId string `json:"txnId"`
+	}
+	err := post("withdrawals/confirm", map[string]any{
+		"address":  recipient.String(),
+		"fromNote": note,
+		"noChange": true,
+	}, &resp)
+	return resp.TxnId, err
+}
+
+// mustNote parses a note text
+func mustNote(text string) *models.Note {
+	note, err := models.Input(text).ToNote(appId)
+	if err != nil {
+		log.Fatalf("Error parsing note: %s", err)
+	}
+	return note
+}
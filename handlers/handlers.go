@@ -2,13 +2,70 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/giuliop/HermesVault-frontend/avm"
 	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/db"
 	"github.com/giuliop/HermesVault-frontend/frontend/templates"
 )
 
+// Handlers serves the web pages and the JSON API using the given store and avm client
+type Handlers struct {
+	store db.Store
+	avm   *avm.Client
+}
+
+// New returns the handlers using store for the notes and the txns database and
+// avmClient to interact with the app onchain
+func New(store db.Store, avmClient *avm.Client) *Handlers {
+	return &Handlers{store: store, avm: avmClient}
+}
+
+// appId returns the id of the vault app, which the secret notes are bound to
+func (h *Handlers) appId() uint64 {
+	return h.avm.App.Id
+}
+
+// blockTimeTimeout is how long to wait for algod to return the timestamp of a block
+const blockTimeTimeout = 5 * time.Second
+
+// blockTime returns the timestamp of the block of round, or the zero time if algod does
+// not return it, which the receipts show as unknown
+func (h *Handlers) blockTime(round uint64) time.Time {
+	ctx, cancel := context.WithTimeout(context.Background(), blockTimeTimeout)
+	defer cancel()
+	confirmedAt, err := h.avm.BlockTime(ctx, round)
+	if err != nil {
+		log.Printf("Error getting the block time of round %d: %v", round, err)
+	}
+	return confirmedAt
+}
+
+// txnOutcome returns the metrics outcome of a deposit or withdrawal sent to the network:
+// "success" if err is nil, otherwise the type of the error
+func txnOutcome(err *avm.TxnConfirmationError) string {
+	if err == nil {
+		return "success"
+	}
+	return err.Type.String()
+}
+
+// serverBusyMessage is the message shown when the prover queue is full
+func serverBusyMessage(retryAfter int) string {
+	return fmt.Sprintf("The server is busy, please try again in %d seconds", retryAfter)
+}
+
+// setRetryAfter sets the Retry-After header to the given seconds
+func setRetryAfter(w http.ResponseWriter, seconds int) {
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
 // IsHtmxRequest checks if the request is coming from HTMX via AJAX
 func IsHtmxRequest(r *http.Request) bool {
 	return r.Header.Get("HX-Request") == "true"
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+)
+
+// HealthzHandler reports that the process is alive, without checking its dependencies
+func (h *Handlers) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowAPIGet(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyCheck is the outcome of a readiness check, with details on what was checked
+type readyCheck struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// algod
+	LastRound             *uint64  `json:"lastRound,omitempty"`
+	CatchingUp            *bool    `json:"catchingUp,omitempty"`
+	SecondsSinceLastRound *float64 `json:"secondsSinceLastRound,omitempty"`
+	// subscriber
+	Watermark *uint64 `json:"watermark,omitempty"`
+	Lag       *uint64 `json:"lag,omitempty"`
+	// merkle tree
+	LeafCount *uint64 `json:"leafCount,omitempty"`
+	AtTip     *bool   `json:"atTip,omitempty"`
+}
+
+// fail marks the check as failed with the error message
+func (c *readyCheck) fail(format string, args ...any) {
+	c.OK = false
+	c.Error = fmt.Sprintf(format, args...)
+}
+
+type readyResponse struct {
+	Status string                 `json:"status"` // ready or not_ready
+	Checks map[string]*readyCheck `json:"checks"`
+}
+
+// ReadyzHandler reports whether the server can serve deposits and withdrawals, checking
+// that algod is reachable and in sync, the databases can be queried, the subscriber is
+// not lagging behind algod and the merkle tree matches the root in the txns database and
+// the app roots onchain.
+// It responds with status 503 if any check fails, with the outcome of each check
+func (h *Handlers) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowAPIGet(w, r) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), config.ReadyCheckTimeout)
+	defer cancel()
+
+	algod := &readyCheck{OK: true}
+	status, err := h.avm.AlgodClient().Status(ctx)
+	if err != nil {
+		algod.fail("algod unreachable: %v", err)
+	} else {
+		catchingUp := status.CatchupTime > 0
+		sinceLastRound := time.Duration(status.TimeSinceLastRound)
+		seconds := sinceLastRound.Seconds()
+		algod.LastRound, algod.CatchingUp = &status.LastRound, &catchingUp
+		algod.SecondsSinceLastRound = &seconds
+		switch {
+		case catchingUp:
+			algod.fail("algod is catching up")
+		case sinceLastRound > config.MaxTimeSinceLastRound:
+			algod.fail("algod has not seen a new round for %v",
+				sinceLastRound.Round(time.Second))
+		}
+	}
+
+	internalDb := &readyCheck{OK: true}
+	if err := h.store.PingInternalDb(ctx); err != nil {
+		internalDb.fail("%v", err)
+	}
+	txnsDb := &readyCheck{OK: true}
+	if err := h.store.PingTxnsDb(ctx); err != nil {
+		txnsDb.fail("%v", err)
+	}
+
+	subscriber := &readyCheck{OK: true}
+	watermark, err := h.store.GetWatermark(ctx)
+	switch {
+	case err != nil:
+		subscriber.fail("%v", err)
+	case algod.LastRound == nil:
+		subscriber.Watermark = &watermark
+		subscriber.fail("cannot compute the lag without algod")
+	default:
+		lag := roundLag(*algod.LastRound, watermark)
+		subscriber.Watermark, subscriber.Lag = &watermark, &lag
+		if lag > config.MaxSubscriberLag {
+			subscriber.fail("the subscriber is %d rounds behind algod", lag)
+		}
+	}
+
+	merkleTree := &readyCheck{OK: true}
+	if check, err := h.avm.CheckTreeOnchain(ctx); err != nil {
+		merkleTree.fail("%v", err)
+	} else {
+		merkleTree.LeafCount, merkleTree.AtTip = &check.LeafCount, &check.AtTip
+	}
+
+	resp := readyResponse{
+		Status: "ready",
+		Checks: map[string]*readyCheck{
+			"algod":      algod,
+			"internalDb": internalDb,
+			"txnsDb":     txnsDb,
+			"subscriber": subscriber,
+			"merkleTree": merkleTree,
+		},
+	}
+	httpStatus := http.StatusOK
+	for name, check := range resp.Checks {
+		if !check.OK {
+			log.Printf("Readiness check %s failed: %s", name, check.Error)
+			resp.Status, httpStatus = "not_ready", http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, httpStatus, resp)
+}
@@ -0,0 +1,476 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/models"
+	"github.com/giuliop/HermesVault-frontend/zkp"
+)
+
+// jobPollInterval is how often the job page polls the job state
+const jobPollInterval = "2s"
+
+// jobTracker saves the progress of a job running in the background to the store.
+// Updates after the job is done are ignored
+type jobTracker struct {
+	mu    sync.Mutex
+	store db.JobStore
+	job   models.Job
+	steps int // receipts saved so far
+}
+
+// update applies f to the job and saves it
+func (t *jobTracker) update(f func(j *models.Job)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.job.State.Done() {
+		return
+	}
+	f(&t.job)
+	t.job.UpdatedAt = time.Now().UTC()
+	if err := t.store.SaveJob(&t.job); err != nil {
+		log.Printf("Error saving job: %v", err)
+	}
+}
+
+// queuePosition records the position of the job proof in the prover queue, 0 meaning
+// the proof is being generated
+func (t *jobTracker) queuePosition(position int) {
+	t.update(func(j *models.Job) {
+		switch {
+		case position == 0:
+			j.State, j.QueuePosition = models.JobProving, 0
+		case j.State == models.JobQueued:
+			j.QueuePosition = position
+		}
+	})
+}
+
+// submitted records that the job transactions were sent to the network
+func (t *jobTracker) submitted(txnId string) {
+	t.update(func(j *models.Job) {
+		j.State, j.QueuePosition, j.TxnId = models.JobSubmitted, 0, txnId
+	})
+}
+
+// next records that the job moves on to the next withdrawal of a batch, waiting again
+// for its proof
+func (t *jobTracker) next() {
+	t.update(func(j *models.Job) {
+		j.State, j.QueuePosition = models.JobQueued, 0
+	})
+}
+
+// addReceipt saves the receipt of the next step of the job: the transactions of a
+// deposit or withdrawal, or one of the withdrawals of a batch
+func (t *jobTracker) addReceipt(receipt *models.Receipt) {
+	if err := t.store.SaveReceipt(t.job.Id, t.steps, receipt); err != nil {
+		log.Printf("Error saving receipt: %v", err)
+	}
+	t.steps++
+}
+
+// confirmed records that the job transactions were confirmed, saving the receipt of the
+// last ones before the job so that a confirmed job always has one
+func (t *jobTracker) confirmed(receipt *models.Receipt) {
+	t.addReceipt(receipt)
+	t.update(func(j *models.Job) {
+		j.State, j.TxnId, j.LeafIndex = models.JobConfirmed, receipt.TxnId, receipt.LeafIndex
+	})
+}
+
+// failed records that the job failed with err, with message for the user
+func (t *jobTracker) failed(err *avm.TxnConfirmationError, message string) {
+	t.update(func(j *models.Job) {
+		j.State, j.QueuePosition = models.JobFailed, 0
+		j.ErrorType, j.Message, j.RetryAfter = err.Type.String(), message, err.RetryAfter
+	})
+}
+
+// startJob saves a new job of the given kind and runs it in the background with run,
+// which reports the job progress to the tracker.
+// If run panics the job is marked failed, the server goes on
+func (h *Handlers) startJob(kind models.JobKind, run func(t *jobTracker),
+) (*models.Job, error) {
+	job, err := models.NewJob(kind, config.InstanceId)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.store.SaveJob(job); err != nil {
+		return nil, err
+	}
+	t := &jobTracker{store: h.store, job: *job}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Panic running %s job %s: %v\n%s", kind, job.Id, r, debug.Stack())
+				message := "Something went wrong. " +
+					"Check the status of your note before trying again"
+				if kind == models.BatchWithdrawalJob {
+					message = batchInterruptedMessage
+				}
+				t.failed(avm.InternalError(fmt.Sprintf("panic: %v", r)), message)
+			}
+		}()
+		run(t)
+	}()
+	return job, nil
+}
+
+// startWithdrawalJob sends a withdrawal in the background like sendWithdrawal, returning
+// the job to follow it. The withdrawal goes on if the user leaves
+func (h *Handlers) startWithdrawalJob(withdrawData *models.WithdrawalData,
+) (*models.Job, error) {
+	return h.startJob(models.WithdrawalJob, func(t *jobTracker) {
+		ctx := zkp.WithQueuePosition(context.Background(), t.queuePosition)
+		receipt, confirmationError := h.sendWithdrawal(ctx, withdrawData, t.submitted)
+		if confirmationError != nil {
+			log.Printf("Error sending withdrawal job %s: %v", t.job.Id,
+				confirmationError.Error())
+			t.failed(confirmationError, withdrawalErrorMessage(confirmationError))
+			return
+		}
+		t.confirmed(receipt)
+	})
+}
+
+// startDepositJob sends a deposit in the background like sendDeposit, returning the job
+// to follow it. The deposit goes on if the user leaves
+func (h *Handlers) startDepositJob(depositData *models.DepositData, signedTxnBytes []byte,
+) (*models.Job, error) {
+	return h.startJob(models.DepositJob, func(t *jobTracker) {
+		receipt, confirmationError := h.sendDeposit(depositData, signedTxnBytes, t.submitted)
+		if confirmationError != nil {
+			log.Printf("Error sending deposit job %s: %v", t.job.Id,
+				confirmationError.Error())
+			t.failed(confirmationError,
+				h.depositErrorMessage(confirmationError, depositData.Address))
+			return
+		}
+		t.confirmed(receipt)
+	})
+}
+
+// FailInterruptedJobs marks as failed the jobs left unfinished by a previous run of this
+// server instance, leaving alone those of the other instances sharing the internal
+// database. It must be called before starting new jobs
+func (h *Handlers) FailInterruptedJobs() {
+	jobs, err := h.store.GetUnfinishedJobs(config.InstanceId)
+	if err != nil {
+		log.Printf("Error getting unfinished jobs: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		// jobs interrupted once submitted may still be confirmed, like after a timeout
+		err := avm.InternalError("job interrupted")
+		if job.State == models.JobSubmitted {
+			err.Type = avm.ErrWaitTimeout
+		}
+		switch job.Kind {
+		case models.DepositJob:
+			job.Message = h.depositErrorMessage(err, "")
+		case models.BatchWithdrawalJob:
+			job.Message = batchInterruptedMessage
+		default:
+			job.Message = withdrawalErrorMessage(err)
+		}
+		job.State, job.QueuePosition, job.ErrorType = models.JobFailed, 0, err.Type.String()
+		job.UpdatedAt = time.Now().UTC()
+		if err := h.store.SaveJob(job); err != nil {
+			log.Printf("Error saving interrupted job: %v", err)
+		}
+	}
+}
+
+// batchInterruptedMessage is the message for the user of a batch withdrawal job stopped
+// before it finished, shown with the notes stored for the job
+const batchInterruptedMessage = "Your batch withdrawal was interrupted. " +
+	"If the last withdrawal sent was confirmed by the blockchain, your remaining funds " +
+	"are in the second secret note below, otherwise in the first:"
+
+// withdrawalErrorMessage returns the message for the user for an error sending a
+// withdrawal
+func withdrawalErrorMessage(err *avm.TxnConfirmationError) string {
+	switch err.Type {
+	case avm.ErrRejected:
+		return "Your withdrawal was rejected by the network. " +
+			"Please check your secret note and try again"
+	case avm.ErrWaitTimeout:
+		return "Your withdrawal has not been confirmed by the blockchain yet. " +
+			"Check the recipient account in a few minutes to see if it was received"
+	case avm.ErrServerBusy:
+		return serverBusyMessage(err.RetryAfter)
+	case avm.ErrNoteSpent:
+		return "This secret note has already been spent, so there is nothing left to " +
+			"withdraw from it"
+	case avm.ErrRootExpired:
+		return "The vault changed while your withdrawal was being prepared. " +
+			"Your funds are safe, please try again"
+	case avm.ErrTreeDivergence:
+		return "The vault is out of sync with the blockchain, so withdrawals are paused. " +
+			"Your funds are safe, please try again later"
+	default:
+		return "Something went wrong. Your withdrawal was not processed. Please try again"
+	}
+}
+
+// depositErrorMessage returns the message for the user for an error sending a deposit
+// from address
+func (h *Handlers) depositErrorMessage(err *avm.TxnConfirmationError,
+	address models.Address) string {
+	switch err.Type {
+	case avm.ErrRejected:
+		return "Your deposit transaction was rejected by the network. Please try again"
+	case avm.ErrOverSpend, avm.ErrMinimumBalanceRequirement:
+		maxSpend, _, maxErr := h.maxDepositAmount(address)
+		if maxErr == nil {
+			return fmt.Sprintf("The maximum amount you can deposit is %s ALGO",
+				models.MicroAlgosToAlgoString(maxSpend))
+		}
+		return "You do not have enough funds to cover this deposit"
+	case avm.ErrExpired:
+		return "Your deposit transaction has expired. Please try again"
+	case avm.ErrWaitTimeout:
+		return "Your deposit has not been confirmed by the network yet. " +
+			"Check your account in a few minutes to see if the deposit was sent"
+	default:
+		return "Something went wrong. Your deposit was not processed. Please try again"
+	}
+}
+
+// getJob returns the job with the id in the request. If it fails, it writes the error
+// response with writeError and returns nil
+func (h *Handlers) getJob(id string, writeError func(status int, message string),
+) *models.Job {
+	if !models.IsJobId(id) {
+		writeError(http.StatusNotFound, "Job not found")
+		return nil
+	}
+	job, err := h.store.GetJob(id)
+	switch err {
+	case nil:
+		return job
+	case sql.ErrNoRows:
+		writeError(http.StatusNotFound, "Job not found")
+		return nil
+	default:
+		log.Printf("Error getting job %s: %v", id, err)
+		writeError(http.StatusInternalServerError, "Something went wrong")
+		return nil
+	}
+}
+
+// JobHandler renders the state of the job with the id in the query, which polls itself
+// until the job is done
+func (h *Handlers) JobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if RenderFullPageIfNotHtmx(w, r, jobPath(id)) {
+		return
+	}
+	job := h.getJob(id, func(status int, message string) {
+		http.Error(w, message, status)
+	})
+	if job == nil {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	switch {
+	case job.Kind == models.BatchWithdrawalJob && job.State == models.JobFailed:
+		fmt.Fprint(w, h.modalBatchJobFailed(job))
+	case job.Kind == models.BatchWithdrawalJob && job.State == models.JobConfirmed:
+		fmt.Fprint(w, modalJobConfirmed(job, `
+		  <p>
+			`+h.batchReceiptsHtml(job.Id)+`
+		  </p>`))
+	default:
+		fmt.Fprint(w, jobHtml(job))
+	}
+}
+
+// jobPath returns the path of the page of the job with the given id
+func jobPath(id string) string {
+	return "job?id=" + id
+}
+
+// writeJobStarted writes the page of a job just started, setting the browser url to the
+// job page so that reloading it shows the job
+func writeJobStarted(w http.ResponseWriter, job *models.Job) {
+	w.Header().Set("HX-Push-Url", "/"+jobPath(job.Id))
+	fmt.Fprint(w, jobHtml(job))
+}
+
+// jobHtml renders a job: the result modal if it is done, otherwise a progress modal
+// polling the job state
+func jobHtml(job *models.Job) string {
+	name, page := "Withdrawal", "withdraw"
+	switch job.Kind {
+	case models.DepositJob:
+		name, page = "Deposit", "deposit"
+	case models.BatchWithdrawalJob:
+		name, page = "Batch withdrawal", "withdraw-batch"
+	}
+
+	switch job.State {
+	case models.JobConfirmed:
+		return modalJobConfirmed(job, `
+		  <p>
+			`+receiptLinks(job.Id, 0)+`
+		  </p>`)
+	case models.JobFailed:
+		if job.Kind == models.DepositJob {
+			return modalDepositFailed(html.EscapeString(job.Message))
+		}
+		return modalWithdrawalFailed(html.EscapeString(job.Message))
+	}
+
+	var progress string
+	switch job.State {
+	case models.JobQueued:
+		progress = fmt.Sprintf(`Waiting to generate the zero knowledge proof,
+			number %d in the queue`, job.QueuePosition)
+	case models.JobProving:
+		progress = `Generating the zero knowledge proof`
+	default:
+		progress = `Waiting for the blockchain to confirm the transaction`
+	}
+	return `
+		<div hx-get="` + jobPath(job.Id) + `" hx-trigger="every ` + jobPollInterval + `"
+			 hx-swap="outerHTML">
+		<dialog class="modal">
+		  <h1>&#8987; ` + name + ` in progress</h1>
+		  <p>
+			` + progress + `
+		  </p>
+		  <p>
+			You can leave this page and come back to
+			<a href="/` + jobPath(job.Id) + `">this link</a> to see the result.
+		  </p>
+		  <button hx-get="` + page + `" onclick="this.parentElement.close()">
+			Close
+		  </button>
+		</dialog>
+		<script>
+		  document.querySelectorAll('dialog')[0].showModal()
+		</script>
+		</div>
+	`
+}
+
+// modalJobConfirmed renders the result of a confirmed job with the links to its receipts
+func modalJobConfirmed(job *models.Job, receipts string) string {
+	title := "Withdrawal successful"
+	msg := `You can use your new secret note to withdraw any remaining balance
+			in the future.`
+	page := "withdraw"
+	switch {
+	case job.Kind == models.DepositJob:
+		title = "Deposit successful"
+		msg = `You can use your new secret note to withdraw your funds in the future.`
+	case job.Kind == models.BatchWithdrawalJob:
+		title, page = "Withdrawals successful", "withdraw-batch"
+	case job.LeafIndex == models.EmptyLeafIndex:
+		msg = `Your whole deposit was withdrawn, no new secret note was issued.`
+	}
+	return `
+		<dialog class="modal">
+		  <h1>&#9989; ` + title + `</h1>
+		  <p>
+			` + msg + `
+		  </p>` + receipts + `
+		  <button hx-get="` + page + `" onclick="this.parentElement.close()">
+			Close
+		  </button>
+		</dialog>
+		<script>
+		  document.querySelectorAll('dialog')[0].showModal()
+		</script>
+	`
+}
+
+type apiJobResponse struct {
+	Id            string          `json:"id"`
+	Kind          models.JobKind  `json:"kind"`
+	State         models.JobState `json:"state"`
+	QueuePosition int             `json:"queuePosition,omitempty"`
+	TxnId         string          `json:"txnId,omitempty"`
+	// the leaf index of the note inserted, once confirmed, none for a withdrawal with
+	// no change
+	LeafIndex *uint64   `json:"leafIndex,omitempty"`
+	Error     *apiError `json:"error,omitempty"`
+	// the receipts of the completed withdrawals of a batch, in order, also if it failed
+	Receipts  []apiReceipt `json:"receipts,omitempty"`
+	CreatedAt time.Time    `json:"createdAt"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+}
+
+func newAPIJobResponse(job *models.Job) apiJobResponse {
+	response := apiJobResponse{
+		Id:            job.Id,
+		Kind:          job.Kind,
+		State:         job.State,
+		QueuePosition: job.QueuePosition,
+		TxnId:         job.TxnId,
+		CreatedAt:     job.CreatedAt,
+		UpdatedAt:     job.UpdatedAt,
+	}
+	if job.State == models.JobConfirmed && job.LeafIndex != models.EmptyLeafIndex {
+		response.LeafIndex = &job.LeafIndex
+	}
+	if job.State == models.JobFailed {
+		code, _ := txnErrorCode(avm.ParseSendTxnErrorType(job.ErrorType))
+		response.Error = &apiError{Code: code, Message: job.Message}
+	}
+	return response
+}
+
+// writeAPIJobStarted writes the response for a job just started, with its url to follow it
+func writeAPIJobStarted(w http.ResponseWriter, job *models.Job) {
+	w.Header().Set("Location", APIPrefix+"jobs/"+job.Id)
+	writeJSON(w, http.StatusAccepted, newAPIJobResponse(job))
+}
+
+// APIJobHandler returns the state of the job with the id in the path
+func (h *Handlers) APIJobHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowAPIGet(w, r) {
+		return
+	}
+	job := h.getJob(r.PathValue("id"), func(status int, message string) {
+		code := apiErrJobNotFound
+		if status == http.StatusInternalServerError {
+			code = apiErrInternal
+		}
+		writeAPIError(w, status, code, message)
+	})
+	if job == nil {
+		return
+	}
+	response := newAPIJobResponse(job)
+	if job.Kind == models.BatchWithdrawalJob {
+		receipts, err := h.store.GetReceipts(job.Id)
+		if err != nil {
+			log.Printf("Error getting receipts of job %s: %v", job.Id, err)
+			writeAPIError(w, http.StatusInternalServerError, apiErrInternal,
+				"Something went wrong")
+			return
+		}
+		for _, receipt := range receipts {
+			response.Receipts = append(response.Receipts, newAPIReceipt(receipt))
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -10,7 +10,7 @@ import (
 	"github.com/giuliop/HermesVault-frontend/models"
 )
 
-func MaxDepositHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) MaxDepositHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
@@ -28,7 +28,7 @@ func MaxDepositHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	maxAmount, err := maxDepositAmount(address)
+	maxAmount, fee, err := h.maxDepositAmount(address)
 	if err != nil {
 		log.Printf("Error computing max deposit amount for %s: %v", address, err)
 		http.Error(w, "failed to compute max deposit amount", http.StatusInternalServerError)
@@ -39,6 +39,10 @@ func MaxDepositHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	maxAmountInput := html.EscapeString(microAlgosToInputAmount(maxAmount))
 	_, err = fmt.Fprintf(w, `<input type="number" id="depositAmount" name="amount" data-wallet-amount placeholder="algo to deposit" step="0.000001" min="1" required value="%s">`, maxAmountInput)
+	if err == nil {
+		// the fee the max amount is net of, sent back with the deposit
+		_, err = fmt.Fprintf(w, `<input type="hidden" id="depositFee" name="depositFee" value="%s" hx-swap-oob="true">`, microAlgosToInputAmount(fee))
+	}
 	if err != nil {
 		log.Printf("Error rendering max deposit input: %v", err)
 	}
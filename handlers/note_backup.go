@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// NoteBackupHandler returns the secret note in the form encrypted with the passphrase,
+// as a backup file to download
+func (h *Handlers) NoteBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error parsing form: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	note, err := models.Input(r.FormValue("note")).ToNote(h.appId())
+	if err != nil {
+		log.Printf("Error parsing note to back up: %v", err)
+		http.Error(w, "The note is not valid: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	backup, err := models.EncryptNoteBackup(note, h.appId(), r.FormValue("passphrase"))
+	if err != nil {
+		log.Printf("Error encrypting note backup: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	filename := "hermesvault-note-" + time.Now().UTC().Format("2006-01-02") +
+		models.NoteBackupExtension
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(backup)
+}
+
+// noteFromForm returns the secret note in the form field or, if a file is uploaded in the
+// field+"Backup" field, the note in that backup file decrypted with the passphrase in the
+// field+"Passphrase" field. The errors are meant for the user
+func (h *Handlers) noteFromForm(r *http.Request, field string) (*models.Note, error) {
+	file, _, err := r.FormFile(field + "Backup")
+	switch {
+	case errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart):
+		return models.Input(r.FormValue(field)).ToNote(h.appId())
+	case err != nil:
+		log.Printf("Error reading note backup upload: %v", err)
+		return nil, errors.New("could not read the backup file")
+	}
+	defer file.Close()
+
+	backup, err := io.ReadAll(io.LimitReader(file, models.MaxNoteBackupSize+1))
+	if err != nil {
+		log.Printf("Error reading note backup upload: %v", err)
+		return nil, errors.New("could not read the backup file")
+	}
+	note, err := models.DecryptNoteBackup(backup, h.appId(),
+		r.FormValue(field+"Passphrase"))
+	if err != nil {
+		return nil, fmt.Errorf("backup file: %v", err)
+	}
+	return note, nil
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/frontend/templates"
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// noteStatus looks up whether note is in the tree, spent onchain or waiting for its
+// transactions to be confirmed
+func (h *Handlers) noteStatus(ctx context.Context, note *models.Note,
+) (*models.NoteStatus, error) {
+	status := &models.NoteStatus{
+		Amount:        models.NewAmount(note.Amount),
+		Commitment:    note.Commitment(),
+		LeafIndex:     models.EmptyLeafIndex,
+		MaxWithdrawal: note.MaxWithdrawalAmount(),
+	}
+	leafIndex, err := h.store.GetLeafIndexByCommitment(status.Commitment)
+	switch err {
+	case nil:
+		status.LeafIndex = leafIndex
+	case sql.ErrNoRows:
+	default:
+		return nil, fmt.Errorf("error getting leaf index by commitment: %v", err)
+	}
+	status.Unconfirmed, err = h.store.HasUnconfirmedNote(status.Commitment)
+	if err != nil {
+		return nil, err
+	}
+	status.Spent, err = h.avm.IsNullifierSpent(ctx, note.Nullifier())
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case status.Spent:
+		status.State = models.NoteSpent
+	case status.InTree():
+		status.State = models.NoteInTree
+	case status.Unconfirmed:
+		status.State = models.NotePending
+	default:
+		status.State = models.NoteUnknown
+	}
+	return status, nil
+}
+
+// NoteStatusHandler serves the form to check a secret note and, on POST, its status
+func (h *Handlers) NoteStatusHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Cache-Control", config.CacheControl)
+
+		// Check if this is an HTMX request, if not, render the full page
+		if RenderFullPageIfNotHtmx(w, r, "note-status") {
+			return
+		}
+
+		if err := templates.NoteStatusForm.Execute(w, nil); err != nil {
+			log.Printf("Error executing note status form template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			log.Printf("Error parsing form: %v", err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		note, err := models.Input(r.FormValue("note")).ToNote(h.appId())
+		if err != nil {
+			log.Printf("Error parsing note to check: %v", err)
+			http.Error(w, "The note you provided is not valid: "+
+				html.EscapeString(err.Error()), http.StatusUnprocessableEntity)
+			return
+		}
+		status, err := h.noteStatus(r.Context(), note)
+		if err != nil {
+			log.Printf("Error getting note status: %v", err)
+			http.Error(w, "<b>Something went wrong.</b><br>Please try again.",
+				http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		if err := templates.NoteStatus.Execute(w, status); err != nil {
+			log.Printf("Error executing note status template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type apiNoteStatusRequest struct {
+	Note string `json:"note"`
+}
+
+type apiNoteStatusResponse struct {
+	State         models.NoteState `json:"state"`
+	Amount        apiAmount        `json:"amount"`
+	Commitment    []byte           `json:"commitment"`
+	LeafIndex     *uint64          `json:"leafIndex"` // null if not in the tree
+	Spent         bool             `json:"spent"`
+	Pending       bool             `json:"pending"`
+	MaxWithdrawal apiAmount        `json:"maxWithdrawal"`
+}
+
+// APINoteStatusHandler returns the status of a secret note: whether it is in the tree,
+// spent or pending, with its amount and the maximum that can be withdrawn from it
+func (h *Handlers) APINoteStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var req apiNoteStatusRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+	note, err := models.Input(req.Note).ToNote(h.appId())
+	if err != nil {
+		log.Printf("Error parsing note to check: %v", err)
+		writeAPIInputError(w, map[string]string{
+			"note": "The note you provided is not valid: " + err.Error()})
+		return
+	}
+	status, err := h.noteStatus(r.Context(), note)
+	if err != nil {
+		log.Printf("Error getting note status: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal,
+			"Something went wrong, please try again")
+		return
+	}
+
+	resp := apiNoteStatusResponse{
+		State:         status.State,
+		Amount:        newAPIAmount(status.Amount),
+		Commitment:    status.Commitment,
+		Spent:         status.Spent,
+		Pending:       status.Unconfirmed,
+		MaxWithdrawal: newAPIAmount(status.MaxWithdrawal),
+	}
+	if status.InTree() {
+		resp.LeafIndex = &status.LeafIndex
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/frontend/templates"
+	"github.com/giuliop/HermesVault-frontend/models"
+)
+
+// getReceipt returns the receipt of step of the job with the given id: 0 for a deposit or
+// withdrawal, the index of the withdrawal for a batch, whose completed withdrawals have a
+// receipt even if a later one failed.
+// If it fails, it writes the error response with writeError and returns nil
+func (h *Handlers) getReceipt(id string, step int,
+	writeError func(status int, message string)) *models.Receipt {
+	job := h.getJob(id, writeError)
+	if job == nil {
+		return nil
+	}
+	receipt, err := h.store.GetReceipt(id, step)
+	switch {
+	case err == nil:
+		return receipt
+	case err == sql.ErrNoRows && job.State != models.JobConfirmed:
+		writeError(http.StatusNotFound, "The "+string(job.Kind)+" has not been confirmed")
+		return nil
+	case err == sql.ErrNoRows:
+		writeError(http.StatusNotFound, "Receipt not found")
+		return nil
+	default:
+		log.Printf("Error getting receipt of job %s: %v", id, err)
+		writeError(http.StatusInternalServerError, "Something went wrong")
+		return nil
+	}
+}
+
+// receiptPath returns the path of the receipt of step of the job with the given id
+func receiptPath(id string, step int) string {
+	if step == 0 {
+		return "receipt?id=" + id
+	}
+	return "receipt?id=" + id + "&step=" + strconv.Itoa(step)
+}
+
+// receiptLinks renders the links to the receipt of step of the job with the given id
+func receiptLinks(id string, step int) string {
+	path := "/" + receiptPath(id, step)
+	return `<a class="underlined" href="` + path + `" target="_blank"
+			   hx-boost="false">Printable receipt</a>
+			|
+			<a class="underlined" href="` + path + `&format=json" download
+			   hx-boost="false">Download receipt</a>`
+}
+
+// parseStep parses the receipt step of a request, 0 if not given
+func parseStep(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	step, err := strconv.Atoi(value)
+	if err == nil && step < 0 {
+		err = fmt.Errorf("negative step %d", step)
+	}
+	return step, err
+}
+
+// ReceiptHandler serves the receipt of the confirmed job with the id in the request, or
+// with step of one withdrawal of a batch, as a printable page or, with format json, as a
+// JSON file.
+// The receipt includes the secret note issued only if it is posted in the note field
+func (h *Handlers) ReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error parsing form: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	id := r.FormValue("id")
+	step, err := parseStep(r.FormValue("step"))
+	if err != nil {
+		http.Error(w, "Invalid receipt step", http.StatusBadRequest)
+		return
+	}
+	receipt := h.getReceipt(id, step, func(status int, message string) {
+		http.Error(w, message, status)
+	})
+	if receipt == nil {
+		return
+	}
+	if r.Method == http.MethodPost {
+		note, err := models.Input(r.FormValue("note")).ToNote(h.appId())
+		if err == nil {
+			receipt, err = receipt.WithNote(note, h.appId())
+		}
+		if err != nil {
+			log.Printf("Error adding note to receipt: %v", err)
+			http.Error(w, "The note you provided is not valid: "+err.Error(),
+				http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if r.FormValue("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(
+			`attachment; filename="hermesvault-%s-%s.json"`, receipt.Kind, receipt.TxnId))
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(newAPIReceipt(receipt)); err != nil {
+			log.Printf("Error encoding receipt: %v", err)
+		}
+		return
+	}
+	data := struct {
+		Id      string
+		Step    int
+		Path    string
+		Receipt *models.Receipt
+	}{id, step, receiptPath(id, step), receipt}
+	if err := templates.Receipt.Execute(w, data); err != nil {
+		log.Printf("Error executing receipt template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// apiReceipt is the API representation of models.Receipt
+type apiReceipt struct {
+	Kind  models.JobKind `json:"kind"`
+	TxnId string         `json:"txnId"`
+	Round uint64         `json:"round"`
+	// the timestamp of the block of round, none if it could not be read
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"`
+	Amount      apiAmount  `json:"amount"`
+	// the fees of the deposit txns, paid by the depositor, only for deposits
+	TxnFees *apiAmount `json:"txnFees,omitempty"`
+	// the fee taken from the withdrawn note, only for withdrawals
+	WithdrawalFee *apiAmount `json:"withdrawalFee,omitempty"`
+	Address       string     `json:"address"` // depositor or withdrawal recipient
+	// the note inserted, none for a withdrawal with no change
+	LeafIndex  *uint64    `json:"leafIndex,omitempty"`
+	NoteAmount *apiAmount `json:"noteAmount,omitempty"`
+	Commitment []byte     `json:"commitment,omitempty"`
+	Note       string     `json:"note,omitempty"` // only if requested
+}
+
+func newAPIReceipt(r *models.Receipt) apiReceipt {
+	receipt := apiReceipt{
+		Kind:    r.Kind,
+		TxnId:   r.TxnId,
+		Round:   r.Round,
+		Amount:  newAPIAmount(r.Amount),
+		Address: string(r.Address),
+		Note:    r.Note,
+	}
+	if !r.ConfirmedAt.IsZero() {
+		receipt.ConfirmedAt = &r.ConfirmedAt
+	}
+	if r.Kind == models.DepositJob {
+		fee := newAPIAmount(r.TxnFees)
+		receipt.TxnFees = &fee
+	} else {
+		fee := newAPIAmount(r.WithdrawalFee)
+		receipt.WithdrawalFee = &fee
+	}
+	if r.HasNote() {
+		noteAmount := newAPIAmount(r.NoteAmount)
+		receipt.LeafIndex, receipt.NoteAmount = &r.LeafIndex, &noteAmount
+		receipt.Commitment = r.Commitment
+	}
+	return receipt
+}
+
+type apiReceiptRequest struct {
+	Note string `json:"note"`
+}
+
+// APIReceiptHandler returns the receipt of the confirmed job with the id in the path, or
+// with the step query of one withdrawal of a batch.
+// On POST the receipt includes the secret note issued, which must be sent in the request
+func (h *Handlers) APIReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	var req apiReceiptRequest
+	if r.Method == http.MethodPost {
+		if !decodeAPIRequest(w, r, &req) {
+			return
+		}
+	} else if !allowAPIGet(w, r) {
+		return
+	}
+	step, err := parseStep(r.URL.Query().Get("step"))
+	if err != nil {
+		writeAPIInputError(w, map[string]string{"step": "Invalid receipt step"})
+		return
+	}
+	receipt := h.getReceipt(r.PathValue("id"), step, func(status int, message string) {
+		code := apiErrJobNotFound
+		if status == http.StatusInternalServerError {
+			code = apiErrInternal
+		}
+		writeAPIError(w, status, code, message)
+	})
+	if receipt == nil {
+		return
+	}
+	if r.Method == http.MethodPost {
+		note, err := models.Input(req.Note).ToNote(h.appId())
+		if err == nil {
+			receipt, err = receipt.WithNote(note, h.appId())
+		}
+		if err != nil {
+			log.Printf("Error adding note to receipt: %v", err)
+			writeAPIInputError(w, map[string]string{
+				"note": "The note you provided is not valid: " + err.Error()})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, newAPIReceipt(receipt))
+}
@@ -4,7 +4,6 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/giuliop/HermesVault-frontend/db"
 	"github.com/giuliop/HermesVault-frontend/frontend/templates"
 )
 
@@ -19,7 +18,7 @@ type Stats struct {
 	FeeTotal        string
 }
 
-func StatsHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) StatsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "public, max-age=300") // 300 sec = 5 min
 
 	if r.Method != http.MethodGet {
@@ -33,7 +32,7 @@ func StatsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get stats from the database
-	statData, err := db.GetStats()
+	statData, err := h.store.GetStats()
 	if err != nil {
 		log.Printf("Error retrieving stats: %v", err)
 		http.Error(w, "Error retrieving statistics, try again later",
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+)
+
+// txnsDbLagRetrySeconds is the Retry-After sent when the txns database is lagging
+const txnsDbLagRetrySeconds = 60
+
+// txnsDbLaggingMessage is the message shown when the txns database is lagging
+const txnsDbLaggingMessage = "The vault is catching up with the blockchain, so your " +
+	"note may not be visible yet. Please try again in a minute"
+
+// TxnsDbLag returns how many rounds the txns database written by the subscriber is
+// behind the last round of algod
+func (h *Handlers) TxnsDbLag(ctx context.Context) (uint64, error) {
+	status, err := h.avm.AlgodClient().Status(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error getting algod status: %v", err)
+	}
+	watermark, err := h.store.GetWatermark(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return roundLag(status.LastRound, watermark), nil
+}
+
+// txnsDbLagging reports whether the txns database is more than config.MaxSubscriberLag
+// rounds behind algod, so that recent notes may be missing and the merkle roots stale.
+// If the lag cannot be read it logs the error and reports false
+func (h *Handlers) txnsDbLagging(ctx context.Context) bool {
+	lag, err := h.TxnsDbLag(ctx)
+	if err != nil {
+		log.Printf("Error getting txns database lag: %v", err)
+		return false
+	}
+	if lag > config.MaxSubscriberLag {
+		log.Printf("The txns database is %d rounds behind algod", lag)
+		return true
+	}
+	return false
+}
+
+// roundLag returns how many rounds watermark is behind lastRound
+func roundLag(lastRound, watermark uint64) uint64 {
+	if lastRound > watermark {
+		return lastRound - watermark
+	}
+	return 0
+}
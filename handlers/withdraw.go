@@ -2,16 +2,16 @@ package handlers
 
 import (
 	"database/sql"
+	"html"
 	"log"
 	"net/http"
 
 	"github.com/giuliop/HermesVault-frontend/config"
-	"github.com/giuliop/HermesVault-frontend/db"
 	"github.com/giuliop/HermesVault-frontend/frontend/templates"
 	"github.com/giuliop/HermesVault-frontend/models"
 )
 
-func WithdrawHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) WithdrawHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		w.Header().Set("Cache-Control", config.CacheControl)
@@ -30,9 +30,15 @@ func WithdrawHandler(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error parsing form: %v", err)
 			http.Error(w, "Bad Request", http.StatusBadRequest)
 		}
-		amount, errAmount := models.Input(r.FormValue("amount")).ToAmount()
+		// with noChange the whole note is withdrawn, so there is no amount to parse
+		noChange := r.FormValue("noChange") == "true"
+		var amount models.Amount
+		var errAmount error
+		if !noChange {
+			amount, errAmount = models.Input(r.FormValue("amount")).ToAmount()
+		}
 		address, errAddress := models.Input(r.FormValue("address")).ToAddress()
-		note, errNote := models.Input(r.FormValue("note")).ToNote()
+		note, errNote := h.noteFromForm(r, "note")
 		errorMsg := ""
 		if errAmount != nil {
 			log.Printf("Error parsing withdrawal amount: %v", errAmount)
@@ -44,7 +50,8 @@ func WithdrawHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		if errNote != nil {
 			log.Printf("Error parsing withdrawal note: %v", errNote)
-			errorMsg += "The note you provided is not valid"
+			errorMsg += "The note you provided is not valid: " +
+				html.EscapeString(errNote.Error())
 		}
 		if errorMsg != "" {
 			http.Error(w, errorMsg, http.StatusUnprocessableEntity)
@@ -58,10 +65,24 @@ func WithdrawHandler(w http.ResponseWriter, r *http.Request) {
 			ChangeNote: nil,
 		}
 		var err error
-		withdrawData.FromNote.LeafIndex, err = db.GetLeafIndexByCommitment(
+		withdrawData.FromNote.LeafIndex, err = h.store.GetLeafIndexByCommitment(
 			withdrawData.FromNote.Commitment())
 		switch err {
 		case nil:
+			if noChange {
+				withdrawData, err = models.NewNoChangeWithdrawal(address, note)
+				if err != nil {
+					log.Printf("Error creating no change withdrawal: %v", err)
+					http.Error(w, "Note amount too small to withdraw",
+						http.StatusUnprocessableEntity)
+					return
+				}
+				if err := h.executeConfirmWithdrawal(w, withdrawData); err != nil {
+					log.Printf("Error executing success template: %v", err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				}
+				return
+			}
 			changeNote, err := models.GenerateChangeNote(amount, note)
 			if err != nil && err.Error() == "note amount too small" {
 				http.Error(w, "Note amount too small.<br>The maximum you can withdraw is <b>"+
@@ -75,7 +96,7 @@ func WithdrawHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			withdrawData.ChangeNote = changeNote
-			if err := templates.ConfirmWithdrawal.Execute(w, &withdrawData); err != nil {
+			if err := h.executeConfirmWithdrawal(w, withdrawData); err != nil {
 				log.Printf("Error executing success template: %v", err)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			}
@@ -83,7 +104,14 @@ func WithdrawHandler(w http.ResponseWriter, r *http.Request) {
 		case sql.ErrNoRows:
 			log.Printf("Leaf index not found for commitment: %v",
 				withdrawData.FromNote.Commitment())
-			errorMsg = "The note you provided is not valid<br>"
+			if h.txnsDbLagging(r.Context()) {
+				setRetryAfter(w, txnsDbLagRetrySeconds)
+				http.Error(w, txnsDbLaggingMessage, http.StatusServiceUnavailable)
+				return
+			}
+			errorMsg = "The note you provided is not in the vault.<br>" +
+				`<a class="underlined" hx-get="note-status" hx-target="#ui">` +
+				"Check the note</a> to learn if it is spent or still pending<br>"
 			http.Error(w, errorMsg, http.StatusUnprocessableEntity)
 			return
 		default:
@@ -95,3 +123,13 @@ func WithdrawHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+// executeConfirmWithdrawal renders the page to confirm withdrawData
+func (h *Handlers) executeConfirmWithdrawal(w http.ResponseWriter,
+	withdrawData *models.WithdrawalData) error {
+	data := struct {
+		*models.WithdrawalData
+		AppId uint64
+	}{withdrawData, h.appId()}
+	return templates.ConfirmWithdrawal.Execute(w, data)
+}
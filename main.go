@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -9,21 +10,103 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/giuliop/HermesVault-frontend/avm"
 	"github.com/giuliop/HermesVault-frontend/config"
 	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/db/encrypt"
 	"github.com/giuliop/HermesVault-frontend/frontend/templates"
 	"github.com/giuliop/HermesVault-frontend/handlers"
+	"github.com/giuliop/HermesVault-frontend/metrics"
+	"github.com/giuliop/HermesVault-frontend/subscriber"
+	"github.com/giuliop/HermesVault-frontend/zkp"
 )
 
 func main() {
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if err := config.LoadFlags(flag.CommandLine); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if config.PrintConfig {
+		config.Print(os.Stdout)
+		return
+	}
+	if err := encrypt.LoadPublicKey(config.PublicKeyPath); err != nil {
+		log.Fatalf("Error loading nullifier encryption key: %v", err)
+	}
+	if err := encrypt.LoadOrCreateSecretKey(config.SecretKeyPath); err != nil {
+		log.Fatalf("Error loading secret key: %v", err)
+	}
+
+	algodClient, err := avm.NewAlgodClient(config.AlgodPath, config.AlgodToken)
+	if err != nil {
+		log.Fatalf("Error creating algod client: %v", err)
+	}
+	app, err := avm.LoadApp(config.AppSetupDirPath)
+	if err != nil {
+		log.Fatalf("Error loading app setup: %v", err)
+	}
+
+	// The embedded subscriber is opened first so that the txns database exists when
+	// the store opens it read-only
+	if config.EmbeddedSubscriber {
+		appJson, err := avm.LoadAppJson(config.AppSetupDirPath)
+		if err != nil {
+			log.Fatalf("Error loading app setup: %v", err)
+		}
+		sub, err := subscriber.Open(config.TxnsDbPath, algodClient, app,
+			appJson.CreationBlock, false)
+		if err != nil {
+			log.Fatalf("Error opening subscriber: %v", err)
+		}
+		defer sub.Close()
+		subscriberCtx, subscriberCancel := context.WithCancel(context.Background())
+		defer subscriberCancel()
+		go sub.Run(subscriberCtx)
+	}
 
-	defer db.Close()
+	store, err := db.Open(config.InternalDbPath, config.TxnsDbPath, app.Id)
+	if err != nil {
+		log.Fatalf("Error opening databases: %v", err)
+	}
+	defer store.Close()
+
+	prover := zkp.NewProver(config.ProverWorkers, config.ProverQueueSize)
+	defer prover.Close()
+	avmClient, err := avm.NewClient(avm.NewAlgod(algodClient), app, store, prover)
+	if err != nil {
+		log.Fatalf("Error creating avm client: %v", err)
+	}
 
 	// Start periodic cleanup of internal database
-	db.CleanupUnconfirmedNotes()
-	cleanupCancel := db.StartCleanupRoutine(context.Background(), config.CleanupInterval)
+	store.CleanupUnconfirmedNotes()
+	store.CleanupPendingDeposits()
+	store.CleanupJobs()
+	cleanupCancel := db.StartCleanupRoutine(context.Background(), store,
+		config.CleanupInterval)
 	defer cleanupCancel()
 
+	// Load the merkle tree ahead of the first withdrawal
+	if err := avmClient.InitMerkleTree(); err != nil {
+		log.Printf("Error initializing merkle tree: %v", err)
+	}
+
+	metrics.NewGaugeFunc("hermesvault_pending_deposits",
+		"Deposits waiting for the user to sign them", func() (float64, error) {
+			count, err := store.CountPendingDeposits()
+			return float64(count), err
+		})
+	metrics.NewGaugeFunc("hermesvault_unconfirmed_notes",
+		"Notes whose transactions have not been confirmed yet", func() (float64, error) {
+			count, err := store.CountUnconfirmedNotes()
+			return float64(count), err
+		})
+
+	metrics.NewGaugeFunc("hermesvault_prover_queue_length",
+		"Zk proofs waiting for a prover worker", func() (float64, error) {
+			return float64(prover.Queued()), nil
+		})
+
 	templates.InitTemplates()
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -33,12 +116,45 @@ func main() {
 		}
 	})
 
-	http.HandleFunc("/deposit", handlers.DepositHandler)
-	http.HandleFunc("/withdraw", handlers.WithdrawHandler)
-	http.HandleFunc("/confirm-deposit", handlers.ConfirmDepositHandler)
-	http.HandleFunc("/confirm-withdraw", handlers.ConfirmWithdrawHandler)
-	http.HandleFunc("/max-deposit", handlers.MaxDepositHandler)
-	http.HandleFunc("/stats", handlers.StatsHandler)
+	h := handlers.New(store, avmClient)
+	h.FailInterruptedJobs()
+	metrics.NewGaugeFunc("hermesvault_txns_db_lag_rounds",
+		"Rounds the txns database is behind algod", func() (float64, error) {
+			ctx, cancel := context.WithTimeout(context.Background(),
+				config.ReadyCheckTimeout)
+			defer cancel()
+			lag, err := h.TxnsDbLag(ctx)
+			return float64(lag), err
+		})
+	http.HandleFunc("/deposit", h.DepositHandler)
+	http.HandleFunc("/withdraw", h.WithdrawHandler)
+	http.HandleFunc("/confirm-deposit", h.ConfirmDepositHandler)
+	http.HandleFunc("/confirm-withdraw", h.ConfirmWithdrawHandler)
+	http.HandleFunc("/withdraw-batch", h.BatchWithdrawHandler)
+	http.HandleFunc("/confirm-withdraw-batch", h.ConfirmBatchWithdrawHandler)
+	http.HandleFunc("/max-deposit", h.MaxDepositHandler)
+	http.HandleFunc("/stats", h.StatsHandler)
+	http.HandleFunc("/job", h.JobHandler)
+	http.HandleFunc("/note-backup", h.NoteBackupHandler)
+	http.HandleFunc("/note-status", h.NoteStatusHandler)
+	http.HandleFunc("/receipt", h.ReceiptHandler)
+
+	// JSON API
+	api := handlers.APIPrefix
+	http.HandleFunc(api+"deposits", h.APIDepositHandler)
+	http.HandleFunc(api+"deposits/confirm", h.APIConfirmDepositHandler)
+	http.HandleFunc(api+"withdrawals", h.APIWithdrawHandler)
+	http.HandleFunc(api+"withdrawals/confirm", h.APIConfirmWithdrawHandler)
+	http.HandleFunc(api+"max-deposit", h.APIMaxDepositHandler)
+	http.HandleFunc(api+"fees/quote", h.APIFeeQuoteHandler)
+	http.HandleFunc(api+"stats", h.APIStatsHandler)
+	http.HandleFunc(api+"jobs/{id}", h.APIJobHandler)
+	http.HandleFunc(api+"jobs/{id}/receipt", h.APIReceiptHandler)
+	http.HandleFunc(api+"notes/status", h.APINoteStatusHandler)
+
+	http.HandleFunc("/metrics", metrics.Handler)
+	http.HandleFunc("/healthz", h.HealthzHandler)
+	http.HandleFunc("/readyz", h.ReadyzHandler)
 
 	// Serve static files from the "static" directory
 	http.Handle("/static/", http.StripPrefix("/static/",
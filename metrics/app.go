@@ -0,0 +1,43 @@
+package metrics
+
+// The metrics of the frontend server
+var (
+	// Deposits counts the deposits sent to the network by outcome, which is "success" or
+	// the avm.SendTxnErrorType of the failure
+	Deposits = NewCounterVec("hermesvault_deposits_total",
+		"Deposits sent to the network by outcome", "outcome")
+
+	// Withdrawals counts the withdrawals sent to the network by outcome, which is
+	// "success" or the avm.SendTxnErrorType of the failure
+	Withdrawals = NewCounterVec("hermesvault_withdrawals_total",
+		"Withdrawals sent to the network by outcome", "outcome")
+
+	// ProofDuration measures the zk proof generation by circuit
+	ProofDuration = NewHistogramVec("hermesvault_proof_duration_seconds",
+		"Time to generate and verify a zk proof by circuit", ProofBuckets, "circuit")
+
+	// ProofQueueWait measures how long proofs wait in the prover queue by circuit
+	ProofQueueWait = NewHistogramVec("hermesvault_proof_queue_wait_seconds",
+		"Time a zk proof waits for a prover worker by circuit", ProofBuckets, "circuit")
+
+	// ProverRejections counts the proofs refused because the prover queue was full
+	ProverRejections = NewCounterVec("hermesvault_prover_rejections_total",
+		"Proofs refused because the prover queue was full by circuit", "circuit")
+
+	// MerkleProofDuration measures building a merkle proof, including the tree sync
+	MerkleProofDuration = NewHistogramVec("hermesvault_merkle_proof_duration_seconds",
+		"Time to build a merkle proof, including syncing the tree", DefBuckets)
+
+	// AlgodDuration measures the algod calls by method
+	AlgodDuration = NewHistogramVec("hermesvault_algod_request_duration_seconds",
+		"Latency of algod calls by method", DefBuckets, "method")
+
+	// AlgodErrors counts the failed algod calls by method
+	AlgodErrors = NewCounterVec("hermesvault_algod_errors_total",
+		"Failed algod calls by method", "method")
+
+	// TreeDivergences counts the checks finding that the merkle tree synced from the txns
+	// database does not match the roots the app holds onchain
+	TreeDivergences = NewCounterVec("hermesvault_tree_divergences_total",
+		"Checks finding the merkle tree diverged from the app roots onchain")
+)
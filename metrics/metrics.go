@@ -0,0 +1,265 @@
+// package metrics collects the server metrics and exposes them in the Prometheus text
+// format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metric is a metric family that can write itself in the Prometheus text format
+type metric interface {
+	write(w io.Writer)
+}
+
+// registry holds the metrics to expose, in registration order
+var registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func register(m metric) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.metrics = append(registry.metrics, m)
+}
+
+// Handler writes all the registered metrics in the Prometheus text format
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	registry.mu.Lock()
+	metrics := append([]metric(nil), registry.metrics...)
+	registry.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// CounterVec is a counter partitioned by the values of its labels
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]*counterValue // by joined label values
+}
+
+type counterValue struct {
+	labelValues []string
+	value       uint64
+}
+
+// NewCounterVec registers and returns a counter with the given labels
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]*counterValue),
+	}
+	register(c)
+	return c
+}
+
+// Inc increments the counter for the given label values, one per label
+func (c *CounterVec) Inc(labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		log.Printf("Metric %s: got %d label values, expected %d", c.name,
+			len(labelValues), len(c.labels))
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{labelValues: append([]string(nil), labelValues...)}
+		c.values[key] = v
+	}
+	v.value++
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		v := c.values[key]
+		fmt.Fprintf(w, "%s%s %d\n", c.name, formatLabels(c.labels, v.labelValues), v.value)
+	}
+}
+
+// HistogramVec is a histogram partitioned by the values of its labels
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64 // upper bounds, increasing
+	mu      sync.Mutex
+	values  map[string]*histogramValue // by joined label values
+}
+
+type histogramValue struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative
+	sum         float64
+	count       uint64
+}
+
+// NewHistogramVec registers and returns a histogram with the given bucket upper bounds,
+// in increasing order, and labels
+func NewHistogramVec(name, help string, buckets []float64, labels ...string,
+) *HistogramVec {
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+	register(h)
+	return h
+}
+
+// Observe adds an observation for the given label values, one per label
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		log.Printf("Metric %s: got %d label values, expected %d", h.name,
+			len(labelValues), len(h.labels))
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = v
+	}
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			v.counts[i]++
+			break
+		}
+	}
+	v.sum += value
+	v.count++
+}
+
+// ObserveSince adds the seconds elapsed since start as an observation for the given
+// label values
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range sortedKeys(h.values) {
+		v := h.values[key]
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += v.counts[i]
+			le := formatFloat(upperBound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+				formatLabels(bucketLabels, withValue(v.labelValues, le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+			formatLabels(bucketLabels, withValue(v.labelValues, "+Inf")), v.count)
+		labels := formatLabels(h.labels, v.labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatFloat(v.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, v.count)
+	}
+}
+
+// GaugeFunc is a gauge whose value is read when the metrics are scraped
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() (float64, error)
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by fn at each scrape.
+// If fn fails the gauge is omitted from that scrape
+func NewGaugeFunc(name, help string, fn func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	value, err := g.fn()
+	if err != nil {
+		log.Printf("Error reading metric %s: %v", g.name, err)
+		return
+	}
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(value))
+}
+
+// DefBuckets are histogram buckets in seconds suited to network calls
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ProofBuckets are histogram buckets in seconds suited to zk proof generation
+var ProofBuckets = []float64{.25, .5, 1, 2, 4, 8, 15, 30, 60}
+
+func writeHeader(w io.Writer, name, help, metricType string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// formatLabels returns the {name="value",...} label set, or "" if there are no labels
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// withValue returns a copy of values with value appended
+func withValue(values []string, value string) []string {
+	return append(append([]string(nil), values...), value)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
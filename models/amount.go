@@ -3,8 +3,6 @@ package models
 import (
 	"fmt"
 	"strings"
-
-	"github.com/giuliop/HermesVault-frontend/config"
 )
 
 // Amount represent an algo token amount
@@ -31,14 +29,6 @@ func (a *Amount) Round() *Amount {
 	return a
 }
 
-// CalculateWithdrawalFee calculates the withdrawal fee for a given amount
-func CalculateWithdrawalFee(amount uint64) uint64 {
-	if config.FrontendWithDrawalFeeDivisor == 0 {
-		return config.WithdrawalMinFee
-	}
-	return max(amount/config.FrontendWithDrawalFeeDivisor, config.WithdrawalMinFee)
-}
-
 // MicroAlgosToAlgoString converts microalgos (uint64) to a string representing algos.
 func MicroAlgosToAlgoString(microalgos uint64) string {
 	wholeAlgos := microalgos / 1_000_000
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Note backup files hold a secret note encrypted with XChaCha20-Poly1305, with a key
+// derived from a passphrase with argon2id. The file is:
+//
+//	magic | version | argon2 time | argon2 memory (KiB) | argon2 threads | salt | nonce |
+//	encrypted note text
+//
+// with the integers big endian and the header before the encrypted note authenticated
+// as additional data
+const (
+	backupMagic   = "HVNOTEBK"
+	backupVersion = 1
+
+	backupSaltSize   = 16
+	backupHeaderSize = len(backupMagic) + 1 + 4 + 4 + 1 + backupSaltSize +
+		chacha20poly1305.NonceSizeX
+
+	// the argon2id parameters of new backups, as recommended by RFC 9106
+	backupTime    = 3
+	backupMemory  = 64 * 1024
+	backupThreads = 4
+
+	// the maximum argon2id parameters accepted decrypting a backup, so that a crafted
+	// file cannot make the server derive a key at an arbitrary cost
+	maxBackupTime   = 10
+	maxBackupMemory = 256 * 1024
+)
+
+const (
+	// NoteBackupExtension is the file extension of note backups
+	NoteBackupExtension = ".hvbackup"
+
+	// MaxNoteBackupSize is the size in bytes above which a file cannot be a note backup
+	MaxNoteBackupSize = 1024
+
+	// MinBackupPassphraseLength is the minimum length of a backup passphrase
+	MinBackupPassphraseLength = 12
+)
+
+// ErrBackupPassphrase is returned decrypting a backup with the wrong passphrase, or a
+// backup that was altered
+var ErrBackupPassphrase = errors.New("wrong passphrase or corrupted backup file")
+
+// kdfSlots bounds the key derivations running at once, each using up to maxBackupMemory
+var kdfSlots = make(chan struct{}, 4)
+
+// EncryptNoteBackup returns the backup file of note of the vault app appId encrypted with
+// passphrase
+func EncryptNoteBackup(note *Note, appId uint64, passphrase string) ([]byte, error) {
+	if len(passphrase) < MinBackupPassphraseLength {
+		return nil, fmt.Errorf("the passphrase must be at least %d characters long",
+			MinBackupPassphraseLength)
+	}
+	header := make([]byte, 0, backupHeaderSize)
+	header = append(header, backupMagic...)
+	header = append(header, backupVersion)
+	header = binary.BigEndian.AppendUint32(header, backupTime)
+	header = binary.BigEndian.AppendUint32(header, backupMemory)
+	header = append(header, backupThreads)
+	saltAndNonce := make([]byte, backupSaltSize+chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(saltAndNonce); err != nil {
+		return nil, fmt.Errorf("error generating salt and nonce: %v", err)
+	}
+	header = append(header, saltAndNonce...)
+
+	salt := saltAndNonce[:backupSaltSize]
+	nonce := saltAndNonce[backupSaltSize:]
+	aead, err := backupCipher(passphrase, salt, backupTime, backupMemory, backupThreads)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, []byte(note.Text(appId)), header), nil
+}
+
+// DecryptNoteBackup returns the note of the vault app appId in the backup file encrypted
+// with passphrase
+func DecryptNoteBackup(backup []byte, appId uint64, passphrase string) (*Note, error) {
+	if len(backup) > MaxNoteBackupSize {
+		return nil, errors.New("not a note backup file, too large")
+	}
+	if len(backup) < backupHeaderSize || !bytes.HasPrefix(backup, []byte(backupMagic)) {
+		return nil, errors.New("not a note backup file")
+	}
+	header := backup[:backupHeaderSize]
+	b := header[len(backupMagic):]
+	if version := b[0]; version != backupVersion {
+		return nil, fmt.Errorf("unsupported note backup version %d", version)
+	}
+	iterations := binary.BigEndian.Uint32(b[1:5])
+	memory := binary.BigEndian.Uint32(b[5:9])
+	threads := b[9]
+	if iterations < 1 || iterations > maxBackupTime || memory < 8*uint32(threads) ||
+		memory > maxBackupMemory || threads < 1 {
+		return nil, errors.New("invalid note backup key derivation parameters")
+	}
+	salt := b[10 : 10+backupSaltSize]
+	nonce := b[10+backupSaltSize:]
+
+	aead, err := backupCipher(passphrase, salt, iterations, memory, threads)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, backup[backupHeaderSize:], header)
+	if err != nil {
+		return nil, ErrBackupPassphrase
+	}
+	note, err := Input(plaintext).ToNote(appId)
+	if err != nil {
+		return nil, fmt.Errorf("invalid note in backup file: %v", err)
+	}
+	return note, nil
+}
+
+// backupCipher returns the cipher with the key derived from passphrase
+func backupCipher(passphrase string, salt []byte, iterations, memory uint32,
+	threads uint8) (cipher.AEAD, error) {
+	kdfSlots <- struct{}{}
+	key := argon2.IDKey([]byte(passphrase), salt, iterations, memory, threads,
+		chacha20poly1305.KeySize)
+	<-kdfSlots
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %v", err)
+	}
+	return aead, nil
+}
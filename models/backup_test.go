@@ -0,0 +1,90 @@
+package models
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+func TestNoteBackupRoundTrip(t *testing.T) {
+	note := testNote(7_654_321)
+	backup, err := EncryptNoteBackup(note, 42, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backup) > MaxNoteBackupSize {
+		t.Errorf("backup of %d bytes above the maximum %d", len(backup), MaxNoteBackupSize)
+	}
+	decrypted, err := DecryptNoteBackup(backup, 42, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Amount != note.Amount || decrypted.K != note.K || decrypted.R != note.R {
+		t.Errorf("decrypted %+v, expected %+v", decrypted, note)
+	}
+
+	again, err := EncryptNoteBackup(note, 42, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again[:backupHeaderSize]) == string(backup[:backupHeaderSize]) {
+		t.Errorf("two backups with the same salt and nonce")
+	}
+}
+
+func TestNoteBackupErrors(t *testing.T) {
+	backup, err := EncryptNoteBackup(testNote(1_000_000), 42, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// altered returns a copy of the backup changed by f
+	altered := func(f func(b []byte)) []byte {
+		b := append([]byte(nil), backup...)
+		f(b)
+		return b
+	}
+	versionAt := len(backupMagic)
+
+	for _, test := range []struct {
+		name       string
+		backup     []byte
+		appId      uint64
+		passphrase string
+		err        string // a part of the error expected
+	}{
+		{"wrong passphrase", backup, 42, testPassphrase + "!", ErrBackupPassphrase.Error()},
+		{"wrong app id", backup, 43, testPassphrase, "not this vault app 43"},
+		{"altered ciphertext", altered(func(b []byte) { b[len(b)-1] ^= 1 }), 42,
+			testPassphrase, ErrBackupPassphrase.Error()},
+		{"altered salt", altered(func(b []byte) { b[versionAt+10] ^= 1 }), 42,
+			testPassphrase, ErrBackupPassphrase.Error()},
+		{"unsupported version", altered(func(b []byte) { b[versionAt] = 2 }), 42,
+			testPassphrase, "unsupported note backup version 2"},
+		{"excessive memory", altered(func(b []byte) {
+			binary.BigEndian.PutUint32(b[versionAt+5:], maxBackupMemory+1)
+		}), 42, testPassphrase, "invalid note backup key derivation parameters"},
+		{"no threads", altered(func(b []byte) { b[versionAt+9] = 0 }), 42,
+			testPassphrase, "invalid note backup key derivation parameters"},
+		{"wrong magic", altered(func(b []byte) { b[0] = 'X' }), 42, testPassphrase,
+			"not a note backup file"},
+		{"truncated", backup[:backupHeaderSize-1], 42, testPassphrase,
+			"not a note backup file"},
+		{"too large", make([]byte, MaxNoteBackupSize+1), 42, testPassphrase, "too large"},
+	} {
+		_, err := DecryptNoteBackup(test.backup, test.appId, test.passphrase)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+		}
+	}
+
+	if _, err := DecryptNoteBackup(backup, 42, testPassphrase+"!"); !errors.Is(err,
+		ErrBackupPassphrase) {
+		t.Errorf("wrong passphrase: got %v, expected ErrBackupPassphrase", err)
+	}
+	if _, err := EncryptNoteBackup(testNote(1), 42, "short"); err == nil {
+		t.Errorf("expected an error encrypting with a short passphrase")
+	}
+}
@@ -0,0 +1,194 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Bech32m encoding (BIP 350), used for the secret notes.
+// Like Lightning invoices we lift the 90 characters limit of BIP 173. Longer strings lose
+// the guarantee of detecting any error in up to 4 characters, but up to 1023 characters
+// any single mistyped character is still detected and can be located, and other errors
+// go undetected with a probability of about 1 in a billion
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+const (
+	bech32mConst       = 0x2bc830a3
+	bech32ChecksumSize = 6
+	bech32Separator    = '1'
+	bech32MaxLength    = 1023
+)
+
+var bech32Generator = [5]uint32{
+	0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// errBech32Checksum is returned decoding a bech32m string with an invalid checksum
+var errBech32Checksum = errors.New("invalid checksum, check for typos")
+
+// bech32TypoError is returned decoding a bech32m string with an invalid checksum, when
+// changing a single character would make it valid
+type bech32TypoError struct {
+	Position int // the position of the character, starting from 1
+}
+
+func (e *bech32TypoError) Error() string {
+	return fmt.Sprintf("invalid checksum, character %d is probably mistyped", e.Position)
+}
+
+func (e *bech32TypoError) Unwrap() error {
+	return errBech32Checksum
+}
+
+// bech32mEncode encodes data with the human readable part hrp
+func bech32mEncode(hrp string, data []byte) string {
+	values := convertBits(data, 8, 5, true)
+	checksum := bech32mChecksum(hrp, values)
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte(bech32Separator)
+	for _, v := range append(values, checksum...) {
+		sb.WriteByte(bech32Charset[v])
+	}
+	return sb.String()
+}
+
+// bech32mDecode decodes a bech32m string, returning its human readable part in lower case
+// and its data. The errors report the position of invalid characters, starting from 1
+func bech32mDecode(s string) (hrp string, data []byte, err error) {
+	if len(s) > bech32MaxLength {
+		return "", nil, fmt.Errorf("too long, %d characters", len(s))
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed upper and lower case characters")
+	}
+	s = strings.ToLower(s)
+	sep := strings.LastIndexByte(s, bech32Separator)
+	if sep < 1 {
+		return "", nil, errors.New("missing prefix")
+	}
+	if len(s)-sep-1 < bech32ChecksumSize {
+		return "", nil, errors.New("too short")
+	}
+	hrp = s[:sep]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, fmt.Errorf("invalid character at position %d", i+1)
+		}
+	}
+	values := make([]byte, len(s)-sep-1)
+	for i := range values {
+		c := s[sep+1+i]
+		v := strings.IndexByte(bech32Charset, c)
+		if v == -1 {
+			return "", nil, fmt.Errorf("invalid character %q at position %d", c, sep+2+i)
+		}
+		values[i] = byte(v)
+	}
+	if !bech32mVerify(hrp, values) {
+		if i := bech32mFindTypo(hrp, values); i >= 0 {
+			return "", nil, &bech32TypoError{Position: sep + 2 + i}
+		}
+		return "", nil, errBech32Checksum
+	}
+	data, err = convertBitsStrict(values[:len(values)-bech32ChecksumSize], 5, 8)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
+
+// bech32mFindTypo returns the index of the single value in values that, changed, makes
+// the checksum valid, or -1 if there is none
+func bech32mFindTypo(hrp string, values []byte) int {
+	candidate := make([]byte, len(values))
+	for i := range values {
+		copy(candidate, values)
+		for v := range byte(len(bech32Charset)) {
+			if v == values[i] {
+				continue
+			}
+			candidate[i] = v
+			if bech32mVerify(hrp, candidate) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range bech32Generator {
+			if (top>>i)&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	expanded := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+func bech32mChecksum(hrp string, values []byte) []byte {
+	enc := append(bech32HrpExpand(hrp), values...)
+	enc = append(enc, make([]byte, bech32ChecksumSize)...)
+	polymod := bech32Polymod(enc) ^ bech32mConst
+	checksum := make([]byte, bech32ChecksumSize)
+	for i := range checksum {
+		checksum[i] = byte(polymod>>(5*(5-i))) & 31
+	}
+	return checksum
+}
+
+func bech32mVerify(hrp string, values []byte) bool {
+	return bech32Polymod(append(bech32HrpExpand(hrp), values...)) == bech32mConst
+}
+
+// convertBits regroups data from fromBits to toBits bits per value, padding the last
+// value with zeros if pad is true
+func convertBits(data []byte, fromBits, toBits uint, pad bool) []byte {
+	var acc uint32
+	var bits uint
+	maxValue := uint32(1)<<toBits - 1
+	out := make([]byte, 0, (uint(len(data))*fromBits+toBits-1)/toBits)
+	for _, b := range data {
+		acc = acc<<fromBits | uint32(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits&maxValue))
+		}
+	}
+	if pad && bits > 0 {
+		out = append(out, byte(acc<<(toBits-bits)&maxValue))
+	}
+	return out
+}
+
+// convertBitsStrict is like convertBits without padding, failing if the leftover bits
+// are more than a padding or not zero
+func convertBitsStrict(data []byte, fromBits, toBits uint) ([]byte, error) {
+	leftover := uint(len(data)) * fromBits % toBits
+	if leftover >= fromBits {
+		return nil, errors.New("invalid padding")
+	}
+	if leftover > 0 && data[len(data)-1]&(1<<leftover-1) != 0 {
+		return nil, errors.New("non zero padding")
+	}
+	return convertBits(data, fromBits, toBits, false), nil
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+)
+
+// testNote returns a note with fixed nonces
+func testNote(amount uint64) *Note {
+	var k, r [config.RandomNonceByteSize]byte
+	for i := range k {
+		k[i], r[i] = byte(i), byte(255-i)
+	}
+	return NewNote(amount, k, r)
+}
+
+// the valid bech32m strings of the BIP 350 test vectors, whose data is not always whole
+// bytes so only their checksum is verified
+func TestBech32mChecksumValid(t *testing.T) {
+	for _, s := range []string{
+		"A1LQFN3A",
+		"a1lqfn3a",
+		"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6",
+		"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx",
+		"11llllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllludsr8",
+		"split1checkupstagehandshakeupstreamerranterredcaperredlc445v",
+		"?1v759aa",
+	} {
+		s = strings.ToLower(s)
+		sep := strings.LastIndexByte(s, bech32Separator)
+		values := make([]byte, len(s)-sep-1)
+		for i := range values {
+			values[i] = byte(strings.IndexByte(bech32Charset, s[sep+1+i]))
+		}
+		if !bech32mVerify(s[:sep], values) {
+			t.Errorf("%s: invalid checksum", s)
+		}
+	}
+}
+
+func TestBech32mRoundTrip(t *testing.T) {
+	for _, data := range [][]byte{{}, {0}, {1, 2, 3}, bytes.Repeat([]byte{0xff}, 81)} {
+		s := bech32mEncode("test", data)
+		hrp, decoded, err := bech32mDecode(s)
+		if err != nil || hrp != "test" || !bytes.Equal(decoded, data) {
+			t.Errorf("%x encoded as %s decoded as %s %x, %v", data, s, hrp, decoded, err)
+		}
+		if _, decoded, err := bech32mDecode(strings.ToUpper(s)); err != nil ||
+			!bytes.Equal(decoded, data) {
+			t.Errorf("%x upper case decoded as %x, %v", data, decoded, err)
+		}
+	}
+}
+
+func TestBech32mDecodeInvalid(t *testing.T) {
+	valid := bech32mEncode("test", []byte{1, 2, 3})
+	for _, test := range []struct {
+		name, s string
+	}{
+		{"mixed case", "Test" + valid[4:]},
+		{"no separator", "testqqqqqqqq"},
+		{"no prefix", valid[4:]},
+		{"too short", "test1qqqqq"},
+		{"invalid character", valid[:6] + "b" + valid[7:]},
+		{"too long", "test1" + strings.Repeat("q", bech32MaxLength)},
+		{"two typos", valid[:5] + swapChar(valid[5]) + swapChar(valid[6]) + valid[7:]},
+	} {
+		if _, _, err := bech32mDecode(test.s); err == nil {
+			t.Errorf("%s: expected an error decoding %s", test.name, test.s)
+		}
+	}
+}
+
+// swapChar returns a bech32 character other than c
+func swapChar(c byte) string {
+	if c == 'q' {
+		return "p"
+	}
+	return "q"
+}
+
+func TestBech32mTypoPosition(t *testing.T) {
+	valid := bech32mEncode("test", []byte{1, 2, 3, 4, 5})
+	for i := len("test1"); i < len(valid); i++ {
+		s := valid[:i] + swapChar(valid[i]) + valid[i+1:]
+		_, _, err := bech32mDecode(s)
+		var typo *bech32TypoError
+		if !errors.As(err, &typo) || typo.Position != i+1 {
+			t.Errorf("character %d mistyped: got %v", i+1, err)
+		}
+		if !errors.Is(err, errBech32Checksum) {
+			t.Errorf("character %d mistyped: %v is not a checksum error", i+1, err)
+		}
+	}
+}
+
+func TestNoteTextRoundTrip(t *testing.T) {
+	note := testNote(123_456_789)
+	text := note.Text(42)
+	if len(text) != noteTextLength || !strings.HasPrefix(text, noteHrp+"1") {
+		t.Fatalf("unexpected note text %s", text)
+	}
+	for _, appId := range []uint64{42, 0} {
+		decoded, err := Input(text).ToNote(appId)
+		if err != nil {
+			t.Fatalf("app %d: %v", appId, err)
+		}
+		if decoded.Amount != note.Amount || decoded.K != note.K || decoded.R != note.R {
+			t.Errorf("app %d: decoded %+v, expected %+v", appId, decoded, note)
+		}
+	}
+	if _, err := Input("  " + strings.ToUpper(text) + "\n").ToNote(42); err != nil {
+		t.Errorf("upper case note with spaces: %v", err)
+	}
+}
+
+func TestNoteTextErrors(t *testing.T) {
+	text := testNote(1_000_000).Text(42)
+
+	_, err := Input(text).ToNote(43)
+	if err == nil || !strings.Contains(err.Error(), "not this vault app 43") {
+		t.Errorf("wrong app id: got %v", err)
+	}
+
+	typo := text[:20] + swapChar(text[20]) + text[21:]
+	_, err = Input(typo).ToNote(42)
+	var typoErr *bech32TypoError
+	if !errors.As(err, &typoErr) || typoErr.Position != 21 {
+		t.Errorf("mistyped character 21: got %v", err)
+	}
+
+	payload := make([]byte, 0, notePayloadSize)
+	payload = append(payload, noteVersion+1)
+	payload = binary.BigEndian.AppendUint64(payload, 42)
+	payload = append(payload, make([]byte, notePayloadSize-len(payload))...)
+	_, err = Input(bech32mEncode(noteHrp, payload)).ToNote(42)
+	if err == nil || !strings.Contains(err.Error(), "unsupported secret note version") {
+		t.Errorf("unsupported version: got %v", err)
+	}
+
+	_, err = Input("hvnotx1" + text[len(noteHrp)+1:]).ToNote(42)
+	if err == nil || !strings.Contains(err.Error(), "invalid prefix") {
+		t.Errorf("wrong prefix: got %v", err)
+	}
+
+	_, err = Input(text[:len(text)-1]).ToNote(42)
+	if err == nil || !strings.Contains(err.Error(), "invalid secret note length") {
+		t.Errorf("truncated note: got %v", err)
+	}
+}
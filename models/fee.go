@@ -0,0 +1,86 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+)
+
+// CalculateWithdrawalFee calculates the withdrawal fee for a given amount with the
+// config.Fees policy. The fee grows with the amount, so that a note can always pay the
+// fee of a smaller withdrawal
+func CalculateWithdrawalFee(amount uint64) uint64 {
+	p := config.Fees
+	fee := p.Flat
+	for i, tier := range p.Tiers {
+		if amount <= tier.From {
+			break
+		}
+		end := amount
+		if i+1 < len(p.Tiers) && p.Tiers[i+1].From < amount {
+			end = p.Tiers[i+1].From
+		}
+		fee += bpsOf(end-tier.From, tier.Bps)
+	}
+	fee = max(fee, MinWithdrawalFee())
+	if p.Max != 0 {
+		fee = min(fee, p.Max)
+	}
+	return fee
+}
+
+// MinWithdrawalFee returns the minimum fee of a withdrawal, which covers at least the
+// network fees
+func MinWithdrawalFee() uint64 {
+	return max(config.Fees.Min, config.WithdrawalMinFee)
+}
+
+// bpsOf returns bps basis points of amount, rounded down, without overflowing
+func bpsOf(amount, bps uint64) uint64 {
+	return amount/10_000*bps + amount%10_000*bps/10_000
+}
+
+// FormatBps formats basis points as a percent without trailing zeros, e.g. 50 as 0.5
+func FormatBps(bps uint64) string {
+	percent := fmt.Sprintf("%d.%02d", bps/100, bps%100)
+	return strings.TrimSuffix(strings.TrimRight(percent, "0"), ".")
+}
+
+// FeePolicyText describes the config.Fees policy to the user, e.g.
+// "0.5% of the amount, minimum 0.0753 algo, maximum 10 algo"
+func FeePolicyText() string {
+	p := config.Fees
+	algo := func(microalgos uint64) string {
+		return MicroAlgosToAlgoString(microalgos) + " algo"
+	}
+	var parts []string
+	if p.Flat > 0 {
+		parts = append(parts, algo(p.Flat))
+	}
+	for i, tier := range p.Tiers {
+		if tier.Bps == 0 {
+			continue
+		}
+		rate := FormatBps(tier.Bps) + "%"
+		switch {
+		case len(p.Tiers) == 1:
+			parts = append(parts, rate+" of the amount")
+		case i == len(p.Tiers)-1:
+			parts = append(parts, rate+" above "+algo(tier.From))
+		case tier.From == 0:
+			parts = append(parts, rate+" of the first "+algo(p.Tiers[i+1].From))
+		default:
+			parts = append(parts, rate+" from "+MicroAlgosToAlgoString(tier.From)+
+				" to "+algo(p.Tiers[i+1].From))
+		}
+	}
+	if len(parts) == 0 {
+		return algo(MinWithdrawalFee())
+	}
+	text := strings.Join(parts, " plus ") + ", minimum " + algo(MinWithdrawalFee())
+	if p.Max != 0 {
+		text += ", maximum " + algo(p.Max)
+	}
+	return text
+}
@@ -51,26 +51,119 @@ func (input Input) ToAddress() (Address, error) {
 	return Address(address.String()), nil
 }
 
-// toNote converts an input to a Note
-// Input is expected to be a hex-encoded string of 70 bytes (140 hex characters),
-// 8 bytes for the amount, 31 bytes for K, 31 bytes for R
-// 31 bytes is given from the RandomNonceByteSize constant in package config
-func (input Input) ToNote() (*Note, error) {
-	amountByteSize := 8
-	nonceByteSize := config.RandomNonceByteSize
-	amountAndNonceSize := amountByteSize + nonceByteSize
+// ToNote converts an input to a Note of the vault app appId, or of any app if appId is 0.
+// Input is expected to be a secret note as encoded by Note.Text, or a legacy note: a
+// hex-encoded string of 70 bytes (140 hex characters), 8 bytes for the amount, 31 bytes
+// for K, 31 bytes for R. 31 bytes is given from the RandomNonceByteSize constant in
+// package config.
+// The errors are meant for the user, reporting the position of mistyped characters
+func (input Input) ToNote(appId uint64) (*Note, error) {
+	text := strings.TrimSpace(string(input))
+	prefix := noteHrp + string(bech32Separator)
+	switch {
+	case strings.HasPrefix(strings.ToLower(text), prefix):
+		return noteFromText(text, appId)
+	case isHex(text):
+		return legacyNoteFromText(text)
+	case len(text) > len(prefix) && text[len(noteHrp)] == bech32Separator:
+		return nil, fmt.Errorf("invalid prefix %q, secret notes start with %q",
+			text[:len(prefix)], prefix)
+	case len(text) == legacyNoteTextLength:
+		return legacyNoteFromText(text)
+	default:
+		return nil, errors.New("invalid secret note length")
+	}
+}
 
-	if len(input) != 140 {
+// noteFromText decodes a secret note encoded by Note.Text, checking it is bound to appId
+// unless appId is 0
+func noteFromText(text string, appId uint64) (*Note, error) {
+	if len(text) != noteTextLength {
+		return nil, fmt.Errorf("invalid secret note length, %d characters instead of %d",
+			len(text), noteTextLength)
+	}
+	_, payload, err := bech32mDecode(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 {
+		return nil, errors.New("invalid secret note length")
+	}
+	if payload[0] != noteVersion {
+		return nil, fmt.Errorf("unsupported secret note version %d", payload[0])
+	}
+	if len(payload) != notePayloadSize {
 		return nil, errors.New("invalid secret note length")
 	}
-	decoded, err := hex.DecodeString(string(input))
+	noteAppId := binary.BigEndian.Uint64(payload[1:9])
+	if appId != 0 && noteAppId != appId {
+		return nil, fmt.Errorf("the secret note is for app %d, not this vault app %d",
+			noteAppId, appId)
+	}
+	return decodeNoteFields(payload[9:]), nil
+}
+
+// legacyNoteFromText decodes a legacy hex encoded note
+func legacyNoteFromText(text string) (*Note, error) {
+	if len(text) != legacyNoteTextLength {
+		return nil, fmt.Errorf("invalid secret note length, %d characters instead of %d",
+			len(text), legacyNoteTextLength)
+	}
+	for i := 0; i < len(text); i++ {
+		if !isHex(text[i : i+1]) {
+			return nil, fmt.Errorf("invalid character %q at position %d", text[i], i+1)
+		}
+	}
+	decoded, err := hex.DecodeString(text)
 	if err != nil {
 		return nil, fmt.Errorf("error decoding hex string: %v", err)
 	}
-	amount := decoded[:amountByteSize]
+	return decodeNoteFields(decoded), nil
+}
+
+// decodeNoteFields decodes the amount, K and R of a note
+func decodeNoteFields(b []byte) *Note {
+	amountByteSize := 8
+	amountAndNonceSize := amountByteSize + config.RandomNonceByteSize
 	var k, r [config.RandomNonceByteSize]byte
-	copy(k[:], decoded[amountByteSize:amountAndNonceSize])
-	copy(r[:], decoded[amountAndNonceSize:])
-	note := NewNote(binary.BigEndian.Uint64(amount), k, r)
-	return note, nil
+	copy(k[:], b[amountByteSize:amountAndNonceSize])
+	copy(r[:], b[amountAndNonceSize:])
+	return NewNote(binary.BigEndian.Uint64(b[:amountByteSize]), k, r)
+}
+
+// isHex reports whether s is not empty and only has hex digits
+func isHex(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789abcdefABCDEF") == ""
+}
+
+// ToRecipients converts an input to a list of withdrawal recipients.
+// Input is expected to have one recipient per line, as an address and an algo amount
+// separated by spaces, tabs or a comma. Empty lines are ignored
+func (input Input) ToRecipients() ([]Recipient, error) {
+	var recipients []Recipient
+	for i, line := range strings.Split(string(input), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ','
+		})
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected an address and an amount", i+1)
+		}
+		address, err := Input(fields[0]).ToAddress()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		amount, err := Input(fields[1]).ToAmount()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		recipients = append(recipients, Recipient{Address: address, Amount: amount})
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	return recipients, nil
 }
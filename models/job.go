@@ -0,0 +1,88 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// JobKind is the operation run by a job
+type JobKind string
+
+const (
+	DepositJob    JobKind = "deposit"
+	WithdrawalJob JobKind = "withdrawal"
+	// BatchWithdrawalJob is a chain of withdrawals from one note to several recipients
+	BatchWithdrawalJob JobKind = "batch-withdrawal"
+)
+
+// JobState is the progress of a job
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"    // waiting for a prover worker
+	JobProving   JobState = "proving"   // generating the zk proof
+	JobSubmitted JobState = "submitted" // sent to the network, waiting for confirmation
+	JobConfirmed JobState = "confirmed"
+	JobFailed    JobState = "failed"
+)
+
+// Done reports whether the job has finished, successfully or not
+func (s JobState) Done() bool {
+	return s == JobConfirmed || s == JobFailed
+}
+
+// Job is a deposit or withdrawal sent to the network in the background, which the user
+// can follow by its id
+type Job struct {
+	Id   string
+	Kind JobKind
+	// Instance is the server instance running the job, the only one failing it if it is
+	// interrupted, since instances may share the jobs
+	Instance      string
+	State         JobState
+	QueuePosition int    // position in the prover queue while JobQueued
+	TxnId         string // id of the first group txn, once submitted
+	// LeafIndex is the leaf index of the note inserted, once confirmed.
+	// It is EmptyLeafIndex for a withdrawal with no change
+	LeafIndex  uint64
+	ErrorType  string // if JobFailed, the type of error sending the transactions
+	Message    string // if JobFailed, the error message for the user
+	RetryAfter int    // if JobFailed because the server was busy, seconds to wait
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// jobIdByteSize is the size of the random job ids, which are hex encoded.
+// Knowing a job id is enough to follow it, so they must not be guessable
+const jobIdByteSize = 16
+
+// NewJob returns a new job of the given kind run by instance, in the JobQueued state for
+// a withdrawal and JobSubmitted for a deposit, which has no proof to generate
+func NewJob(kind JobKind, instance string) (*Job, error) {
+	id := make([]byte, jobIdByteSize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("error generating job id: %v", err)
+	}
+	state := JobQueued
+	if kind == DepositJob {
+		state = JobSubmitted
+	}
+	now := time.Now().UTC()
+	return &Job{
+		Id:        hex.EncodeToString(id),
+		Kind:      kind,
+		Instance:  instance,
+		State:     state,
+		LeafIndex: EmptyLeafIndex,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+// IsJobId reports whether s is formatted like a job id
+func IsJobId(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == jobIdByteSize && hex.EncodeToString(b) == s
+}
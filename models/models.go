@@ -4,6 +4,7 @@ package models
 import (
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"log"
 	"math"
 
@@ -22,6 +23,125 @@ type WithdrawalData struct {
 	Address    Address
 	FromNote   *Note
 	ChangeNote *Note
+	Root       []byte // merkle root the withdrawal proof is built against
+	NoChange   bool   // if true the whole note is withdrawn and no change note is inserted
+}
+
+// NewNoChangeWithdrawal returns the data to withdraw the whole fromNote to address,
+// without inserting a change note in the tree.
+// The amount is the note's maximum withdrawal amount and the fee is its fee plus the dust,
+// the few microalgos left that no larger amount can take since its fee would grow past
+// them, so that amount plus fee equals the note amount. The change note required by the
+// proof has zero amount and is never inserted in the tree nor given to the user
+func NewNoChangeWithdrawal(address Address, fromNote *Note) (*WithdrawalData, error) {
+	amount := fromNote.MaxWithdrawalAmount()
+	if amount.Microalgos == 0 {
+		return nil, fmt.Errorf("note amount too small")
+	}
+	changeNote, err := GenerateNote(0)
+	if err != nil {
+		return nil, fmt.Errorf("error generating note: %v", err)
+	}
+	fee := CalculateWithdrawalFee(amount.Microalgos)
+	dust := fromNote.Amount - amount.Microalgos - fee
+	return &WithdrawalData{
+		Amount:     amount,
+		Fee:        NewAmount(fee + dust),
+		Address:    address,
+		FromNote:   fromNote,
+		ChangeNote: changeNote,
+		NoChange:   true,
+	}, nil
+}
+
+// Recipient is the recipient of one of the withdrawals of a batch withdrawal
+type Recipient struct {
+	Address Address
+	Amount  Amount
+}
+
+// BatchWithdrawalData is a withdrawal from one note to several recipients, made as a chain
+// of withdrawals each spending the change note of the previous one
+type BatchWithdrawalData struct {
+	FromNote    *Note
+	Withdrawals []*WithdrawalData
+}
+
+// NewBatchWithdrawal returns the chain of withdrawals to pay the recipients from fromNote,
+// generating a change note for each
+func NewBatchWithdrawal(fromNote *Note, recipients []Recipient,
+) (*BatchWithdrawalData, error) {
+	b := &BatchWithdrawalData{FromNote: fromNote}
+	note := fromNote
+	for _, r := range recipients {
+		changeNote, err := GenerateChangeNote(r.Amount, note)
+		if err != nil {
+			return nil, err
+		}
+		b.Withdrawals = append(b.Withdrawals, &WithdrawalData{
+			Amount:     r.Amount,
+			Fee:        r.Amount.Fee(),
+			Address:    r.Address,
+			FromNote:   note,
+			ChangeNote: changeNote,
+		})
+		note = changeNote
+	}
+	return b, nil
+}
+
+// ChainBatchWithdrawal returns the chain of withdrawals to pay the recipients from
+// fromNote using the given change notes, one per recipient.
+// It checks each change note has the amount left after the corresponding withdrawal
+func ChainBatchWithdrawal(fromNote *Note, recipients []Recipient, changeNotes []*Note,
+) (*BatchWithdrawalData, error) {
+	if len(recipients) != len(changeNotes) {
+		return nil, fmt.Errorf("expected %d change notes, got %d", len(recipients),
+			len(changeNotes))
+	}
+	b := &BatchWithdrawalData{FromNote: fromNote}
+	note := fromNote
+	for i, r := range recipients {
+		deduction := r.Amount.Microalgos + CalculateWithdrawalFee(r.Amount.Microalgos)
+		if deduction < r.Amount.Microalgos || note.Amount < deduction {
+			return nil, fmt.Errorf("note amount too small")
+		}
+		if changeNotes[i].Amount != note.Amount-deduction {
+			return nil, fmt.Errorf("change note %d has wrong amount", i+1)
+		}
+		b.Withdrawals = append(b.Withdrawals, &WithdrawalData{
+			Amount:     r.Amount,
+			Fee:        r.Amount.Fee(),
+			Address:    r.Address,
+			FromNote:   note,
+			ChangeNote: changeNotes[i],
+		})
+		note = changeNotes[i]
+	}
+	return b, nil
+}
+
+// ChangeNote returns the change note of the last withdrawal of the batch
+func (b *BatchWithdrawalData) ChangeNote() *Note {
+	return b.Withdrawals[len(b.Withdrawals)-1].ChangeNote
+}
+
+// Total returns the sum of the amounts withdrawn
+func (b *BatchWithdrawalData) Total() Amount {
+	var total uint64
+	for _, w := range b.Withdrawals {
+		total += w.Amount.Microalgos
+	}
+	return NewAmount(total)
+}
+
+// TotalFee returns the sum of the fees of the withdrawals
+func (b *BatchWithdrawalData) TotalFee() Amount {
+	var total uint64
+	for _, w := range b.Withdrawals {
+		total += w.Fee.Microalgos
+	}
+	return NewAmount(total)
 }
 
 type DepositData struct {
@@ -37,14 +157,20 @@ func (d *DepositData) TxnsJson() string {
 	return json
 }
 
-// EncodeTxns encodes each transactions to msgpack, then to base64 string, then packs them
-// into an array and encodes them to JSON string
-func EncodeTxnsToJson(txns []types.Transaction) string {
+// EncodeTxnsToBase64 encodes each transactions to msgpack, then to base64 string
+func EncodeTxnsToBase64(txns []types.Transaction) []string {
 	var base64Txns []string
 	for _, txn := range txns {
 		msgpackTxn := msgpack.Encode(txn)
 		base64Txns = append(base64Txns, base64.StdEncoding.EncodeToString(msgpackTxn))
 	}
+	return base64Txns
+}
+
+// EncodeTxns encodes each transactions to msgpack, then to base64 string, then packs them
+// into an array and encodes them to JSON string
+func EncodeTxnsToJson(txns []types.Transaction) string {
+	base64Txns := EncodeTxnsToBase64(txns)
 	jsonData, err := json.Marshal(base64Txns)
 	// should not happen
 	if err != nil {
@@ -8,6 +8,20 @@ import (
 	"github.com/giuliop/HermesVault-frontend/config"
 )
 
+// Secret notes are encoded in bech32m with the noteHrp prefix and a payload of the format
+// version, the app id, the amount, K and R. The app id binds the note to the vault app,
+// and so to its network, and the checksum catches mistyped characters.
+// Legacy notes are the hex encoding of the amount, K and R only; they are still accepted
+// as input but no longer issued
+const (
+	noteHrp     = "hvnote"
+	noteVersion = 1
+
+	notePayloadSize      = 1 + 8 + 8 + 2*config.RandomNonceByteSize
+	noteTextLength       = len(noteHrp) + 1 + (notePayloadSize*8+4)/5 + bech32ChecksumSize
+	legacyNoteTextLength = 2 * (8 + 2*config.RandomNonceByteSize)
+)
+
 type Note struct {
 	Amount    uint64
 	K         [config.RandomNonceByteSize]byte
@@ -36,8 +50,15 @@ func NewNote(amount uint64, k, r [config.RandomNonceByteSize]byte) *Note {
 	}
 }
 
-func (n *Note) Text() string {
-	return fmt.Sprintf("%016x%x%x", n.Amount, n.K, n.R)
+// Text returns the secret note encoding of n, bound to the vault app appId
+func (n *Note) Text(appId uint64) string {
+	payload := make([]byte, 0, notePayloadSize)
+	payload = append(payload, noteVersion)
+	payload = binary.BigEndian.AppendUint64(payload, appId)
+	payload = binary.BigEndian.AppendUint64(payload, n.Amount)
+	payload = append(payload, n.K[:]...)
+	payload = append(payload, n.R[:]...)
+	return bech32mEncode(noteHrp, payload)
 }
 
 func (n *Note) Nullifier() []byte {
@@ -57,18 +78,53 @@ func (n *Note) LeafValue() []byte {
 	return h
 }
 
+// MaxWithdrawalAmount returns the largest amount that can be withdrawn from the note,
+// the largest x with x plus its fee not above the note amount, 0 if the note cannot pay
+// the fee of any withdrawal.
+// The fee grows with the amount, so x plus its fee does too and x is found by binary search
 func (n *Note) MaxWithdrawalAmount() Amount {
-	fee := CalculateWithdrawalFee(n.Amount)
-	if n.Amount <= fee {
-		return NewAmount(0)
+	lo, hi := uint64(0), n.Amount // the answer is in [lo, hi]
+	for lo < hi {
+		mid := hi - (hi-lo)/2
+		if fee := CalculateWithdrawalFee(mid); fee <= n.Amount-mid {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
 	}
-	return NewAmount(n.Amount - fee)
+	return NewAmount(lo)
 }
 
 func (n *Note) AmountAlgoString() string {
 	return MicroAlgosToAlgoString(n.Amount)
 }
 
+// NoteState is where a secret note stands in the vault
+type NoteState string
+
+const (
+	NoteUnknown NoteState = "unknown" // not in the tree nor waiting to be confirmed
+	NotePending NoteState = "pending" // its transactions have not been confirmed yet
+	NoteInTree  NoteState = "in_tree" // in the tree and not spent, it can be withdrawn
+	NoteSpent   NoteState = "spent"   // its nullifier is onchain
+)
+
+// NoteStatus is the status of a secret note in the vault
+type NoteStatus struct {
+	State         NoteState
+	Amount        Amount
+	Commitment    []byte
+	LeafIndex     uint64 // EmptyLeafIndex if the note is not in the tree
+	Spent         bool   // the nullifier box of the note exists onchain
+	Unconfirmed   bool   // registered as unconfirmed by the frontend
+	MaxWithdrawal Amount
+}
+
+// InTree reports whether the note is in the merkle tree
+func (s *NoteStatus) InTree() bool {
+	return s.LeafIndex != EmptyLeafIndex
+}
+
 // generateDepositNote generates a new deposit note for the change amount after a withdrawal
 func GenerateChangeNote(withdrawalAmount Amount, fromNote *Note) (*Note, error) {
 	deduction := withdrawalAmount.Microalgos + CalculateWithdrawalFee(withdrawalAmount.Microalgos)
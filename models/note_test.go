@@ -0,0 +1,106 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+)
+
+// setFees sets config.Fees to p for the duration of the test
+func setFees(t *testing.T, p config.FeePolicy) {
+	old := config.Fees
+	config.Fees = p
+	t.Cleanup(func() { config.Fees = old })
+}
+
+var feePolicies = []struct {
+	name   string
+	policy config.FeePolicy
+}{
+	{"min only", config.FeePolicy{}},
+	{"percent", config.FeePolicy{Tiers: []config.FeeTier{{From: 0, Bps: 50}}}},
+	{"flat and percent", config.FeePolicy{Flat: 10_000,
+		Tiers: []config.FeeTier{{From: 0, Bps: 100}}}},
+	{"tiers", config.FeePolicy{Tiers: []config.FeeTier{{From: 0, Bps: 100},
+		{From: 100_000_000, Bps: 50}, {From: 1_000_000_000, Bps: 10}}}},
+	{"min and max", config.FeePolicy{Tiers: []config.FeeTier{{From: 0, Bps: 100}},
+		Min: 200_000, Max: 1_000_000}},
+}
+
+var noteAmounts = []uint64{0, 1, config.WithdrawalMinFee, config.WithdrawalMinFee + 1,
+	200_001, 1_000_000, 7_654_321, 100_000_000, 100_500_000, 101_010_101, 999_999_999,
+	1_000_000_000, 1_234_567_891, 50_000_000_000}
+
+func TestMaxWithdrawalAmount(t *testing.T) {
+	for _, p := range feePolicies {
+		setFees(t, p.policy)
+		for _, amount := range noteAmounts {
+			note := &Note{Amount: amount}
+			x := note.MaxWithdrawalAmount().Microalgos
+			if x > 0 && x+CalculateWithdrawalFee(x) > amount {
+				t.Errorf("%s, note %d: max %d plus fee %d is above the note", p.name,
+					amount, x, CalculateWithdrawalFee(x))
+			}
+			if next := x + 1; next <= amount && next+CalculateWithdrawalFee(next) <= amount {
+				t.Errorf("%s, note %d: max %d but %d plus fee %d fits too", p.name,
+					amount, x, next, CalculateWithdrawalFee(next))
+			}
+		}
+	}
+}
+
+func TestMaxWithdrawalAmountTooSmall(t *testing.T) {
+	setFees(t, config.FeePolicy{Min: 200_000})
+	for _, amount := range []uint64{0, 1, 200_000} {
+		note := &Note{Amount: amount}
+		if x := note.MaxWithdrawalAmount().Microalgos; x != 0 {
+			t.Errorf("note %d: max %d, expected 0", amount, x)
+		}
+	}
+	note := &Note{Amount: 200_001}
+	if x := note.MaxWithdrawalAmount().Microalgos; x != 1 {
+		t.Errorf("note 200001: max %d, expected 1", x)
+	}
+}
+
+func TestMaxWithdrawalAmountCapped(t *testing.T) {
+	setFees(t, config.FeePolicy{Tiers: []config.FeeTier{{From: 0, Bps: 100}},
+		Max: 1_000_000})
+	note := &Note{Amount: 500_000_000}
+	if x := note.MaxWithdrawalAmount().Microalgos; x != 499_000_000 {
+		t.Errorf("max %d, expected the note less the max fee", x)
+	}
+}
+
+func TestNewNoChangeWithdrawal(t *testing.T) {
+	for _, p := range feePolicies {
+		setFees(t, p.policy)
+		for _, amount := range noteAmounts {
+			note := &Note{Amount: amount}
+			w, err := NewNoChangeWithdrawal(Address("address"), note)
+			if note.MaxWithdrawalAmount().Microalgos == 0 {
+				if err == nil {
+					t.Errorf("%s, note %d: expected an error", p.name, amount)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s, note %d: %v", p.name, amount, err)
+			}
+			if w.Amount.Microalgos+w.Fee.Microalgos != amount {
+				t.Errorf("%s, note %d: amount %d plus fee %d is not the note", p.name,
+					amount, w.Amount.Microalgos, w.Fee.Microalgos)
+			}
+			if w.Amount != note.MaxWithdrawalAmount() ||
+				w.Fee.Microalgos < CalculateWithdrawalFee(w.Amount.Microalgos) {
+				t.Errorf("%s, note %d: amount %d and fee %d, expected the max amount "+
+					"and at least its fee", p.name, amount, w.Amount.Microalgos,
+					w.Fee.Microalgos)
+			}
+			if !w.NoChange || w.ChangeNote.Amount != 0 {
+				t.Errorf("%s, note %d: unexpected change note %+v", p.name, amount,
+					w.ChangeNote)
+			}
+		}
+	}
+}
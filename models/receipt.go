@@ -0,0 +1,98 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// Receipt records a confirmed deposit or withdrawal.
+// It holds no secret note unless one is added with WithNote
+type Receipt struct {
+	Kind  JobKind
+	TxnId string // id of the first group txn
+	Round uint64 // round the txns were confirmed in
+	// ConfirmedAt is the timestamp of the block of Round, zero if it could not be read
+	ConfirmedAt time.Time
+	Amount      Amount // amount deposited or withdrawn to the recipient
+	// TxnFees are the fees of the deposit txns, paid by the depositor. Zero for withdrawals
+	TxnFees Amount
+	// WithdrawalFee is the fee taken from the withdrawn note, which also pays the txn fees.
+	// Zero for deposits
+	WithdrawalFee Amount
+	Address       Address // depositor or withdrawal recipient
+	// LeafIndex is the leaf index of the note inserted.
+	// It is EmptyLeafIndex for a withdrawal with no change
+	LeafIndex  uint64
+	NoteAmount Amount // amount of the note inserted
+	Commitment []byte // commitment of the note inserted, nil if none
+	Note       string // text of the note inserted, only if added with WithNote
+}
+
+// NewDepositReceipt returns the receipt of a deposit confirmed in round, whose block has
+// timestamp confirmedAt
+func NewDepositReceipt(d *DepositData, round uint64, confirmedAt time.Time) *Receipt {
+	return &Receipt{
+		Kind:        DepositJob,
+		TxnId:       d.Note.TxnID,
+		Round:       round,
+		ConfirmedAt: confirmedAt,
+		Amount:      d.Amount,
+		TxnFees:     NewAmount(groupFee(d.Txns)),
+		Address:     d.Address,
+		LeafIndex:   d.Note.LeafIndex,
+		NoteAmount:  NewAmount(d.Note.Amount),
+		Commitment:  d.Note.Commitment(),
+	}
+}
+
+// NewWithdrawalReceipt returns the receipt of a withdrawal confirmed in round, whose block
+// has timestamp confirmedAt
+func NewWithdrawalReceipt(w *WithdrawalData, round uint64, confirmedAt time.Time) *Receipt {
+	r := &Receipt{
+		Kind:          WithdrawalJob,
+		TxnId:         w.ChangeNote.TxnID,
+		Round:         round,
+		ConfirmedAt:   confirmedAt,
+		Amount:        w.Amount,
+		WithdrawalFee: w.Fee,
+		Address:       w.Address,
+		LeafIndex:     EmptyLeafIndex,
+	}
+	if !w.NoChange {
+		r.LeafIndex = w.ChangeNote.LeafIndex
+		r.NoteAmount = NewAmount(w.ChangeNote.Amount)
+		r.Commitment = w.ChangeNote.Commitment()
+	}
+	return r
+}
+
+// HasNote reports whether a note was inserted by the receipt operation
+func (r *Receipt) HasNote() bool {
+	return r.Commitment != nil
+}
+
+// WithNote returns a copy of the receipt including the text of note of the vault app
+// appId, which must be the note inserted by the receipt operation
+func (r *Receipt) WithNote(note *Note, appId uint64) (*Receipt, error) {
+	if !r.HasNote() {
+		return nil, fmt.Errorf("no secret note was issued by this %s", r.Kind)
+	}
+	if !bytes.Equal(note.Commitment(), r.Commitment) {
+		return nil, fmt.Errorf("the note is not the one issued by this %s", r.Kind)
+	}
+	receipt := *r
+	receipt.Note = note.Text(appId)
+	return &receipt, nil
+}
+
+// groupFee returns the total fee of a txn group
+func groupFee(txns []types.Transaction) uint64 {
+	var fee uint64
+	for _, txn := range txns {
+		fee += uint64(txn.Fee)
+	}
+	return fee
+}
@@ -56,6 +56,11 @@ def save_deposit(note: Note, deposit: Deposit, root: bytes, block: int) -> None:
 
 
 def save_withdrawal(note: Note, withdrawal: Withdrawal, root: bytes, block: int) -> None:
+    """
+    Save a withdrawal and its change note.
+    A no_change withdrawal inserts no note in the tree, the app returns the index of the
+    last leaf inserted, so only the stats and the watermark are updated
+    """
     txns_table_sql = """
     INSERT INTO txns (leaf_index, commitment, txn_id, txn_type, address, amount, from_nullifier)
     VALUES (?, ?, ?, ?, ?, ?, ?)
@@ -64,33 +69,35 @@ def save_withdrawal(note: Note, withdrawal: Withdrawal, root: bytes, block: int)
     cursor = db_conn.cursor()
 
     try:
-        cursor.execute(
-            txns_table_sql,
-            (
-                note.leaf_index,
-                note.commitment,
-                note.txn_id,
-                WITHDRAWAL_TXN_TYPE,
-                withdrawal.address,
-                withdrawal.amount,
-                withdrawal.nullifier,
-            ),
-        )
+        if not withdrawal.no_change:
+            cursor.execute(
+                txns_table_sql,
+                (
+                    note.leaf_index,
+                    note.commitment,
+                    note.txn_id,
+                    WITHDRAWAL_TXN_TYPE,
+                    withdrawal.address,
+                    withdrawal.amount,
+                    withdrawal.nullifier,
+                ),
+            )
+            cursor.execute("UPDATE roots SET value = ?, leaf_count = ? WHERE id = 1",
+                           (root, note.leaf_index + 1))
+
         cursor.execute("UPDATE stats SET value = value + ? WHERE key = 'total_withdrawals'",
                        (withdrawal.amount,))
 
         cursor.execute("UPDATE stats SET value = value + ? WHERE key = 'total_fees'",
                        (withdrawal.fee,))
 
-        cursor.execute("UPDATE roots SET value = ?, leaf_count = ? WHERE id = 1",
-                          (root, note.leaf_index + 1))
-
         cursor.execute("UPDATE watermark SET value = ? WHERE id = 1", (block,))
 
         db_conn.commit()  # Commit everything at once
 
         logger.info("Saved withdrawal %s", withdrawal)
-        logger.info("Saved note %s", note)
+        if not withdrawal.no_change:
+            logger.info("Saved note %s", note)
         logger.info("Tree root %s", format_bytes(root))
         logger.info("Block %s", block)
 
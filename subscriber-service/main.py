@@ -102,7 +102,8 @@ def handle_transaction(txn: sub.SubscribedTransaction, filter_name: str) -> None
 
     if filter_name == parse.withdrawFilterName:
         accounts = txn.get("application-transaction")["accounts"]
-        commitment, address, nullifier, amount, fee = parse.withdraw_args(args, accounts)
+        commitment, address, nullifier, amount, fee, no_change = parse.withdraw_args(
+            args, accounts)
         note = Note(
             leaf_index=leaf_index,
             commitment=commitment,
@@ -114,6 +115,7 @@ def handle_transaction(txn: sub.SubscribedTransaction, filter_name: str) -> None
             nullifier=nullifier,
             amount=amount,
             fee=fee,
+            no_change=no_change,
         )
         db.retry(lambda: db.save_withdrawal(note, withdrawal, tree_root, confirmed_block))
 
@@ -38,6 +38,7 @@ class Withdrawal:
     nullifier: bytes
     amount: int
     fee: int
+    no_change: bool = False # a whole note withdrawal, inserting no change note
 
     def __repr__(self):
         return (
@@ -45,5 +46,6 @@ class Withdrawal:
             f"address={self.address!r}, "
             f"nullifier={format_bytes(self.nullifier)}, "
             f"amount={self.amount!r}, "
-            f"fee={self.fee!r})"
+            f"fee={self.fee!r}, "
+            f"no_change={self.no_change!r})"
         )
\ No newline at end of file
@@ -0,0 +1,155 @@
+package subscriber
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// txn types in the txns table
+const (
+	depositTxnType    = 0
+	withdrawalTxnType = 1
+)
+
+// openTxnsDb opens the txns database in read-write mode with WAL and creates the
+// tables if they don't exist already
+func openTxnsDb(txnsDbPath string) (*sql.DB, error) {
+	txnsDb, err := sql.Open("sqlite3", txnsDbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transactions database: %v", err)
+	}
+
+	createTables := `
+	CREATE TABLE IF NOT EXISTS txns (
+		leaf_index     INTEGER PRIMARY KEY,   -- inserted note index in onchain merkle tree
+		commitment     BLOB NOT NULL,         -- inserted note value in onchain merkle tree
+		txn_id         TEXT UNIQUE NOT NULL,  -- id of txn that inserted note (1st in group)
+		txn_type       INTEGER NOT NULL,      -- 0 for deposits, 1 for withdrawal
+		address        TEXT NOT NULL,         -- address making deposit or withdrawal
+		amount         INTEGER NOT NULL,      -- amount deposited or withdrawn
+		from_nullifier BLOB                   -- spent note nullifier (NULL for deposits)
+	) STRICT;
+
+	CREATE TABLE IF NOT EXISTS stats (
+		key   TEXT PRIMARY KEY,
+		value INTEGER
+	) STRICT;
+
+	INSERT OR IGNORE INTO stats (key, value) VALUES
+		('total_deposits', 0),
+		('total_withdrawals', 0),
+		('total_fees', 0),
+		('count_deposits', 0);
+
+	CREATE TABLE IF NOT EXISTS watermark (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		value INTEGER NOT NULL
+	) STRICT;
+
+	INSERT OR IGNORE INTO watermark (id, value) VALUES (1, 0);
+
+	CREATE TABLE IF NOT EXISTS roots (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		value BLOB NOT NULL,
+		leaf_count INTEGER NOT NULL
+	) STRICT;
+
+	INSERT OR IGNORE INTO roots (id, value, leaf_count) VALUES (1, x'', 0);
+
+	CREATE INDEX IF NOT EXISTS idx_txns_commitment ON txns(commitment);
+	`
+
+	// Enable WAL so that the frontend can read while we write
+	_, err = txnsDb.Exec("PRAGMA journal_mode = WAL")
+	if err != nil {
+		txnsDb.Close()
+		return nil, fmt.Errorf("failed to enable WAL: %v", err)
+	}
+	// Set busy timeout to 5000ms (5 seconds) to reduce "database is locked" errors
+	_, err = txnsDb.Exec("PRAGMA busy_timeout = 5000")
+	if err != nil {
+		txnsDb.Close()
+		return nil, fmt.Errorf("failed to set busy timeout: %v", err)
+	}
+	_, err = txnsDb.Exec(createTables)
+	if err != nil {
+		txnsDb.Close()
+		return nil, fmt.Errorf("failed to create tables: %v", err)
+	}
+	return txnsDb, nil
+}
+
+// getWatermark returns the last round processed
+func getWatermark(txnsDb *sql.DB) (uint64, error) {
+	var watermark uint64
+	err := txnsDb.QueryRow(`SELECT value FROM watermark WHERE id = 1`).Scan(&watermark)
+	return watermark, err
+}
+
+// setWatermark sets the last round processed
+func setWatermark(txnsDb *sql.DB, watermark uint64) error {
+	_, err := txnsDb.Exec(`UPDATE watermark SET value = ? WHERE id = 1`, watermark)
+	return err
+}
+
+// saveRound saves the txns of a round and sets the watermark to it, all or nothing.
+// No change withdrawals insert no note, so they only update the stats
+func saveRound(txnsDb *sql.DB, round uint64, txns []*txn) error {
+	tx, err := txnsDb.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, t := range txns {
+		if !t.noChange {
+			var fromNullifier any
+			if t.txnType == withdrawalTxnType {
+				fromNullifier = t.fromNullifier
+			}
+			_, err = tx.Exec(`INSERT INTO txns (leaf_index, commitment, txn_id, txn_type,
+				address, amount, from_nullifier) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				t.leafIndex, t.commitment, t.txnId, t.txnType, t.address, t.amount,
+				fromNullifier)
+			if err != nil {
+				return fmt.Errorf("failed to insert txn %s: %v", t.txnId, err)
+			}
+			_, err = tx.Exec(`UPDATE roots SET value = ?, leaf_count = ? WHERE id = 1`,
+				t.root[:], t.leafIndex+1)
+			if err != nil {
+				return fmt.Errorf("failed to update root: %v", err)
+			}
+		}
+
+		switch t.txnType {
+		case depositTxnType:
+			_, err = tx.Exec(`UPDATE stats SET value = value + ?
+				WHERE key = 'total_deposits'`, t.amount)
+			if err == nil {
+				_, err = tx.Exec(`UPDATE stats SET value = value + 1
+					WHERE key = 'count_deposits'`)
+			}
+		case withdrawalTxnType:
+			_, err = tx.Exec(`UPDATE stats SET value = value + ?
+				WHERE key = 'total_withdrawals'`, t.amount)
+			if err == nil {
+				_, err = tx.Exec(`UPDATE stats SET value = value + ?
+					WHERE key = 'total_fees'`, t.fee)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update stats: %v", err)
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE watermark SET value = ? WHERE id = 1`, round)
+	if err != nil {
+		return fmt.Errorf("failed to update watermark: %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit round %d: %v", round, err)
+	}
+	return nil
+}
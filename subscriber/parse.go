@@ -0,0 +1,147 @@
+package subscriber
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// txn is a deposit or withdrawal inserting a note in the merkle tree
+type txn struct {
+	leafIndex     uint64
+	commitment    []byte
+	txnId         string
+	txnType       int // depositTxnType or withdrawalTxnType
+	address       string
+	amount        uint64
+	fee           uint64 // only for withdrawals
+	fromNullifier []byte // only for withdrawals
+	// only for withdrawals, true if the whole note is withdrawn. Then no change note is
+	// inserted and leafIndex and root are those of the last leaf inserted before
+	noChange bool
+	root     [32]byte // the tree root after inserting the note
+}
+
+// parseDeposit parses a deposit app call.
+// The arc4 arg signature is (byte[32][],byte[32][],address), where the args are:
+//   - byte[32][] -> zk proof
+//   - byte[32][] -> zk public inputs: amount, commitment
+//   - address    -> address sending the deposit
+func parseDeposit(stxn *types.SignedTxnWithAD, txnId string) (*txn, error) {
+	args := stxn.Txn.ApplicationArgs
+	if len(args) < 4 {
+		return nil, fmt.Errorf("expected 4 args, got %d", len(args))
+	}
+	publicInputs := args[2]
+	amount, err := byte32(publicInputs, 0)
+	if err != nil {
+		return nil, err
+	}
+	commitment, err := byte32(publicInputs, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(args[3]) != len(types.Address{}) {
+		return nil, fmt.Errorf("invalid address length %d", len(args[3]))
+	}
+	var address types.Address
+	copy(address[:], args[3])
+
+	t := &txn{
+		commitment: commitment,
+		txnId:      txnId,
+		txnType:    depositTxnType,
+		address:    address.String(),
+		amount:     binary.BigEndian.Uint64(amount[24:]),
+	}
+	if err := setLeafIndexAndRoot(t, stxn); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// parseWithdrawal parses a withdrawal app call.
+// The arc4 arg signature is (byte[32][],byte[32][],account,account,bool), where the args are:
+//   - byte[32][] -> zk proof
+//   - byte[32][] -> zk public inputs: recipient, withdrawal amount, fee, commitment,
+//     nullifier, root
+//   - account    -> account receiving the withdrawal
+//   - account    -> account receiving the fee
+//   - bool       -> no change
+func parseWithdrawal(stxn *types.SignedTxnWithAD, txnId string) (*txn, error) {
+	args := stxn.Txn.ApplicationArgs
+	if len(args) < 6 {
+		return nil, fmt.Errorf("expected 6 args, got %d", len(args))
+	}
+	publicInputs := args[2]
+	amount, err := byte32(publicInputs, 1)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := byte32(publicInputs, 2)
+	if err != nil {
+		return nil, err
+	}
+	commitment, err := byte32(publicInputs, 3)
+	if err != nil {
+		return nil, err
+	}
+	nullifier, err := byte32(publicInputs, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	// the account arg is an index in the foreign accounts, 1-based since 0 is the sender
+	if len(args[3]) != 1 {
+		return nil, fmt.Errorf("invalid account arg length %d", len(args[3]))
+	}
+	accountPos := int(args[3][0]) - 1
+	accounts := stxn.Txn.Accounts
+	if accountPos < 0 || accountPos >= len(accounts) {
+		return nil, fmt.Errorf("account index %d out of range", accountPos+1)
+	}
+	// an arc4 bool is one byte with the value in the highest bit
+	if len(args[5]) != 1 {
+		return nil, fmt.Errorf("invalid no change arg length %d", len(args[5]))
+	}
+	noChange := args[5][0]&0x80 != 0
+
+	t := &txn{
+		commitment:    commitment,
+		txnId:         txnId,
+		txnType:       withdrawalTxnType,
+		address:       accounts[accountPos].String(),
+		amount:        binary.BigEndian.Uint64(amount[24:]),
+		fee:           binary.BigEndian.Uint64(fee[24:]),
+		fromNullifier: nullifier,
+		noChange:      noChange,
+	}
+	if err := setLeafIndexAndRoot(t, stxn); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// setLeafIndexAndRoot sets the leaf index and root of t from the app call return value
+func setLeafIndexAndRoot(t *txn, stxn *types.SignedTxnWithAD) error {
+	logs := stxn.EvalDelta.Logs
+	if len(logs) == 0 {
+		return fmt.Errorf("no logs in transaction")
+	}
+	var err error
+	t.leafIndex, t.root, err = avm.ParseLeafIndexAndRoot([]byte(logs[len(logs)-1]))
+	return err
+}
+
+// byte32 returns the byte[32] at position pos of an arc4 byte[32][] array
+func byte32(array []byte, pos int) ([]byte, error) {
+	// the first 2 bytes encode the length of the array
+	start := 2 + pos*32
+	if len(array) < start+32 {
+		return nil, fmt.Errorf("public inputs too short for input %d", pos)
+	}
+	return array[start : start+32], nil
+}
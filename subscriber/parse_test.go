@@ -0,0 +1,142 @@
+package subscriber
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// abiLog returns the app call return log with leafIndex and root
+func abiLog(leafIndex uint64, root byte) string {
+	log := make([]byte, 4+8+32)
+	binary.BigEndian.PutUint64(log[4:12], leafIndex)
+	log[12] = root
+	return string(log)
+}
+
+// publicInputs returns an arc4 byte[32][] array with inputs as the last byte of each
+// element
+func publicInputs(inputs ...byte) []byte {
+	array := binary.BigEndian.AppendUint16(nil, uint16(len(inputs)))
+	for _, input := range inputs {
+		array = append(array, make([]byte, 31)...)
+		array = append(array, input)
+	}
+	return array
+}
+
+func depositTxn(leafIndex uint64, commitment byte, amount byte) *types.SignedTxnWithAD {
+	stxn := &types.SignedTxnWithAD{}
+	address := types.Address{1}
+	stxn.Txn.ApplicationArgs = [][]byte{{}, {}, publicInputs(amount, commitment),
+		address[:]}
+	stxn.EvalDelta.Logs = []string{abiLog(leafIndex, commitment)}
+	return stxn
+}
+
+func withdrawalTxn(leafIndex uint64, commitment byte, amount byte, fee byte,
+	noChange bool) *types.SignedTxnWithAD {
+	stxn := &types.SignedTxnWithAD{}
+	noChangeArg := byte(0)
+	if noChange {
+		noChangeArg = 0x80
+	}
+	stxn.Txn.ApplicationArgs = [][]byte{{}, {},
+		publicInputs(0, amount, fee, commitment, commitment+100, 0),
+		{1}, {2}, {noChangeArg}}
+	stxn.Txn.Accounts = []types.Address{{2}, {3}}
+	stxn.EvalDelta.Logs = []string{abiLog(leafIndex, commitment)}
+	return stxn
+}
+
+func TestParseWithdrawalNoChange(t *testing.T) {
+	for _, noChange := range []bool{false, true} {
+		w, err := parseWithdrawal(withdrawalTxn(4, 9, 20, 1, noChange), "txn")
+		if err != nil {
+			t.Fatalf("noChange %v: %v", noChange, err)
+		}
+		if w.noChange != noChange {
+			t.Errorf("noChange: got %v, want %v", w.noChange, noChange)
+		}
+		if w.leafIndex != 4 || w.amount != 20 || w.fee != 1 {
+			t.Errorf("noChange %v: got leaf index %d, amount %d, fee %d", noChange,
+				w.leafIndex, w.amount, w.fee)
+		}
+	}
+
+	stxn := withdrawalTxn(4, 9, 20, 1, true)
+	stxn.Txn.ApplicationArgs = stxn.Txn.ApplicationArgs[:5]
+	if _, err := parseWithdrawal(stxn, "txn"); err == nil {
+		t.Errorf("expected an error for a withdrawal without the no change arg")
+	}
+}
+
+func TestSaveRoundNoChange(t *testing.T) {
+	txnsDb, err := openTxnsDb(filepath.Join(t.TempDir(), "txns.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txnsDb.Close()
+
+	deposit, err := parseDeposit(depositTxn(0, 1, 50), "deposit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveRound(txnsDb, 10, []*txn{deposit}); err != nil {
+		t.Fatal(err)
+	}
+	// the app returns the index and root of the deposit leaf for a no change withdrawal
+	noChange, err := parseWithdrawal(withdrawalTxn(0, 1, 45, 5, true), "noChange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveRound(txnsDb, 11, []*txn{noChange}); err != nil {
+		t.Fatalf("saving a no change withdrawal: %v", err)
+	}
+	withdrawal, err := parseWithdrawal(withdrawalTxn(1, 2, 1, 1, false), "change")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveRound(txnsDb, 12, []*txn{withdrawal}); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	if err := txnsDb.QueryRow(`SELECT COUNT(*) FROM txns`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("txns: got %d, want 2", count)
+	}
+	var root []byte
+	var leafCount uint64
+	err = txnsDb.QueryRow(`SELECT value, leaf_count FROM roots WHERE id = 1`).Scan(
+		&root, &leafCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leafCount != 2 || !bytes.Equal(root, withdrawal.root[:]) {
+		t.Errorf("roots: got leaf count %d, root %x", leafCount, root)
+	}
+	stats := map[string]uint64{"total_withdrawals": 46, "total_fees": 6}
+	for key, want := range stats {
+		var value uint64
+		err := txnsDb.QueryRow(`SELECT value FROM stats WHERE key = ?`, key).Scan(&value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != want {
+			t.Errorf("%s: got %d, want %d", key, value, want)
+		}
+	}
+	watermark, err := getWatermark(txnsDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if watermark != 12 {
+		t.Errorf("watermark: got %d, want 12", watermark)
+	}
+}
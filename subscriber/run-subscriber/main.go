@@ -0,0 +1,58 @@
+// This program runs the subscriber as its own service, reading the app deposits and
+// withdrawals from algod and saving them in the txns database read by the frontend.
+// It reads its configuration like the frontend server and accepts the same config flags.
+//
+// Use the --fastcatchup flag to move the watermark to the latest round, ignoring all
+// the transactions in between (only useful to start from scratch on a new deployment)
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/giuliop/HermesVault-frontend/avm"
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/subscriber"
+)
+
+func main() {
+	fastCatchup := flag.Bool("fastcatchup", false,
+		"set the watermark to the latest round, ignoring the transactions in between")
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if err := config.LoadFlags(flag.CommandLine); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if config.PrintConfig {
+		config.Print(os.Stdout)
+		return
+	}
+	algodClient, err := avm.NewAlgodClient(config.AlgodPath, config.AlgodToken)
+	if err != nil {
+		log.Fatalf("Error creating algod client: %v", err)
+	}
+	app, err := avm.LoadApp(config.AppSetupDirPath)
+	if err != nil {
+		log.Fatalf("Error loading app setup: %v", err)
+	}
+	appJson, err := avm.LoadAppJson(config.AppSetupDirPath)
+	if err != nil {
+		log.Fatalf("Error loading app setup: %v", err)
+	}
+
+	sub, err := subscriber.Open(config.TxnsDbPath, algodClient, app,
+		appJson.CreationBlock, *fastCatchup)
+	if err != nil {
+		log.Fatalf("Error opening subscriber: %v", err)
+	}
+	defer sub.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
+	sub.Run(ctx)
+}
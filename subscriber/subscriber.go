@@ -0,0 +1,196 @@
+// Package subscriber reads the app deposits and withdrawals from algod blocks and saves
+// them in the txns database read by the frontend
+package subscriber
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/models"
+
+	"github.com/algorand/go-algorand-sdk/v2/client/v2/algod"
+	"github.com/algorand/go-algorand-sdk/v2/crypto"
+	"github.com/algorand/go-algorand-sdk/v2/types"
+)
+
+// retryInterval is how long to wait before retrying after an error talking to algod
+// or writing to the database
+const retryInterval = 5 * time.Second
+
+// Subscriber polls algod for new blocks and saves the app deposits and withdrawals in
+// the txns database, together with the merkle root, the stats and the last round
+// processed (the watermark)
+type Subscriber struct {
+	algod              *algod.Client
+	appId              uint64
+	depositSelector    []byte
+	withdrawalSelector []byte
+	txnsDb             *sql.DB
+}
+
+// Open opens (creating it if needed) the txns database at txnsDbPath and returns a
+// subscriber for app, created at creationBlock.
+// If fastCatchup is true the watermark is moved to the latest round, ignoring all the
+// transactions in between; use it only to start from scratch on a new deployment
+func Open(txnsDbPath string, algodClient *algod.Client, app *models.App,
+	creationBlock uint64, fastCatchup bool) (*Subscriber, error) {
+
+	depositMethod, err := app.Schema.Contract.GetMethodByName(config.DepositMethodName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deposit method: %v", err)
+	}
+	withdrawalMethod, err := app.Schema.Contract.GetMethodByName(
+		config.WithDrawalMethodName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get withdrawal method: %v", err)
+	}
+
+	txnsDb, err := openTxnsDb(txnsDbPath)
+	if err != nil {
+		return nil, err
+	}
+	s := &Subscriber{
+		algod:              algodClient,
+		appId:              app.Id,
+		depositSelector:    depositMethod.GetSelector(),
+		withdrawalSelector: withdrawalMethod.GetSelector(),
+		txnsDb:             txnsDb,
+	}
+
+	watermark, err := getWatermark(txnsDb)
+	if err != nil {
+		txnsDb.Close()
+		return nil, fmt.Errorf("failed to get watermark: %v", err)
+	}
+	newWatermark := watermark
+	if fastCatchup {
+		status, err := algodClient.Status().Do(context.Background())
+		if err != nil {
+			txnsDb.Close()
+			return nil, fmt.Errorf("failed to get algod status: %v", err)
+		}
+		newWatermark = status.LastRound
+		log.Printf("Fast catchup mode enabled. Setting watermark to %d from %d. "+
+			"All transactions in between are ignored.", newWatermark, watermark)
+	} else if watermark < creationBlock {
+		newWatermark = creationBlock
+	}
+	if newWatermark != watermark {
+		if err := setWatermark(txnsDb, newWatermark); err != nil {
+			txnsDb.Close()
+			return nil, fmt.Errorf("failed to set watermark: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// Close closes the txns database
+func (s *Subscriber) Close() {
+	if err := s.txnsDb.Close(); err != nil {
+		log.Printf("Error closing transactions database: %v", err)
+	}
+}
+
+// Run processes the blocks after the watermark until ctx is canceled, waiting for new
+// blocks once it reaches the latest round. Errors are logged and retried
+func (s *Subscriber) Run(ctx context.Context) {
+	log.Printf("Subscriber started for app %d", s.appId)
+	for {
+		err := s.catchup(ctx)
+		if ctx.Err() != nil {
+			log.Println("Subscriber stopped")
+			return
+		}
+		if err != nil {
+			log.Printf("Subscriber error: %v", err)
+			select {
+			case <-time.After(retryInterval):
+			case <-ctx.Done():
+				log.Println("Subscriber stopped")
+				return
+			}
+		}
+	}
+}
+
+// catchup processes all the blocks from the watermark to the latest round, and then
+// waits for the next block
+func (s *Subscriber) catchup(ctx context.Context) error {
+	watermark, err := getWatermark(s.txnsDb)
+	if err != nil {
+		return fmt.Errorf("failed to get watermark: %v", err)
+	}
+	status, err := s.algod.Status().Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get algod status: %v", err)
+	}
+	for round := watermark + 1; round <= status.LastRound; round++ {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := s.processRound(ctx, round); err != nil {
+			return err
+		}
+	}
+	// StatusAfterBlock returns when a block after LastRound is available or after
+	// a timeout of about a minute
+	_, err = s.algod.StatusAfterBlock(status.LastRound).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to wait for block after %d: %v", status.LastRound, err)
+	}
+	return nil
+}
+
+// processRound saves the app deposits and withdrawals in round and moves the watermark
+// to it
+func (s *Subscriber) processRound(ctx context.Context, round uint64) error {
+	block, err := s.algod.Block(round).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get block %d: %v", round, err)
+	}
+	var txns []*txn
+	for _, stxn := range block.Payset {
+		if stxn.Txn.Type != types.ApplicationCallTx ||
+			uint64(stxn.Txn.ApplicationID) != s.appId ||
+			len(stxn.Txn.ApplicationArgs) == 0 {
+			continue
+		}
+		selector := stxn.Txn.ApplicationArgs[0]
+		isDeposit := bytes.Equal(selector, s.depositSelector)
+		isWithdrawal := bytes.Equal(selector, s.withdrawalSelector)
+		if !isDeposit && !isWithdrawal {
+			continue
+		}
+
+		txnId := getTxnId(&block, &stxn)
+		var t *txn
+		if isDeposit {
+			t, err = parseDeposit(&stxn.SignedTxnWithAD, txnId)
+		} else {
+			t, err = parseWithdrawal(&stxn.SignedTxnWithAD, txnId)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse txn %s in block %d: %v", txnId, round, err)
+		}
+		txns = append(txns, t)
+	}
+	return saveRound(s.txnsDb, round, txns)
+}
+
+// getTxnId returns the id of a transaction in a block. Blocks strip the genesis id and
+// hash from their transactions, which are needed to compute the id
+func getTxnId(block *types.Block, stxn *types.SignedTxnInBlock) string {
+	txn := stxn.Txn
+	if stxn.HasGenesisID {
+		txn.GenesisID = block.GenesisID
+	}
+	if stxn.HasGenesisHash || txn.GenesisHash == (types.Digest{}) {
+		txn.GenesisHash = block.GenesisHash
+	}
+	return crypto.GetTxID(txn)
+}
@@ -72,7 +72,7 @@ func saveAccountToFile(account crypto.Account) error {
 // transaction ID in the default filesDir directory
 func saveNoteToFile(note *models.Note) error {
 	filePath := filesDir + note.TxnID
-	err := os.WriteFile(filePath, []byte(note.Text()), 0644)
+	err := os.WriteFile(filePath, []byte(note.Text(client.App.Id)), 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write note to file: %w", err)
 	}
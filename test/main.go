@@ -3,14 +3,19 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"time"
 
+	"github.com/algorand/go-algorand-sdk/v2/client/v2/algod"
 	"github.com/algorand/go-algorand-sdk/v2/crypto"
 	"github.com/algorand/go-algorand-sdk/v2/transaction"
 	"github.com/giuliop/HermesVault-frontend/avm"
 	"github.com/giuliop/HermesVault-frontend/config"
+	"github.com/giuliop/HermesVault-frontend/db"
+	"github.com/giuliop/HermesVault-frontend/db/encrypt"
+	"github.com/giuliop/HermesVault-frontend/zkp"
 )
 
 const (
@@ -20,10 +25,52 @@ const (
 	filesDir = "files/"
 )
 
+var (
+	// store is where the notes of the test transactions are saved
+	store db.Store
+	// client sends the test transactions
+	client *avm.Client
+	// algodClient is the algod client used by client
+	algodClient *algod.Client
+)
+
 // Make one deposit and enough withdrawals to test the contract root management
 func main() {
 	rootCount := 50 // from deployed contract
 	txnsCountToTest := rootCount + 5
+
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if err := config.LoadFlags(flag.CommandLine); err != nil {
+		log.Fatalf("Error loading config: %s", err)
+	}
+	if err := encrypt.LoadPublicKey(config.PublicKeyPath); err != nil {
+		log.Fatalf("Error loading nullifier encryption key: %s", err)
+	}
+	var err error
+	algodClient, err = avm.NewAlgodClient(config.AlgodPath, config.AlgodToken)
+	if err != nil {
+		log.Fatalf("Error creating algod client: %s", err)
+	}
+	app, err := avm.LoadApp(config.AppSetupDirPath)
+	if err != nil {
+		log.Fatalf("Error loading app setup: %s", err)
+	}
+	sqlite, err := db.Open(config.InternalDbPath, config.TxnsDbPath, app.Id)
+	if err != nil {
+		log.Fatalf("Error opening databases: %s", err)
+	}
+	defer sqlite.Close()
+	store = sqlite
+	client, err = avm.NewClient(avm.NewAlgod(algodClient), app, store,
+		zkp.NewProver(1, 1))
+	if err != nil {
+		log.Fatalf("Error creating avm client: %s", err)
+	}
+	if err := client.InitMerkleTree(); err != nil {
+		log.Fatalf("Error initializing merkle tree: %s", err)
+	}
+
 	depositor, err := getAccountFromEncryptedFile(encryptedMenmonicFilePath)
 	if err != nil {
 		log.Fatalf("Error getting account from file: %s", err)
@@ -96,7 +143,7 @@ func main() {
 	}
 
 	expectedBalance := int(startBalance) -
-		config.DepositMinFeeMultiplier*transaction.MinTxnFee -
+		int(client.DepositFee()) - // the fee of the deposit group
 		len(accounts)*config.WithdrawalMinFee -
 		config.WithdrawalMinFee - // final withdrawal fee to depositor
 		len(accounts)*transaction.MinTxnFee // closeout fees
@@ -111,7 +158,7 @@ func main() {
 
 // getAccountBalance retrieves the balance of an Algorand account
 func getAccountBalance(address string) (uint64, error) {
-	account, err := avm.AlgodClient().AccountInformation(address).Do(context.Background())
+	account, err := algodClient.AccountInformation(address).Do(context.Background())
 	if err != nil {
 		return 0, fmt.Errorf("failed to get account information: %w", err)
 	}
@@ -120,9 +167,7 @@ func getAccountBalance(address string) (uint64, error) {
 
 // closeoutAccount closes out an Algorand account to a specified address
 func closeoutAccount(account crypto.Account, closeTo string) error {
-	algod := avm.AlgodClient()
-
-	sp, err := algod.SuggestedParams().Do(context.Background())
+	sp, err := algodClient.SuggestedParams().Do(context.Background())
 	if err != nil {
 		return fmt.Errorf("failed to get suggested params: %w", err)
 	}
@@ -144,12 +189,12 @@ func closeoutAccount(account crypto.Account, closeTo string) error {
 		return fmt.Errorf("failed to sign transaction: %w", err)
 	}
 
-	txnID, err := algod.SendRawTransaction(signedTxn).Do(context.Background())
+	txnID, err := algodClient.SendRawTransaction(signedTxn).Do(context.Background())
 	if err != nil {
 		return fmt.Errorf("failed to send transaction: %w", err)
 	}
 
-	_, err = transaction.WaitForConfirmation(algod, txnID, config.WaitRounds,
+	_, err = transaction.WaitForConfirmation(algodClient, txnID, config.WaitRounds,
 		context.Background())
 	return err
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 
@@ -8,7 +9,6 @@ import (
 
 	"github.com/giuliop/HermesVault-frontend/avm"
 	"github.com/giuliop/HermesVault-frontend/config"
-	"github.com/giuliop/HermesVault-frontend/db"
 	"github.com/giuliop/HermesVault-frontend/models"
 )
 
@@ -26,9 +26,9 @@ func sendDeposit(depositor *crypto.Account, amountMicroAlgo uint64,
 	if err != nil {
 		return nil, err
 	}
-	fmt.Printf("generated deposit note: %s\n", note.Text())
+	fmt.Printf("generated deposit note: %s\n", note.Text(client.App.Id))
 
-	txns, err := avm.CreateDepositTxns(amount, address, note)
+	txns, err := client.CreateDepositTxns(context.Background(), amount, address, note, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -39,16 +39,17 @@ func sendDeposit(depositor *crypto.Account, amountMicroAlgo uint64,
 	}
 
 	var confirmationError *avm.TxnConfirmationError
-	note.LeafIndex, note.TxnID, confirmationError = avm.SendDepositToNetwork(txns, signedTxn)
+	note.LeafIndex, note.TxnID, _, confirmationError = client.SendDepositToNetwork(txns,
+		signedTxn)
 	switch {
 	case confirmationError == nil:
-		if dbErr := db.SaveNote(note); dbErr != nil {
+		if dbErr := store.SaveNote(note); dbErr != nil {
 			log.Printf("failed to save deposit note in db: %v", dbErr)
 		}
 
 	case confirmationError.Type == avm.ErrWaitTimeout:
 		log.Printf("deposit %s confirmation timed out: %v", note.TxnID, confirmationError)
-		if _, dbErr := db.RegisterUnconfirmedNote(note); dbErr != nil {
+		if _, dbErr := store.RegisterUnconfirmedNote(note); dbErr != nil {
 			log.Printf("failed to register deposit unconfirmed note: %v", dbErr)
 		}
 
@@ -79,26 +80,26 @@ func sendWithdrawal(recipient string, amountMicroAlgo uint64, fromNote *models.N
 		FromNote:   fromNote,
 		ChangeNote: changeNote,
 	}
-	fmt.Printf("generated change note: %s\n", changeNote.Text())
+	fmt.Printf("generated change note: %s\n", changeNote.Text(client.App.Id))
 
-	txns, err := avm.CreateWithdrawalTxns(&w)
+	txns, err := client.CreateWithdrawalTxns(context.Background(), &w)
 	if err != nil {
 		return nil, err
 	}
 
 	var confirmationError *avm.TxnConfirmationError
-	changeNote.LeafIndex, changeNote.TxnID, confirmationError =
-		avm.SendWithdrawalToNetworkWithTSS(txns)
+	changeNote.LeafIndex, changeNote.TxnID, _, confirmationError =
+		client.SendWithdrawalToNetworkWithTSS(txns)
 
 	switch {
 	case confirmationError == nil:
-		if dbErr := db.SaveNote(changeNote); dbErr != nil {
+		if dbErr := store.SaveNote(changeNote); dbErr != nil {
 			log.Printf("failed to save change note in db: %v", dbErr)
 		}
 
 	case confirmationError.Type == avm.ErrWaitTimeout:
 		log.Printf("withdrawal %s confirmation timed out: %v", changeNote.TxnID, confirmationError)
-		if _, dbErr := db.RegisterUnconfirmedNote(changeNote); dbErr != nil {
+		if _, dbErr := store.RegisterUnconfirmedNote(changeNote); dbErr != nil {
 			log.Printf("failed to register change unconfirmed note: %v", dbErr)
 		}
 
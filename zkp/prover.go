@@ -0,0 +1,240 @@
+package zkp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/giuliop/HermesVault-frontend/metrics"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/giuliop/algoplonk"
+)
+
+// ErrProverClosed is returned proving with a closed Prover
+var ErrProverClosed = errors.New("prover closed")
+
+// BusyError is returned when the proof queue of a Prover is full
+type BusyError struct {
+	RetryAfter time.Duration // estimated wait before the queue has room again
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("prover busy, retry in %d seconds", e.RetrySeconds())
+}
+
+// RetrySeconds returns RetryAfter rounded up to whole seconds, at least 1
+func (e *BusyError) RetrySeconds() int {
+	return max(1, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// initialProofDuration is the proof duration assumed to estimate waits before any proof
+// has been generated
+const initialProofDuration = 5 * time.Second
+
+// Prover generates zk proofs with a fixed number of workers, since each proof uses all
+// the cores available. Proofs waiting for a worker are queued, up to a maximum queue size
+// after which new proofs are refused with a BusyError
+type Prover struct {
+	mu          sync.Mutex
+	cond        *sync.Cond // signals the workers that the queue or closed changed
+	queue       []*proofJob
+	maxQueue    int
+	workers     int
+	avgDuration time.Duration // moving average of the proof durations
+	closed      bool
+	// prove generates a proof, ZkArgs but for the tests
+	prove func(frontend.Circuit, *algoplonk.CompiledCircuit) ([][]byte, error)
+}
+
+// proofJob is a proof queued or being generated
+type proofJob struct {
+	ctx        context.Context
+	circuit    string // the circuit name, for the metrics
+	assignment frontend.Circuit
+	cc         *algoplonk.CompiledCircuit
+	queuedAt   time.Time
+	done       chan proofResult // buffered, so the worker never blocks
+}
+
+type proofResult struct {
+	zkArgs [][]byte
+	err    error
+}
+
+// NewProver starts a Prover with the given number of workers and maximum queue size,
+// both at least 1
+func NewProver(workers, maxQueue int) *Prover {
+	p := &Prover{
+		maxQueue:    max(1, maxQueue),
+		workers:     max(1, workers),
+		avgDuration: initialProofDuration,
+		prove:       ZkArgs,
+	}
+	p.cond = sync.NewCond(&p.mu)
+	for range p.workers {
+		go p.work()
+	}
+	return p
+}
+
+// positionKey is the context key of the queue position callback
+type positionKey struct{}
+
+// WithQueuePosition returns a copy of ctx which makes Prove call onPosition with the
+// position of the proof in the queue when it is queued and each time it moves up.
+// Position 0 means the proof is being generated. onPosition must not block
+func WithQueuePosition(ctx context.Context, onPosition func(position int)) context.Context {
+	return context.WithValue(ctx, positionKey{}, onPosition)
+}
+
+// Prove queues the proof for the assignment of the compiled circuit cc and waits for it,
+// returning the zk args like ZkArgs. circuit names the circuit in the metrics.
+// If the queue is full it returns a *BusyError right away.
+// If ctx is done while the proof is queued, it is dropped and ctx.Err() is returned; a
+// proof already being generated completes but its result is discarded
+func (p *Prover) Prove(ctx context.Context, circuit string, assignment frontend.Circuit,
+	cc *algoplonk.CompiledCircuit) ([][]byte, error) {
+	job := &proofJob{
+		ctx:        ctx,
+		circuit:    circuit,
+		assignment: assignment,
+		cc:         cc,
+		queuedAt:   time.Now(),
+		done:       make(chan proofResult, 1),
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrProverClosed
+	}
+	if len(p.queue) >= p.maxQueue {
+		retryAfter := p.estimateWait(len(p.queue) + 1)
+		p.mu.Unlock()
+		metrics.ProverRejections.Inc(circuit)
+		return nil, &BusyError{RetryAfter: retryAfter}
+	}
+	p.queue = append(p.queue, job)
+	position := len(p.queue)
+	p.cond.Signal()
+	p.mu.Unlock()
+	reportPosition(ctx, position)
+
+	select {
+	case result := <-job.done:
+		return result.zkArgs, result.err
+	case <-ctx.Done():
+		p.mu.Lock()
+		dequeued := p.remove(job)
+		positions := p.positions()
+		p.mu.Unlock()
+		if dequeued {
+			notifyPositions(positions)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Queued returns the number of proofs waiting for a worker
+func (p *Prover) Queued() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue)
+}
+
+// Close stops the workers once they finish their current proof. Queued proofs fail with
+// ErrProverClosed
+func (p *Prover) Close() {
+	p.mu.Lock()
+	p.closed = true
+	queue := p.queue
+	p.queue = nil
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	for _, job := range queue {
+		job.done <- proofResult{err: ErrProverClosed}
+	}
+}
+
+// work generates the queued proofs one at a time until the prover is closed
+func (p *Prover) work() {
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		job := p.queue[0]
+		p.queue = p.queue[1:]
+		positions := p.positions()
+		p.mu.Unlock()
+
+		notifyPositions(positions)
+		if job.ctx.Err() != nil {
+			job.done <- proofResult{err: job.ctx.Err()}
+			continue
+		}
+		reportPosition(job.ctx, 0)
+		metrics.ProofQueueWait.ObserveSince(job.queuedAt, job.circuit)
+
+		start := time.Now()
+		zkArgs, err := p.prove(job.assignment, job.cc)
+		duration := time.Since(start)
+		metrics.ProofDuration.Observe(duration.Seconds(), job.circuit)
+
+		p.mu.Lock()
+		p.avgDuration = (4*p.avgDuration + duration) / 5
+		p.mu.Unlock()
+
+		job.done <- proofResult{zkArgs: zkArgs, err: err}
+	}
+}
+
+// estimateWait returns the estimated wait for a proof at the given queue position to
+// start being generated. It must be called with the lock held
+func (p *Prover) estimateWait(position int) time.Duration {
+	rounds := (position + p.workers - 1) / p.workers
+	return time.Duration(rounds) * p.avgDuration
+}
+
+// remove removes job from the queue, returning false if it was not queued.
+// It must be called with the lock held
+func (p *Prover) remove(job *proofJob) bool {
+	for i, queued := range p.queue {
+		if queued == job {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// positions returns the queued jobs with their position, to be notified once the lock is
+// released. It must be called with the lock held
+func (p *Prover) positions() map[*proofJob]int {
+	positions := make(map[*proofJob]int, len(p.queue))
+	for i, job := range p.queue {
+		positions[job] = i + 1
+	}
+	return positions
+}
+
+func notifyPositions(positions map[*proofJob]int) {
+	for job, position := range positions {
+		reportPosition(job.ctx, position)
+	}
+}
+
+// reportPosition calls the queue position callback of ctx, if any
+func reportPosition(ctx context.Context, position int) {
+	if onPosition, ok := ctx.Value(positionKey{}).(func(int)); ok {
+		onPosition(position)
+	}
+}
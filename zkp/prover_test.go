@@ -0,0 +1,195 @@
+package zkp
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/giuliop/algoplonk"
+)
+
+// stubProver returns a Prover whose proofs signal started when they begin and wait for
+// release to complete, returning the zk args {{1}}
+func stubProver(t *testing.T, workers, maxQueue int) (p *Prover, started chan struct{},
+	release chan struct{}) {
+	p = NewProver(workers, maxQueue)
+	started, release = make(chan struct{}, 10), make(chan struct{})
+	p.prove = func(frontend.Circuit, *algoplonk.CompiledCircuit) ([][]byte, error) {
+		started <- struct{}{}
+		<-release
+		return [][]byte{{1}}, nil
+	}
+	t.Cleanup(func() {
+		close(release)
+		p.Close()
+	})
+	return p, started, release
+}
+
+// positionRecorder records the queue positions reported for a proof
+type positionRecorder struct {
+	mu        sync.Mutex
+	positions []int
+}
+
+func (r *positionRecorder) record(position int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.positions = append(r.positions, position)
+}
+
+func (r *positionRecorder) get() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.positions...)
+}
+
+type proveResult struct {
+	zkArgs [][]byte
+	err    error
+}
+
+// prove runs p.Prove in the background with ctx, returning the channel of its result
+func prove(p *Prover, ctx context.Context) chan proveResult {
+	result := make(chan proveResult, 1)
+	go func() {
+		zkArgs, err := p.Prove(ctx, "test", nil, nil)
+		result <- proveResult{zkArgs, err}
+	}()
+	return result
+}
+
+// waitFor waits up to a second for cond to be true
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestProveQueueFull(t *testing.T) {
+	p, started, release := stubProver(t, 1, 1)
+	proving := prove(p, context.Background())
+	<-started
+	queued := prove(p, context.Background())
+	waitFor(t, "the second proof to be queued", func() bool { return p.Queued() == 1 })
+
+	_, err := p.Prove(context.Background(), "test", nil, nil)
+	var busy *BusyError
+	if !errors.As(err, &busy) {
+		t.Fatalf("expected a BusyError with the queue full, got %v", err)
+	}
+	// the refused proof would be second in the queue, two proofs of 5 seconds away
+	if busy.RetrySeconds() != 10 {
+		t.Errorf("retry in %d seconds, expected 10", busy.RetrySeconds())
+	}
+
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+	for _, result := range []chan proveResult{proving, queued} {
+		if r := <-result; r.err != nil || len(r.zkArgs) != 1 {
+			t.Errorf("unexpected result %v, %v", r.zkArgs, r.err)
+		}
+	}
+}
+
+func TestRetrySeconds(t *testing.T) {
+	for _, test := range []struct {
+		retryAfter time.Duration
+		seconds    int
+	}{
+		{0, 1},
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{10 * time.Second, 10},
+	} {
+		busy := &BusyError{RetryAfter: test.retryAfter}
+		if seconds := busy.RetrySeconds(); seconds != test.seconds {
+			t.Errorf("RetrySeconds of %v: got %d, want %d", test.retryAfter, seconds,
+				test.seconds)
+		}
+	}
+}
+
+// waitForPositions waits for the positions recorded by r to be positions
+func waitForPositions(t *testing.T, r *positionRecorder, positions ...int) {
+	t.Helper()
+	waitFor(t, "the queue positions", func() bool {
+		return slices.Equal(r.get(), positions)
+	})
+}
+
+func TestProveQueuePosition(t *testing.T) {
+	p, started, release := stubProver(t, 1, 2)
+	proving := prove(p, context.Background())
+	<-started
+	var second, third positionRecorder
+	queued1 := prove(p, WithQueuePosition(context.Background(), second.record))
+	waitForPositions(t, &second, 1)
+	queued2 := prove(p, WithQueuePosition(context.Background(), third.record))
+	waitForPositions(t, &third, 2)
+
+	release <- struct{}{}
+	<-proving
+	<-started
+	release <- struct{}{}
+	<-queued1
+	<-started
+	release <- struct{}{}
+	<-queued2
+
+	for _, test := range []struct {
+		name      string
+		recorder  *positionRecorder
+		positions []int
+	}{
+		{"second", &second, []int{1, 0}},
+		{"third", &third, []int{2, 1, 0}},
+	} {
+		if got := test.recorder.get(); !slices.Equal(got, test.positions) {
+			t.Errorf("%s proof positions %v, want %v", test.name, got, test.positions)
+		}
+	}
+}
+
+func TestProveCancelQueued(t *testing.T) {
+	p, started, release := stubProver(t, 1, 2)
+	proving := prove(p, context.Background())
+	<-started
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := prove(p, ctx)
+	waitFor(t, "the second proof to be queued", func() bool { return p.Queued() == 1 })
+	var last positionRecorder
+	queued := prove(p, WithQueuePosition(context.Background(), last.record))
+	waitForPositions(t, &last, 2)
+
+	cancel()
+	if r := <-canceled; !errors.Is(r.err, context.Canceled) {
+		t.Fatalf("expected the canceled proof to fail with context.Canceled, got %v", r.err)
+	}
+	if queued := p.Queued(); queued != 1 {
+		t.Errorf("%d proofs queued after the cancellation, expected 1", queued)
+	}
+	waitForPositions(t, &last, 2, 1)
+
+	release <- struct{}{}
+	<-proving
+	<-started
+	release <- struct{}{}
+	if r := <-queued; r.err != nil {
+		t.Errorf("the proof queued after the canceled one failed: %v", r.err)
+	}
+	if positions := last.get(); !slices.Equal(positions, []int{2, 1, 0}) {
+		t.Errorf("last proof positions %v, want [2 1 0]", positions)
+	}
+}